@@ -8,13 +8,18 @@ import (
 	"os"
 	"path/filepath"
 	"plugin"
+	"strconv"
 	"strings"
 
 	"github.com/kudzu-cms/kudzu/system/admin"
 	"github.com/kudzu-cms/kudzu/system/api"
 	"github.com/kudzu-cms/kudzu/system/api/analytics"
+	"github.com/kudzu-cms/kudzu/system/backup"
+	"github.com/kudzu-cms/kudzu/system/cfg"
 	"github.com/kudzu-cms/kudzu/system/db"
+	"github.com/kudzu-cms/kudzu/system/tenant"
 	"github.com/kudzu-cms/kudzu/system/tls"
+	"github.com/kudzu-cms/kudzu/system/webhook"
 )
 
 // ErrWrongOrMissingService informs a user that the services to run must be
@@ -22,8 +27,45 @@ import (
 var ErrWrongOrMissingService = errors.New("To execute 'kudzu serve', " +
 	"you must specify which service to run.")
 
+// ErrTenantDevHTTPS informs a user that self-signed HTTPS can't be served in
+// multi-tenant mode
+var ErrTenantDevHTTPS = errors.New("Self-signed HTTPS (--dev-https) is not " +
+	"supported in multi-tenant mode. Use --https, or terminate TLS in front of kudzu.")
+
+// ErrTenantDocs informs a user that the docs server isn't run in multi-tenant
+// mode
+var ErrTenantDocs = errors.New("The docs server (--docs) is not supported " +
+	"in multi-tenant mode.")
+
 // Run starts the project.
 func Run(bind string, port int, https bool, httpsport int, services []string, dev bool, devhttps bool, docs bool, docsport int) error {
+	// in multi-tenant mode, this process only routes requests to the worker
+	// processes serving each tenant, and serves HTTPS for them
+	if cfg.Tenant() == "" && tenant.Enabled() {
+		if devhttps {
+			return ErrTenantDevHTTPS
+		}
+
+		if docs {
+			return ErrTenantDocs
+		}
+
+		return tenant.Serve(bind, port, https, httpsport)
+	}
+
+	// a tenant worker listens privately for the multi-tenant front server, which
+	// is responsible for anything served publicly, including HTTPS
+	listen := fmt.Sprintf(":%d", port)
+	if cfg.Tenant() != "" {
+		p, err := strconv.Atoi(cfg.TenantPort())
+		if err != nil {
+			return fmt.Errorf("Invalid port for tenant %s: %s", cfg.Tenant(), err)
+		}
+
+		bind, port = "localhost", p
+		listen = fmt.Sprintf("localhost:%d", port)
+		https, devhttps, docs = false, false, false
+	}
 
 	pluginsPath := filepath.Join(".", ".plugins")
 	info, err := os.Stat(pluginsPath)
@@ -59,6 +101,9 @@ func Run(bind string, port int, https bool, httpsport int, services []string, de
 	// init search index
 	go db.InitSearchIndex()
 
+	// send webhooks for changes to content and uploads
+	webhook.Run()
+
 	// save the https port the system is listening on
 	err = db.PutConfig("https_port", fmt.Sprintf("%d", httpsport))
 	if err != nil {
@@ -98,7 +143,109 @@ func Run(bind string, port int, https bool, httpsport int, services []string, de
 
 	fmt.Printf("Server listening at http://%s:%d for HTTP requests...\n", bind, port)
 	fmt.Printf("\nVisit http://%s:%d/admin to get started.\n", bind, port)
-	return http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
+	return http.ListenAndServe(listen, nil)
+}
+
+// ProvisionTenant creates a new tenant served for the hosts provided when the
+// system runs in multi-tenant mode. Multi-tenant mode is enabled once the first
+// tenant has been provisioned.
+func ProvisionTenant(name string, hosts []string) error {
+	t, err := tenant.Provision(name, hosts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Provisioned tenant %s for %s with data in %s\n", t.Name, strings.Join(t.Hosts, ", "), t.DataDir)
+	fmt.Println("Restart kudzu to start serving the new tenant.")
+	return nil
+}
+
+// Compact rewrites the system and analytics databases into fresh files holding
+// only live data, reporting the size of each before and after. It should be run
+// while the server is stopped.
+func Compact() error {
+	db.Init()
+	defer db.Close()
+
+	analytics.Init()
+	defer analytics.Close()
+
+	stores := []struct {
+		name    string
+		size    func() (int64, error)
+		compact func() error
+	}{
+		{"system.db", db.Size, db.Compact},
+		{"analytics.db", analytics.Size, analytics.Compact},
+	}
+
+	for _, s := range stores {
+		before, err := s.size()
+		if err != nil {
+			return err
+		}
+
+		err = s.compact()
+		if err != nil {
+			return fmt.Errorf("Failed to compact %s: %s", s.name, err)
+		}
+
+		after, err := s.size()
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Compacted %s: %d bytes => %d bytes\n", s.name, before, after)
+	}
+
+	return nil
+}
+
+// Stats prints the number of keys and bytes used by each bucket in the system
+// and analytics databases
+func Stats() error {
+	db.Init()
+	defer db.Close()
+
+	analytics.Init()
+	defer analytics.Close()
+
+	stores := []struct {
+		name  string
+		stats func() ([]backup.BucketStats, error)
+	}{
+		{"system.db", db.Stats},
+		{"analytics.db", analytics.Stats},
+	}
+
+	for _, s := range stores {
+		stats, err := s.stats()
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(s.name)
+		for _, b := range stats {
+			fmt.Printf("  %-32s keys: %-8d inuse: %-10d alloc: %d\n", b.Name, b.Keys, b.Inuse, b.Alloc)
+		}
+	}
+
+	return nil
+}
+
+// RotateEncryptionKey re-encrypts the encrypted fields of all stored content
+// with the current encryption key, so previous keys can be retired
+func RotateEncryptionKey() error {
+	db.Init()
+	defer db.Close()
+
+	n, err := db.RotateEncryptionKey()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Re-encrypted %d items with the current encryption key\n", n)
+	return nil
 }
 
 func buildPlugins() {
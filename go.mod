@@ -3,10 +3,13 @@ module github.com/kudzu-cms/kudzu
 go 1.16
 
 require (
+	github.com/andybalholm/brotli v1.0.5
 	github.com/blevesearch/bleve v1.0.14
 	github.com/boltdb/bolt v1.3.1
 	github.com/gofrs/uuid v4.0.0+incompatible
 	github.com/gorilla/schema v1.2.0
+	github.com/graphql-go/graphql v0.8.1
+	github.com/klauspost/compress v1.15.15
 	github.com/nilslice/email v0.1.0
 	github.com/nilslice/jwt v1.0.0
 	github.com/tidwall/gjson v1.6.8
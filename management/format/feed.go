@@ -0,0 +1,22 @@
+package format
+
+import "time"
+
+// Feedable is implemented with the method FeedItem, which must return how an item
+// of the type appears in the RSS, Atom and JSON feeds of the type served by the
+// content API at /api/feed
+type Feedable interface {
+	FeedItem() FeedItem
+}
+
+// FeedItem is an entry in a feed. Link may be an absolute URL, or a path which is
+// resolved against the Site URL, or the URL the feed was requested from if the
+// Site URL isn't set. If Date is zero, the
+// item's timestamp is used.
+type FeedItem struct {
+	Title   string
+	Link    string
+	Summary string
+	Author  string
+	Date    time.Time
+}
@@ -8,11 +8,16 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"sort"
+	"time"
 
+	"github.com/kudzu-cms/kudzu/system/admin/apikey"
 	"github.com/kudzu-cms/kudzu/system/admin/user"
 	"github.com/kudzu-cms/kudzu/system/api/analytics"
+	"github.com/kudzu-cms/kudzu/system/backup"
 	"github.com/kudzu-cms/kudzu/system/db"
 	"github.com/kudzu-cms/kudzu/system/item"
+	"github.com/kudzu-cms/kudzu/system/webhook"
 )
 
 var startAdminHTML = `<!doctype html>
@@ -66,8 +71,11 @@ var mainAdminHTML = `
                     <div class="row collection-item">
                         <li><a class="col s12" href="/admin/configure"><i class="tiny left material-icons">settings</i>Configuration</a></li>
                         <li><a class="col s12" href="/admin/configure/users"><i class="tiny left material-icons">supervisor_account</i>Admin Users</a></li>
+                        <li><a class="col s12" href="/admin/configure/apikeys"><i class="tiny left material-icons">vpn_key</i>API Keys</a></li>
+                        <li><a class="col s12" href="/admin/configure/webhooks"><i class="tiny left material-icons">swap_calls</i>Webhooks</a></li>
                         <li><a class="col s12" href="/admin/uploads"><i class="tiny left material-icons">swap_vert</i>Uploads</a></li>
                         <li><a class="col s12" href="/admin/addons"><i class="tiny left material-icons">settings_input_svideo</i>Addons</a></li>
+                        <li><a class="col s12" href="/admin/maintenance"><i class="tiny left material-icons">storage</i>Maintenance</a></li>
                     </div>
                 </ul>
                 </div>
@@ -494,6 +502,300 @@ func UsersList(req *http.Request) ([]byte, error) {
 	return Admin(buf.Bytes())
 }
 
+// APIKeysList returns the admin view to create and revoke API keys. If token is
+// not empty, it is shown as the token of a key which has just been created.
+func APIKeysList(token string) ([]byte, error) {
+	html := `
+    <div class="card api-keys">
+        {{ if .Token }}
+        <div class="card-title">New API key:</div>
+        <div class="row">
+            <div class="col s9">
+                <p>Copy the token below and keep it somewhere safe, it will not be shown again. Clients send it in the header: <code>Authorization: Bearer &lt;token&gt;</code></p>
+                <input type="text" readonly value="{{ .Token }}" onclick="this.select()"/>
+            </div>
+        </div>
+        {{ end }}
+
+        <div class="card-title">Create an API key:</div>
+        <form class="row" enctype="multipart/form-data" action="/admin/configure/apikeys" method="post">
+            <div class="col s9">
+                <label class="active">Name</label>
+                <input type="text" name="name" value="" placeholder="A name to identify the client using the key"/>
+            </div>
+
+            <div class="col s9">
+                <label class="active">Expires in days (leave blank if the key should not expire)</label>
+                <input type="number" name="expires" min="1" value=""/>
+            </div>
+
+            <div class="col s9">
+                <table class="highlight">
+                    <thead>
+                        <tr>
+                            <th>Type</th>
+                            {{ range $.Scopes }}<th>{{ . }}</th>{{ end }}
+                        </tr>
+                    </thead>
+                    <tbody>
+                        {{ range $t := .Types }}
+                        <tr>
+                            <td>{{ if eq $t "*" }}All types{{ else }}{{ $t }}{{ end }}</td>
+                            {{ range $s := $.Scopes }}
+                            <td>
+                                <input type="checkbox" class="filled-in" id="scope-{{ $t }}-{{ $s }}" name="scope.{{ $t }}" value="{{ $s }}"/>
+                                <label for="scope-{{ $t }}-{{ $s }}"></label>
+                            </td>
+                            {{ end }}
+                        </tr>
+                        {{ end }}
+                    </tbody>
+                </table>
+            </div>
+
+            <div class="col s9">
+                <button class="btn waves-effect waves-light green right" type="submit">Create Key</button>
+            </div>
+        </form>
+
+        <div class="card-title">Revoke API Keys</div>
+        <ul class="api-keys row">
+            {{ range .Keys }}
+            <li class="col s9">
+                <strong>{{ .Name }}</strong> ({{ .ID }})
+                <form enctype="multipart/form-data" class="delete-api-key __kudzu right" action="/admin/configure/apikeys/delete" method="post">
+                    <span>Revoke</span>
+                    <input type="hidden" name="id" value="{{ .ID }}"/>
+                </form>
+                <div>
+                    {{ range $t, $s := .Scopes }}<div>{{ if eq $t "*" }}All types{{ else }}{{ $t }}{{ end }}: {{ range $i, $v := $s }}{{ if $i }}, {{ end }}{{ $v }}{{ end }}</div>{{ end }}
+                    <div>Created: {{ time .Created }}, expires: {{ if .Expires }}{{ time .Expires }}{{ if .Expired }} (expired){{ end }}{{ else }}never{{ end }}, last used: {{ if .LastUsed }}{{ time .LastUsed }}{{ else }}never{{ end }}</div>
+                </div>
+            </li>
+            {{ end }}
+        </ul>
+    </div>
+    `
+	script := `
+    <script>
+        $(function() {
+            var del = $('.delete-api-key.__kudzu span');
+            del.on('click', function(e) {
+                if (confirm("[kudzu] Please confirm:\n\nAre you sure you want to revoke this API key?\nClients using it will no longer have access.")) {
+                    $(e.target).parent().submit();
+                }
+            });
+        });
+    </script>
+    `
+	jj, err := db.APIKeyAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []apikey.Key
+	for i := range jj {
+		var k apikey.Key
+		err = json.Unmarshal(jj[i], &k)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, k)
+	}
+
+	types := []string{apikey.AllTypes}
+	for t := range item.Types {
+		types = append(types, t)
+	}
+	sort.Strings(types[1:])
+
+	funcs := template.FuncMap{
+		"time": func(ms int64) string {
+			return time.Unix(0, ms*int64(time.Millisecond)).UTC().Format("Jan 2, 2006 15:04 MST")
+		},
+	}
+
+	buf := &bytes.Buffer{}
+	tmpl := template.Must(template.New("apikeys").Funcs(funcs).Parse(html + script))
+	data := map[string]interface{}{
+		"Token":  token,
+		"Keys":   keys,
+		"Types":  types,
+		"Scopes": apikey.Scopes,
+	}
+
+	err = tmpl.Execute(buf, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return Admin(buf.Bytes())
+}
+
+// WebhooksList returns the admin view to create and delete webhooks, and to
+// browse and redeliver recent deliveries
+func WebhooksList() ([]byte, error) {
+	html := `
+    <div class="card webhooks">
+        <div class="card-title">Create a webhook:</div>
+        <form class="row" enctype="multipart/form-data" action="/admin/configure/webhooks" method="post">
+            <div class="col s9">
+                <label class="active">URL</label>
+                <input type="url" name="url" value="" placeholder="https://example.com/hooks/kudzu" required/>
+            </div>
+
+            <div class="col s9">
+                <label class="active">Secret (leave blank to generate one)</label>
+                <input type="text" name="secret" value="" placeholder="Used to sign each delivery with HMAC-SHA256"/>
+            </div>
+
+            <div class="col s9">
+                <label class="active">Types (leave all unchecked to send events for every type)</label>
+                <p>
+                {{ range $t := .Types }}
+                    <input type="checkbox" class="filled-in" id="type-{{ $t }}" name="types" value="{{ $t }}"/>
+                    <label for="type-{{ $t }}">{{ typeName $t }}</label>
+                {{ end }}
+                </p>
+            </div>
+
+            <div class="col s9">
+                <label class="active">Events (leave all unchecked to send every event)</label>
+                <p>
+                {{ range $e := .Events }}
+                    <input type="checkbox" class="filled-in" id="event-{{ $e }}" name="events" value="{{ $e }}"/>
+                    <label for="event-{{ $e }}">{{ $e }}</label>
+                {{ end }}
+                </p>
+            </div>
+
+            <div class="col s9">
+                <button class="btn waves-effect waves-light green right" type="submit">Create Webhook</button>
+            </div>
+        </form>
+
+        <div class="card-title">Webhooks</div>
+        <ul class="webhooks row">
+            {{ range .Hooks }}
+            <li class="col s9">
+                <strong>{{ .URL }}</strong> ({{ .ID }})
+                <form enctype="multipart/form-data" class="delete-webhook __kudzu right" action="/admin/configure/webhooks/delete" method="post">
+                    <span>Delete</span>
+                    <input type="hidden" name="id" value="{{ .ID }}"/>
+                </form>
+                <div>
+                    <div>Types: {{ if .Types }}{{ range $i, $t := .Types }}{{ if $i }}, {{ end }}{{ typeName $t }}{{ end }}{{ else }}All types{{ end }}</div>
+                    <div>Events: {{ if .Events }}{{ range $i, $e := .Events }}{{ if $i }}, {{ end }}{{ $e }}{{ end }}{{ else }}All events{{ end }}</div>
+                    <div>Secret: <input type="text" readonly value="{{ .Secret }}" onclick="this.select()"/></div>
+                    <div>Created: {{ time .Created }}</div>
+                </div>
+            </li>
+            {{ end }}
+        </ul>
+
+        <div class="card-title">Recent Deliveries</div>
+        <table class="highlight deliveries">
+            <thead>
+                <tr>
+                    <th>Delivery</th>
+                    <th>Event</th>
+                    <th>Status</th>
+                    <th>Attempts</th>
+                    <th>Last Attempt</th>
+                    <th></th>
+                </tr>
+            </thead>
+            <tbody>
+                {{ range .Deliveries }}
+                <tr>
+                    <td>
+                        <div>{{ .ID }}</div>
+                        <div class="grey-text">{{ .URL }}</div>
+                        {{ if .Redelivery }}<div class="grey-text">Redelivery of {{ .Redelivery }}</div>{{ end }}
+                    </td>
+                    <td>{{ .Event }} {{ typeName .Type }}{{ if .ContentID }} {{ .ContentID }}{{ end }}</td>
+                    <td>
+                        <div>{{ .Status }}{{ if .ResponseCode }} ({{ .ResponseCode }}){{ end }}</div>
+                        {{ if .Error }}<div class="red-text">{{ .Error }}</div>{{ end }}
+                        {{ if eq .Status "pending" }}{{ if .Attempts }}<div class="grey-text">Retry at {{ time .NextAttempt }}</div>{{ end }}{{ end }}
+                    </td>
+                    <td>{{ .Attempts }}</td>
+                    <td>{{ if .LastAttempt }}{{ time .LastAttempt }}{{ else }}never{{ end }}</td>
+                    <td>
+                        <form enctype="multipart/form-data" action="/admin/configure/webhooks/redeliver" method="post">
+                            <input type="hidden" name="id" value="{{ .ID }}"/>
+                            <button class="btn-flat waves-effect" type="submit">Redeliver</button>
+                        </form>
+                    </td>
+                </tr>
+                {{ end }}
+            </tbody>
+        </table>
+    </div>
+    `
+	script := `
+    <script>
+        $(function() {
+            var del = $('.delete-webhook.__kudzu span');
+            del.on('click', function(e) {
+                if (confirm("[kudzu] Please confirm:\n\nAre you sure you want to delete this webhook?\nIts pending deliveries will not be sent.")) {
+                    $(e.target).parent().submit();
+                }
+            });
+        });
+    </script>
+    `
+	hooks, err := webhook.All()
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries, err := webhook.Deliveries(50)
+	if err != nil {
+		return nil, err
+	}
+
+	types := []string{webhook.AllTypes}
+	for t := range item.Types {
+		types = append(types, t)
+	}
+	sort.Strings(types[1:])
+	types = append(types, webhook.UploadsType)
+
+	funcs := template.FuncMap{
+		"time": func(ms int64) string {
+			return time.Unix(0, ms*int64(time.Millisecond)).UTC().Format("Jan 2, 2006 15:04:05 MST")
+		},
+		"typeName": func(t string) string {
+			switch t {
+			case webhook.AllTypes:
+				return "All types"
+			case webhook.UploadsType:
+				return "Uploads"
+			}
+
+			return t
+		},
+	}
+
+	buf := &bytes.Buffer{}
+	tmpl := template.Must(template.New("webhooks").Funcs(funcs).Parse(html + script))
+	data := map[string]interface{}{
+		"Hooks":      hooks,
+		"Deliveries": deliveries,
+		"Types":      types,
+		"Events":     webhook.Events,
+	}
+
+	err = tmpl.Execute(buf, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return Admin(buf.Bytes())
+}
+
 var analyticsHTML = `
 <div class="analytics">
 <div class="card">
@@ -525,6 +827,14 @@ var analyticsHTML = `
                 backgroundColor: 'rgba(33, 150, 243, 0.2)',
                 borderColor: 'rgba(33, 150, 243, 1)',
                 borderWidth: 1
+            },
+            {
+                type: 'line',
+                label: 'Rate Limited',
+                data: $.parseJSON({{ .limited }}),
+                backgroundColor: 'rgba(244, 67, 54, 0.2)',
+                borderColor: 'rgba(244, 67, 54, 1)',
+                borderWidth: 1
             }]
         },
         options: {
@@ -559,6 +869,106 @@ func Dashboard() ([]byte, error) {
 	return Admin(buf.Bytes())
 }
 
+var maintenanceHTML = `
+<div class="maintenance">
+{{ range .Stores }}
+<div class="card">
+<div class="card-content">
+    <form class="right" action="/admin/maintenance" method="post">
+        <input type="hidden" name="source" value="{{ .Source }}"/>
+        <button class="btn waves-effect waves-light compact-db" type="submit">Compact</button>
+    </form>
+    <div class="card-title">{{ .File }} <span class="grey-text">({{ .Size }} on disk)</span></div>
+    <table class="striped">
+        <thead>
+            <tr><th>Bucket</th><th>Keys</th><th>In Use</th><th>Allocated</th></tr>
+        </thead>
+        <tbody>
+        {{ range .Buckets }}
+            <tr><td>{{ .Name }}</td><td>{{ .Keys }}</td><td>{{ .Inuse }}</td><td>{{ .Alloc }}</td></tr>
+        {{ end }}
+        </tbody>
+    </table>
+</div>
+</div>
+{{ end }}
+</div>
+<script>
+    $(function() {
+        $('.compact-db').on('click', function(e) {
+            if (!confirm("[kudzu] Please confirm:\n\nCompacting locks the database while it is copied, so API and admin requests will wait until it completes.")) {
+                e.preventDefault();
+            }
+        });
+    });
+</script>
+`
+
+type maintenanceStore struct {
+	Source  string
+	File    string
+	Size    string
+	Buckets []maintenanceBucket
+}
+
+type maintenanceBucket struct {
+	Name  string
+	Keys  int
+	Inuse string
+	Alloc string
+}
+
+// Maintenance returns the admin view with a per-bucket size and key count
+// report of the system and analytics databases, and actions to compact them
+func Maintenance() ([]byte, error) {
+	systemStats, err := db.Stats()
+	if err != nil {
+		return nil, err
+	}
+
+	systemSize, err := db.Size()
+	if err != nil {
+		return nil, err
+	}
+
+	analyticsStats, err := analytics.Stats()
+	if err != nil {
+		return nil, err
+	}
+
+	analyticsSize, err := analytics.Size()
+	if err != nil {
+		return nil, err
+	}
+
+	stores := []maintenanceStore{
+		{Source: "system", File: "system.db", Size: item.FmtBytes(float64(systemSize))},
+		{Source: "analytics", File: "analytics.db", Size: item.FmtBytes(float64(analyticsSize))},
+	}
+
+	for i, stats := range [][]backup.BucketStats{systemStats, analyticsStats} {
+		for _, s := range stats {
+			stores[i].Buckets = append(stores[i].Buckets, maintenanceBucket{
+				Name:  s.Name,
+				Keys:  s.Keys,
+				Inuse: item.FmtBytes(float64(s.Inuse)),
+				Alloc: item.FmtBytes(float64(s.Alloc)),
+			})
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	tmpl := template.Must(template.New("maintenance").Parse(maintenanceHTML))
+	err = tmpl.Execute(buf, map[string]interface{}{
+		"Stores": stores,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return Admin(buf.Bytes())
+}
+
 var err400HTML = []byte(`
 <div class="error-page e400 col s6">
 <div class="card">
@@ -0,0 +1,164 @@
+// Package apikey contains the API keys issued by admin users to machine clients
+// of the content API, and the scopes which limit what each key may do.
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	// ScopeRead allows a key to read content of a type
+	ScopeRead = "read"
+
+	// ScopeCreate allows a key to create content of a type
+	ScopeCreate = "create"
+
+	// ScopeUpdate allows a key to update content of a type
+	ScopeUpdate = "update"
+
+	// ScopeDelete allows a key to delete content of a type
+	ScopeDelete = "delete"
+
+	// ScopeReadHidden allows a key to read content of a type which implements
+	// item.Hideable, as if Hide had returned item.ErrAllowHiddenItem
+	ScopeReadHidden = "read-hidden"
+
+	// AllTypes can be used in place of a type name to grant scopes for every type
+	AllTypes = "*"
+
+	// tokenPrefix begins every token, so kudzu API keys can be told apart from
+	// other Bearer tokens a system may use
+	tokenPrefix = "kudzu_"
+)
+
+// Scopes are the operations which may be granted to a key for each type
+var Scopes = []string{ScopeRead, ScopeCreate, ScopeUpdate, ScopeDelete, ScopeReadHidden}
+
+// ErrInvalidToken is returned when parsing a token which is not a kudzu API key
+var ErrInvalidToken = errors.New("Invalid API key token")
+
+// Key defines an API key in the system. The key's secret is only available as
+// part of the token returned when the key is created, and is stored as a hash.
+type Key struct {
+	ID       string              `json:"id"`
+	Name     string              `json:"name"`
+	Hash     string              `json:"hash"`
+	Scopes   map[string][]string `json:"scopes"`
+	Created  int64               `json:"created"`
+	Expires  int64               `json:"expires"`
+	LastUsed int64               `json:"last_used"`
+}
+
+type contextKey struct{}
+
+// New creates a key with the scopes provided for each type name (or AllTypes),
+// and returns it along with the token clients use to authenticate. A zero
+// expires time creates a key which never expires.
+func New(name string, scopes map[string][]string, expires time.Time) (*Key, string, error) {
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return nil, "", err
+	}
+
+	k := &Key{
+		ID:      hex.EncodeToString(id),
+		Name:    name,
+		Scopes:  scopes,
+		Created: millis(time.Now()),
+	}
+
+	if !expires.IsZero() {
+		k.Expires = millis(expires)
+	}
+
+	s := base64.RawURLEncoding.EncodeToString(secret)
+	k.Hash = hash(s)
+
+	return k, tokenPrefix + k.ID + "_" + s, nil
+}
+
+// IsToken reports whether a Bearer token is formatted as a kudzu API key
+func IsToken(token string) bool {
+	return strings.HasPrefix(token, tokenPrefix)
+}
+
+// Parse splits a token into the ID of its key and its secret
+func Parse(token string) (string, string, error) {
+	if !IsToken(token) {
+		return "", "", ErrInvalidToken
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(token, tokenPrefix), "_", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", ErrInvalidToken
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// Verify checks that the secret from a token matches the key, and that the key
+// has not expired
+func (k *Key) Verify(secret string) bool {
+	if k.Expired() {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(hash(secret)), []byte(k.Hash)) == 1
+}
+
+// Expired reports whether the key has passed its expiry time
+func (k *Key) Expired() bool {
+	return k.Expires != 0 && millis(time.Now()) >= k.Expires
+}
+
+// Allows reports whether the key has been granted the scope for the type
+func (k *Key) Allows(typeName, scope string) bool {
+	for _, t := range []string{typeName, AllTypes} {
+		for _, s := range k.Scopes[t] {
+			if s == scope {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// NewContext returns a copy of ctx carrying the key a request was authenticated with
+func NewContext(ctx context.Context, k *Key) context.Context {
+	return context.WithValue(ctx, contextKey{}, k)
+}
+
+// FromContext returns the key a request was authenticated with, or nil if the
+// request was not made with an API key. Hooks and API interfaces can use it to
+// identify machine clients, for example:
+//
+//	k := apikey.FromContext(req.Context())
+func FromContext(ctx context.Context) *Key {
+	k, _ := ctx.Value(contextKey{}).(*Key)
+	return k
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
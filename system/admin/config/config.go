@@ -14,6 +14,7 @@ type Config struct {
 
 	Name                    string   `json:"name"`
 	Domain                  string   `json:"domain"`
+	SiteURL                 string   `json:"site_url"`
 	BindAddress             string   `json:"bind_addr"`
 	HTTPPort                string   `json:"http_port"`
 	HTTPSPort               string   `json:"https_port"`
@@ -21,15 +22,45 @@ type Config struct {
 	ClientSecret            string   `json:"client_secret"`
 	Etag                    string   `json:"etag"`
 	DisableCORS             bool     `json:"cors_disabled"`
+	CORSAllowedOrigins      string   `json:"cors_allowed_origins"`
+	CORSAllowedMethods      string   `json:"cors_allowed_methods"`
+	CORSAllowedHeaders      string   `json:"cors_allowed_headers"`
+	CORSExposedHeaders      string   `json:"cors_exposed_headers"`
+	CORSAllowCredentials    bool     `json:"cors_allow_credentials"`
+	CORSMaxAge              int64    `json:"cors_max_age"`
 	DisableGZIP             bool     `json:"gzip_disabled"`
+	CompressMinSize         int64    `json:"compress_min_size"`
+	CompressTypes           string   `json:"compress_types"`
 	DisableHTTPCache        bool     `json:"cache_disabled"`
 	CacheMaxAge             int64    `json:"cache_max_age"`
 	CacheInvalidate         []string `json:"cache"`
+	ResponseCacheSize       int64    `json:"response_cache_size"`
+	ChangeRetentionDays     int64    `json:"change_retention_days"`
+	SitemapURLPattern       string   `json:"sitemap_url_pattern"`
+	SitemapExclude          string   `json:"sitemap_exclude"`
+	RateLimitIP             int64    `json:"rate_limit_ip"`
+	RateLimitKey            int64    `json:"rate_limit_key"`
+	RateLimitRules          string   `json:"rate_limit_rules"`
 	BackupBasicAuthUser     string   `json:"backup_basic_auth_user"`
 	BackupBasicAuthPassword string   `json:"backup_basic_auth_password"`
 }
 
 const (
+	corsInfo = `
+		<p class="flow-text">Cross-Origin Requests (CORS):</p>
+		<p>Choose which websites may fetch your data from the API in a browser. Origins are listed one per line, as a domain like example.com, optionally with a scheme and port like https://example.com:8080, or with a wildcard like *.example.com for its subdomains. Leave the list empty to allow any origin.</p>
+	`
+
+	sitemapInfo = `
+		<p class="flow-text">Sitemap:</p>
+		<p>Public content with a slug is listed at /sitemap.xml for search engines. Each item's URL is made from the pattern below, where {type}, {slug} and {id} are replaced by the item's content type in lowercase, slug and ID, unless its content type defines its own.</p>
+	`
+
+	rateLimitInfo = `
+		<p class="flow-text">API Rate Limits:</p>
+		<p>Limit the number of API requests each client may make per minute, where 0 is unlimited. Clients making requests with an API key are limited by key, and other clients by IP address.</p>
+	`
+
 	dbBackupInfo = `
 		<p class="flow-text">Database Backup Credentials:</p>
 		<p>Add a user name and password to download a backup of your data via HTTP.</p>
@@ -54,6 +85,12 @@ func (c *Config) MarshalEditor() ([]byte, error) {
 				"placeholder": "e.g. www.example.com or example.com",
 			}),
 		},
+		editor.Field{
+			View: editor.Input("SiteURL", c, map[string]string{
+				"label":       "Site URL, used for links in feeds and the sitemap (empty = the URL each was requested from)",
+				"placeholder": "e.g. https://www.example.com",
+			}),
+		},
 		editor.Field{
 			View: editor.Input("BindAddress", c, map[string]string{
 				"type": "hidden",
@@ -96,18 +133,74 @@ func (c *Config) MarshalEditor() ([]byte, error) {
 				"type": "hidden",
 			}),
 		},
+		editor.Field{
+			View: []byte(corsInfo),
+		},
 		editor.Field{
 			View: editor.Checkbox("DisableCORS", c, map[string]string{
-				"label": "Disable CORS (so only " + c.Domain + " can fetch your data)",
+				"label": "Disable CORS (so only " + c.Domain + " can fetch your data, ignoring the allowed origins)",
 			}, map[string]string{
 				"true": "Disable CORS",
 			}),
 		},
+		editor.Field{
+			View: editor.Textarea("CORSAllowedOrigins", c, map[string]string{
+				"label":       "Allowed origins, one per line (empty = any origin)",
+				"placeholder": "e.g. https://example.com or *.example.com",
+			}),
+		},
+		editor.Field{
+			View: editor.Input("CORSAllowedMethods", c, map[string]string{
+				"label":       "Allowed methods (empty = GET, POST, PUT, PATCH, DELETE, OPTIONS)",
+				"placeholder": "e.g. GET, POST, OPTIONS",
+				"type":        "text",
+			}),
+		},
+		editor.Field{
+			View: editor.Input("CORSAllowedHeaders", c, map[string]string{
+				"label":       "Allowed request headers (empty = Accept, Authorization, Content-Type, * = any requested)",
+				"placeholder": "e.g. Accept, Authorization, Content-Type, If-None-Match",
+				"type":        "text",
+			}),
+		},
+		editor.Field{
+			View: editor.Input("CORSExposedHeaders", c, map[string]string{
+				"label":       "Response headers exposed to scripts",
+				"placeholder": "e.g. ETag, Retry-After",
+				"type":        "text",
+			}),
+		},
+		editor.Field{
+			View: editor.Checkbox("CORSAllowCredentials", c, map[string]string{
+				"label": "Allow credentials (cookies and HTTP authentication) in cross-origin requests from the allowed origins, if listed",
+			}, map[string]string{
+				"true": "Allow Credentials",
+			}),
+		},
+		editor.Field{
+			View: editor.Input("CORSMaxAge", c, map[string]string{
+				"label": "Seconds browsers may cache preflight responses (0 = browser default)",
+				"type":  "text",
+			}),
+		},
 		editor.Field{
 			View: editor.Checkbox("DisableGZIP", c, map[string]string{
-				"label": "Disable GZIP (will increase server speed, but also bandwidth)",
+				"label": "Disable compression with Brotli, Zstandard and GZIP (will increase server speed, but also bandwidth)",
 			}, map[string]string{
-				"true": "Disable GZIP",
+				"true": "Disable Compression",
+			}),
+		},
+		editor.Field{
+			View: editor.Input("CompressMinSize", c, map[string]string{
+				"label": "Smallest response compressed (in bytes, 0 = 1024)",
+				"type":  "text",
+			}),
+		},
+		editor.Field{
+			View: editor.Input("CompressTypes", c, map[string]string{
+				"label":       "Media types compressed, where * matches any part (empty = text/*, application/json, application/*+json, application/javascript, application/xml, application/*+xml, image/svg+xml)",
+				"placeholder": "e.g. text/*, application/json",
+				"type":        "text",
 			}),
 		},
 		editor.Field{
@@ -130,6 +223,56 @@ func (c *Config) MarshalEditor() ([]byte, error) {
 				"invalidate": "Invalidate Cache",
 			}),
 		},
+		editor.Field{
+			View: editor.Input("ResponseCacheSize", c, map[string]string{
+				"label": "Memory for cached API responses of content types which allow it (in megabytes, 0 = disabled)",
+				"type":  "text",
+			}),
+		},
+		editor.Field{
+			View: editor.Input("ChangeRetentionDays", c, map[string]string{
+				"label": "Days to keep entries in the content change feed (0 = 30)",
+				"type":  "text",
+			}),
+		},
+		editor.Field{
+			View: []byte(sitemapInfo),
+		},
+		editor.Field{
+			View: editor.Input("SitemapURLPattern", c, map[string]string{
+				"label":       "URL of each item in the sitemap, as a path or full URL (empty = /{type}/{slug})",
+				"placeholder": "e.g. /blog/{slug}",
+				"type":        "text",
+			}),
+		},
+		editor.Field{
+			View: editor.Input("SitemapExclude", c, map[string]string{
+				"label":       "Content types left out of the sitemap",
+				"placeholder": "e.g. Review, Product",
+				"type":        "text",
+			}),
+		},
+		editor.Field{
+			View: []byte(rateLimitInfo),
+		},
+		editor.Field{
+			View: editor.Input("RateLimitIP", c, map[string]string{
+				"label": "Requests per minute for each IP address (0 = unlimited)",
+				"type":  "text",
+			}),
+		},
+		editor.Field{
+			View: editor.Input("RateLimitKey", c, map[string]string{
+				"label": "Requests per minute for each API key (0 = unlimited)",
+				"type":  "text",
+			}),
+		},
+		editor.Field{
+			View: editor.Textarea("RateLimitRules", c, map[string]string{
+				"label":       "Limits for routes or content types, one per line as: route or type, requests per minute for each IP address, requests per minute for each API key",
+				"placeholder": "e.g. /api/content/create 10 60",
+			}),
+		},
 		editor.Field{
 			View: []byte(dbBackupInfo),
 		},
@@ -16,6 +16,7 @@ import (
 	"github.com/kudzu-cms/kudzu/management/format"
 	"github.com/kudzu-cms/kudzu/management/manager"
 	"github.com/kudzu-cms/kudzu/system/addon"
+	"github.com/kudzu-cms/kudzu/system/admin/apikey"
 	"github.com/kudzu-cms/kudzu/system/admin/config"
 	"github.com/kudzu-cms/kudzu/system/admin/upload"
 	"github.com/kudzu-cms/kudzu/system/admin/user"
@@ -24,6 +25,7 @@ import (
 	"github.com/kudzu-cms/kudzu/system/db"
 	"github.com/kudzu-cms/kudzu/system/item"
 	"github.com/kudzu-cms/kudzu/system/search"
+	"github.com/kudzu-cms/kudzu/system/webhook"
 
 	"github.com/gorilla/schema"
 	emailer "github.com/nilslice/email"
@@ -238,6 +240,69 @@ func backupHandler(res http.ResponseWriter, req *http.Request) {
 	}
 }
 
+func maintenanceHandler(res http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		view, err := Maintenance()
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		res.Header().Set("Content-Type", "text/html")
+		res.Write(view)
+
+	case http.MethodPost:
+		err := req.ParseForm()
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		switch req.FormValue("source") {
+		case "system":
+			err = db.Compact()
+
+		case "analytics":
+			err = analytics.Compact()
+
+		default:
+			res.WriteHeader(http.StatusBadRequest)
+			errView, err := Error400()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+		if err != nil {
+			log.Println("Failed to compact", req.FormValue("source"), "database:", err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		http.Redirect(res, req, req.URL.String(), http.StatusFound)
+
+	default:
+		res.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
 func configUsersHandler(res http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
@@ -524,6 +589,342 @@ func configUsersDeleteHandler(res http.ResponseWriter, req *http.Request) {
 	}
 }
 
+func configAPIKeysHandler(res http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		view, err := APIKeysList("")
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		res.Write(view)
+
+	case http.MethodPost:
+		// create new API key
+		err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		name := strings.TrimSpace(req.PostFormValue("name"))
+		if name == "" {
+			res.WriteHeader(http.StatusBadRequest)
+			errView, err := Error400()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		// scopes are posted as scope.{type} for each type, including "*"
+		scopes := make(map[string][]string)
+		for k, v := range req.PostForm {
+			if !strings.HasPrefix(k, "scope.") {
+				continue
+			}
+
+			t := strings.TrimPrefix(k, "scope.")
+			if _, ok := item.Types[t]; !ok && t != apikey.AllTypes {
+				continue
+			}
+
+			scopes[t] = v
+		}
+
+		var expires time.Time
+		if days := req.PostFormValue("expires"); days != "" {
+			d, err := strconv.Atoi(days)
+			if err != nil || d < 1 {
+				res.WriteHeader(http.StatusBadRequest)
+				errView, err := Error400()
+				if err != nil {
+					return
+				}
+
+				res.Write(errView)
+				return
+			}
+
+			expires = time.Now().AddDate(0, 0, d)
+		}
+
+		k, token, err := apikey.New(name, scopes, expires)
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		err = db.SetAPIKey(k)
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		// the token is only available now, so render it rather than redirecting
+		view, err := APIKeysList(token)
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		res.Header().Set("Cache-Control", "no-store")
+		res.Write(view)
+
+	default:
+		res.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func configAPIKeysDeleteHandler(res http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		err = db.DeleteAPIKey(req.PostFormValue("id"))
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		http.Redirect(res, req, strings.TrimSuffix(req.URL.String(), "/delete"), http.StatusFound)
+
+	default:
+		res.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func configWebhooksHandler(res http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		view, err := WebhooksList()
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		res.Write(view)
+
+	case http.MethodPost:
+		// create new webhook
+		err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		var types []string
+		for _, t := range req.PostForm["types"] {
+			if _, ok := item.Types[t]; !ok && t != webhook.AllTypes && t != webhook.UploadsType {
+				continue
+			}
+
+			types = append(types, t)
+		}
+
+		var events []string
+		for _, e := range req.PostForm["events"] {
+			for _, ev := range webhook.Events {
+				if e == ev {
+					events = append(events, e)
+				}
+			}
+		}
+
+		w, err := webhook.New(
+			strings.TrimSpace(req.PostFormValue("url")),
+			types,
+			events,
+			strings.TrimSpace(req.PostFormValue("secret")),
+		)
+		if err == webhook.ErrInvalidURL {
+			res.WriteHeader(http.StatusBadRequest)
+			errView, err := Error400()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		err = db.SetWebhook(w.ID, w)
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		http.Redirect(res, req, req.URL.String(), http.StatusFound)
+
+	default:
+		res.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func configWebhooksDeleteHandler(res http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		err = db.DeleteWebhook(req.PostFormValue("id"))
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		http.Redirect(res, req, strings.TrimSuffix(req.URL.String(), "/delete"), http.StatusFound)
+
+	default:
+		res.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func configWebhooksRedeliverHandler(res http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		_, err = webhook.Redeliver(req.PostFormValue("id"))
+		if err == db.ErrNoWebhookDeliveryExists {
+			res.WriteHeader(http.StatusNotFound)
+			errView, err := Error404()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		http.Redirect(res, req, strings.TrimSuffix(req.URL.String(), "/redeliver"), http.StatusFound)
+
+	default:
+		res.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
 func loginHandler(res http.ResponseWriter, req *http.Request) {
 	if !db.SystemInitComplete() {
 		redir := req.URL.Scheme + req.URL.Host + "/admin/init"
@@ -1699,6 +2100,11 @@ func approveContentHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	err = db.AppendChange(t, id, db.ChangeApprove)
+	if err != nil {
+		log.Println("Error recording approval in change log for:", t, err)
+	}
+
 	// set the target in the context so user can get saved value from db in hook
 	ctx := context.WithValue(req.Context(), "target", fmt.Sprintf("%s:%d", t, id))
 	req = req.WithContext(ctx)
@@ -34,6 +34,13 @@ func Run() {
 	http.HandleFunc("/admin/configure/users", user.Auth(configUsersHandler))
 	http.HandleFunc("/admin/configure/users/edit", user.Auth(configUsersEditHandler))
 	http.HandleFunc("/admin/configure/users/delete", user.Auth(configUsersDeleteHandler))
+	http.HandleFunc("/admin/configure/apikeys", user.Auth(configAPIKeysHandler))
+	http.HandleFunc("/admin/configure/apikeys/delete", user.Auth(configAPIKeysDeleteHandler))
+	http.HandleFunc("/admin/configure/webhooks", user.Auth(configWebhooksHandler))
+	http.HandleFunc("/admin/configure/webhooks/delete", user.Auth(configWebhooksDeleteHandler))
+	http.HandleFunc("/admin/configure/webhooks/redeliver", user.Auth(configWebhooksRedeliverHandler))
+
+	http.HandleFunc("/admin/maintenance", user.Auth(maintenanceHandler))
 
 	http.HandleFunc("/admin/uploads", user.Auth(uploadContentsHandler))
 	http.HandleFunc("/admin/uploads/search", user.Auth(uploadSearchHandler))
@@ -50,7 +57,8 @@ func Run() {
 
 	staticDir := cfg.AdminStaticDir()
 
-	http.Handle("/admin/static/", db.CacheControl(http.StripPrefix("/admin/static", http.FileServer(restrict(http.Dir(staticDir))))))
+	// each static asset is compressed once for each encoding, until it is modified
+	http.Handle("/admin/static/", db.CacheControl(api.CompressCached(http.StripPrefix("/admin/static", http.FileServer(restrict(http.Dir(staticDir)))).ServeHTTP)))
 
 	// API path needs to be registered within server package so that it is handled
 	// even if the API server is not running. Otherwise, images/files uploaded
@@ -19,8 +19,84 @@ import (
 	"github.com/kudzu-cms/kudzu/system/item"
 )
 
+// Upload is a file stored by StoreUploads
+type Upload struct {
+	// Path is the path of the file on disk
+	Path string
+
+	// URLPath is the path the file is served at, stored in the form field
+	URLPath string
+
+	// ID is the id of the file's upload record
+	ID int
+}
+
 // StoreFiles stores file uploads at paths like /YYYY/MM/filename.ext
 func StoreFiles(req *http.Request) (map[string]string, error) {
+	return storeFiles(req, func(name string, size int64, filename, absPath, urlPath string, fds []*multipart.FileHeader) error {
+		// add upload information to db
+		go storeFileInfo(size, filename, urlPath, fds)
+		return nil
+	})
+}
+
+// StoreUploads stores file uploads as StoreFiles does, but stores the upload
+// record of each file before returning, and returns the uploads by the name of
+// their form field, so they can be removed by RemoveUploads if what they were
+// uploaded for fails. If an error is returned, the uploads already stored are
+// removed.
+func StoreUploads(req *http.Request) (map[string]Upload, error) {
+	uploads := make(map[string]Upload)
+	_, err := storeFiles(req, func(name string, size int64, filename, absPath, urlPath string, fds []*multipart.FileHeader) error {
+		u := Upload{Path: absPath, URLPath: urlPath}
+		uploads[name] = u
+
+		id, err := db.SetUpload("__uploads:-1", fileInfo(size, filename, urlPath, fds))
+		if err != nil {
+			return err
+		}
+
+		u.ID = id
+		uploads[name] = u
+		return nil
+	})
+	if err != nil {
+		removeErr := RemoveUploads(uploads)
+		if removeErr != nil {
+			log.Println("Error removing file uploads:", removeErr)
+		}
+
+		return nil, err
+	}
+
+	return uploads, nil
+}
+
+// RemoveUploads deletes the files and upload records of uploads stored by
+// StoreUploads
+func RemoveUploads(uploads map[string]Upload) error {
+	for _, u := range uploads {
+		err := os.Remove(u.Path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		if u.ID == 0 {
+			continue
+		}
+
+		err = db.DeleteUpload(fmt.Sprintf("__uploads:%d", u.ID))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// storeFiles saves the request's files to disk, calling stored for each file
+// once it is saved
+func storeFiles(req *http.Request, stored func(name string, size int64, filename, absPath, urlPath string, fds []*multipart.FileHeader) error) (map[string]string, error) {
 	err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
 	if err != nil {
 		return nil, err
@@ -98,23 +174,27 @@ func StoreFiles(req *http.Request) (map[string]string, error) {
 		urlPath := fmt.Sprintf("/%s/%s/%d/%02d/%s", urlPathPrefix, uploadDirName, tm.Year(), tm.Month(), filename)
 		urlPaths[name] = urlPath
 
-		// add upload information to db
-		go storeFileInfo(size, filename, urlPath, fds)
+		err = stored(name, size, filename, absPath, urlPath, fds)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return urlPaths, nil
 }
 
 func storeFileInfo(size int64, filename, urlPath string, fds []*multipart.FileHeader) {
-	data := url.Values{
+	_, err := db.SetUpload("__uploads:-1", fileInfo(size, filename, urlPath, fds))
+	if err != nil {
+		log.Println("Error saving file upload record to database:", err)
+	}
+}
+
+func fileInfo(size int64, filename, urlPath string, fds []*multipart.FileHeader) url.Values {
+	return url.Values{
 		"name":           []string{filename},
 		"path":           []string{urlPath},
 		"content_type":   []string{fds[0].Header.Get("Content-Type")},
 		"content_length": []string{fmt.Sprintf("%d", size)},
 	}
-
-	_, err := db.SetUpload("__uploads:-1", data)
-	if err != nil {
-		log.Println("Error saving file upload record to database:", err)
-	}
 }
@@ -96,3 +96,81 @@ func batchPrune(threshold time.Duration) error {
 
 	return nil
 }
+
+// batchInsertLimits inserts the rate limit events queued on limits
+func batchInsertLimits(limits chan apiLimit) error {
+	var events []apiLimit
+	batchSize := len(limits)
+
+	for i := 0; i < batchSize; i++ {
+		events = append(events, <-limits)
+	}
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	return store.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("__limits"))
+		if err != nil {
+			return err
+		}
+
+		for _, l := range events {
+			id, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+
+			j, err := json.Marshal(l)
+			if err != nil {
+				return err
+			}
+
+			err = b.Put([]byte(strconv.FormatUint(id, 10)), j)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// batchPruneLimits removes rate limit events older than the threshold
+func batchPruneLimits(threshold time.Duration) error {
+	min := time.Now().Add(-threshold)
+
+	return store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__limits"))
+
+		// keys can't be deleted while iterating over the bucket, so the expired
+		// ones are collected first
+		var expired [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			var l apiLimit
+			err := json.Unmarshal(v, &l)
+			if err != nil {
+				return err
+			}
+
+			if time.Unix(l.Timestamp/1000, 0).Before(min) {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range expired {
+			err := b.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/boltdb/bolt"
+	"github.com/kudzu-cms/kudzu/system/backup"
 	"github.com/kudzu-cms/kudzu/system/cfg"
 )
 
@@ -26,6 +27,16 @@ type apiRequest struct {
 	External   bool   `json:"external_content"`
 }
 
+// apiLimit is a request which was rejected for exceeding a rate limit
+type apiLimit struct {
+	URL        string `json:"url"`
+	Method     string `json:"http_method"`
+	RemoteAddr string `json:"ip_address"`
+	APIKey     string `json:"api_key,omitempty"`
+	Limit      string `json:"limit"`
+	Timestamp  int64  `json:"timestamp"`
+}
+
 type apiMetric struct {
 	Date   string `json:"date"`
 	Total  int    `json:"total"`
@@ -33,8 +44,9 @@ type apiMetric struct {
 }
 
 var (
-	store       *bolt.DB
+	store       *backup.Store
 	requestChan chan apiRequest
+	limitChan   chan apiLimit
 )
 
 // RANGE determines the number of days kudzu request analytics and metrics are
@@ -61,6 +73,28 @@ func Record(req *http.Request) {
 	requestChan <- r
 }
 
+// RecordLimit queues a request which was rejected by the named rate limit for
+// the client, identified by its IP address or the ID of its API key
+func RecordLimit(req *http.Request, ip, key, limit string) {
+	ts := int64(time.Nanosecond) * time.Now().UnixNano() / int64(time.Millisecond)
+
+	l := apiLimit{
+		URL:        req.URL.String(),
+		Method:     req.Method,
+		RemoteAddr: ip,
+		APIKey:     key,
+		Limit:      limit,
+		Timestamp:  ts,
+	}
+
+	// drop the event rather than block if the queue is full, as is likely
+	// while a client is flooding the API
+	select {
+	case limitChan <- l:
+	default:
+	}
+}
+
 // Close exports the abillity to close our db file. Should be called with defer
 // after call to Init() from the same place.
 func Close() {
@@ -74,8 +108,8 @@ func Close() {
 // sets up the queue/batching channel
 func Init() {
 	var err error
-	analyticsDb := filepath.Join(cfg.DataDir(),"analytics.db")
-	store, err = bolt.Open(analyticsDb, 0666, nil)
+	analyticsDb := filepath.Join(cfg.DataDir(), "analytics.db")
+	store, err = backup.Open(analyticsDb, 0666)
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -91,6 +125,11 @@ func Init() {
 			return err
 		}
 
+		_, err = tx.CreateBucketIfNotExists([]byte("__limits"))
+		if err != nil {
+			return err
+		}
+
 		return nil
 	})
 	if err != nil {
@@ -98,6 +137,7 @@ func Init() {
 	}
 
 	requestChan = make(chan apiRequest, 1024*64*runtime.NumCPU())
+	limitChan = make(chan apiLimit, 1024*4*runtime.NumCPU())
 
 	go serve()
 
@@ -125,12 +165,22 @@ func serve() {
 				log.Println(err)
 			}
 
+			err = batchInsertLimits(limitChan)
+			if err != nil {
+				log.Println(err)
+			}
+
 		case <-pruneDBTimer.C:
 			err := batchPrune(pruneThreshold)
 			if err != nil {
 				log.Println(err)
 			}
 
+			err = batchPruneLimits(pruneThreshold)
+			if err != nil {
+				log.Println(err)
+			}
+
 		case <-time.After(time.Second * 30):
 			continue
 		}
@@ -331,6 +381,34 @@ CHECK_REQUEST:
 		return nil, err
 	}
 
+	// count the requests rejected by rate limits on each day
+	limited := [RANGE]int{}
+	err = store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__limits"))
+
+		return b.ForEach(func(k, v []byte) error {
+			var l apiLimit
+			err := json.Unmarshal(v, &l)
+			if err != nil {
+				log.Println("Error decoding api limit json from analytics db:", err)
+				return nil
+			}
+
+			ts := time.Unix(l.Timestamp/1000, 0)
+			for j := len(times) - 1; j >= 0; j-- {
+				if !ts.Before(times[j]) {
+					limited[j]++
+					break
+				}
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	// marshal array counts to js arrays for output to chart
 	jsUnique, err := json.Marshal(unique)
 	if err != nil {
@@ -342,11 +420,17 @@ CHECK_REQUEST:
 		return nil, err
 	}
 
+	jsLimited, err := json.Marshal(limited)
+	if err != nil {
+		return nil, err
+	}
+
 	return map[string]interface{}{
-		"dates":  dates,
-		"unique": string(jsUnique),
-		"total":  string(jsTotal),
-		"from":   dates[0],
-		"to":     dates[len(dates)-1],
+		"dates":   dates,
+		"unique":  string(jsUnique),
+		"total":   string(jsTotal),
+		"limited": string(jsLimited),
+		"from":    dates[0],
+		"to":      dates[len(dates)-1],
 	}, nil
 }
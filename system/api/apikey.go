@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kudzu-cms/kudzu/system/admin/apikey"
+	"github.com/kudzu-cms/kudzu/system/db"
+)
+
+// keyTouchInterval is how stale a key's last used time may be before a request
+// updates it, so that every request doesn't write to the db
+const keyTouchInterval = time.Minute
+
+// KeyAuth wraps a HandlerFunc to authenticate requests made with an API key in
+// the Authorization header, as "Bearer kudzu_...". The key is added to the
+// request's context, where hooks can get it using apikey.FromContext. Requests
+// without an API key are passed on unchanged, and requests with an invalid,
+// expired or revoked key are rejected.
+func KeyAuth(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		auth := req.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			next.ServeHTTP(res, req)
+			return
+		}
+
+		token := strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+		if !apikey.IsToken(token) {
+			next.ServeHTTP(res, req)
+			return
+		}
+
+		k, err := keyFromToken(token)
+		if err != nil {
+			log.Println("[KeyAuth] rejected API key from:", req.RemoteAddr, err)
+			res.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			sendError(res, http.StatusUnauthorized, errInvalidAPIKey, "The API key is invalid, expired or revoked")
+			return
+		}
+
+		now := time.Now()
+		if now.Sub(time.Unix(0, k.LastUsed*int64(time.Millisecond))) > keyTouchInterval {
+			err := db.TouchAPIKey(k.ID, now)
+			if err != nil {
+				log.Println("[KeyAuth] error updating API key last used time:", err)
+			}
+		}
+
+		// responses depend on the key's scopes, so mustn't be kept by shared caches
+		privateCache(res)
+
+		next.ServeHTTP(res, req.WithContext(apikey.NewContext(req.Context(), k)))
+	})
+}
+
+// privateCache changes a public Cache-Control policy for the response to private
+func privateCache(res http.ResponseWriter) {
+	cc := res.Header().Get("Cache-Control")
+	res.Header().Set("Cache-Control", strings.Replace(cc, "public", "private", 1))
+}
+
+// keyFromToken returns the stored key for a token, if the token's secret is valid
+func keyFromToken(token string) (*apikey.Key, error) {
+	id, secret, err := apikey.Parse(token)
+	if err != nil {
+		return nil, err
+	}
+
+	j, err := db.APIKey(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var k apikey.Key
+	err = json.Unmarshal(j, &k)
+	if err != nil {
+		return nil, err
+	}
+
+	if !k.Verify(secret) {
+		return nil, apikey.ErrInvalidToken
+	}
+
+	return &k, nil
+}
@@ -0,0 +1,570 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kudzu-cms/kudzu/system/admin/upload"
+	"github.com/kudzu-cms/kudzu/system/db"
+	"github.com/kudzu-cms/kudzu/system/item"
+
+	"github.com/tidwall/gjson"
+)
+
+// maxBatchOperations is the most operations a batch request may contain
+const maxBatchOperations = 100
+
+// maxBatchSize is the largest body a batch request may have
+const maxBatchSize = 1024 * 1024 * 32 // 32MB
+
+const (
+	batchRead   = "read"
+	batchCreate = "create"
+	batchUpdate = "update"
+	batchDelete = "delete"
+)
+
+// batchRequest is the body of a request to /api/batch
+type batchRequest struct {
+	Atomic     bool             `json:"atomic"`
+	Operations []batchOperation `json:"operations"`
+}
+
+// batchOperation is one operation of a batch request, applied as the equivalent
+// request to the content API would be
+type batchOperation struct {
+	Op   string                 `json:"op"`
+	Type string                 `json:"type"`
+	ID   json.Number            `json:"id"`
+	Data map[string]interface{} `json:"data"`
+}
+
+// batchResult is the result of one operation of a batch request
+type batchResult struct {
+	Status int             `json:"status"`
+	Op     string          `json:"op"`
+	Type   string          `json:"type"`
+	ID     int             `json:"id,omitempty"`
+	State  string          `json:"state,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+	Error  *item.APIError  `json:"error,omitempty"`
+}
+
+// batchResponseWriter records the status and headers written for an operation
+// by the content API pipeline and its hooks, discarding any body but an error
+type batchResponseWriter struct {
+	header http.Header
+	status int
+	apiErr *item.APIError
+}
+
+func (w *batchResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *batchResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	if w.status >= http.StatusBadRequest && w.apiErr == nil {
+		var e errorResponse
+		if json.Unmarshal(p, &e) == nil && e.Error != nil {
+			w.apiErr = e.Error
+			w.apiErr.Status = w.status
+		}
+	}
+
+	return len(p), nil
+}
+
+func (w *batchResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func batchHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		sendMethodError(res, http.MethodPost)
+		return
+	}
+
+	ct, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if ct != "application/json" {
+		sendError(res, http.StatusUnsupportedMediaType, errUnsupportedMediaType, "The request body must be application/json")
+		return
+	}
+
+	var batch batchRequest
+	dec := json.NewDecoder(http.MaxBytesReader(res, req.Body, maxBatchSize))
+	dec.UseNumber()
+	err := dec.Decode(&batch)
+	if err != nil {
+		log.Println("[Batch] error decoding request:", err)
+		sendError(res, http.StatusBadRequest, errInvalidBody, "The request body must be a JSON object with a list of operations")
+		return
+	}
+
+	if len(batch.Operations) == 0 || len(batch.Operations) > maxBatchOperations {
+		sendError(res, http.StatusBadRequest, errInvalidBody, fmt.Sprintf("A batch must have from 1 to %d operations", maxBatchOperations))
+		return
+	}
+
+	// each operation is prepared before any are applied, since storing files
+	// writes to the db, which can't be done while a batch's Tx is open
+	reqs := make([]*http.Request, len(batch.Operations))
+	results := make([]batchResult, len(batch.Operations))
+	uploads := make([]map[string]upload.Upload, len(batch.Operations))
+	slugs := make(map[string]bool)
+
+	// files are stored before an atomic batch's Tx begins, so unless it is
+	// committed they are removed again, whichever way it ends
+	committed := false
+	if batch.Atomic {
+		defer func() {
+			if !committed {
+				removeBatchUploads(uploads)
+			}
+		}()
+	}
+
+	for i, op := range batch.Operations {
+		results[i] = batchResult{Op: op.Op, Type: op.Type}
+
+		// each operation counts against its type's rate limits, as it would if
+		// it were sent as its own request
+		results[i].Error = limitType(req, op.Type)
+		if results[i].Error == nil {
+			reqs[i], uploads[i], results[i].Error = prepareBatchOperation(req, op)
+		}
+
+		// a slug provided by the client must not be used twice within the batch
+		if results[i].Error == nil && op.Op == batchCreate {
+			if slug := reqs[i].PostForm.Get("slug"); slug != "" {
+				if slugs[slug] {
+					results[i].Error = item.NewAPIError(http.StatusConflict, errSlugConflict, "The slug "+slug+" is already in use")
+				}
+				slugs[slug] = true
+			}
+		}
+
+		if results[i].Error == nil {
+			continue
+		}
+
+		results[i].Status = results[i].Error.Status
+		if batch.Atomic {
+			abortBatch(results, i)
+			sendBatch(res, req, results[i].Status, results, false)
+			return
+		}
+	}
+
+	// the Before hooks of every operation of an atomic batch are called, and
+	// every read is made, before its Tx begins, since hooks may use the db,
+	// which can't be written to by anything else while the Tx is open
+	changes := make([]*contentChange, len(batch.Operations))
+	writers := make([]*batchResponseWriter, len(batch.Operations))
+	for i, op := range batch.Operations {
+		if results[i].Status != 0 {
+			continue
+		}
+
+		writers[i] = &batchResponseWriter{header: make(http.Header)}
+		changes[i] = beginBatchOperation(writers[i], reqs[i], op, &results[i])
+
+		if !batch.Atomic {
+			if changes[i] != nil && applyBatchChange(changes[i], dbStore{}, writers[i], &results[i]) {
+				finishBatchChange(changes[i], writers[i], op, &results[i])
+			}
+			continue
+		}
+
+		if results[i].Status >= http.StatusBadRequest {
+			abortBatch(results, i)
+			sendBatch(res, req, results[i].Status, results, false)
+			return
+		}
+	}
+
+	if !batch.Atomic {
+		sendBatch(res, req, http.StatusOK, results, true)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("[Batch] error beginning transaction:", err)
+		sendInternalError(res)
+		return
+	}
+
+	for i := range batch.Operations {
+		if changes[i] == nil {
+			continue
+		}
+
+		if !applyBatchChange(changes[i], tx, writers[i], &results[i]) {
+			err := tx.Rollback()
+			if err != nil {
+				log.Println("[Batch] error rolling back transaction:", err)
+			}
+
+			abortBatch(results, i)
+			sendBatch(res, req, results[i].Status, results, false)
+			return
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println("[Batch] error committing transaction:", err)
+		sendInternalError(res)
+		return
+	}
+	committed = true
+
+	// the After hooks are only called once the changes are committed, so an
+	// error from one is reported in its result without undoing the batch
+	for i, op := range batch.Operations {
+		if changes[i] != nil {
+			finishBatchChange(changes[i], writers[i], op, &results[i])
+		}
+	}
+
+	sendBatch(res, req, http.StatusOK, results, true)
+}
+
+// prepareBatchOperation returns the request to the content API equivalent to
+// the operation and the files it stored, or the error to respond to it with if
+// the operation is invalid
+func prepareBatchOperation(req *http.Request, op batchOperation) (*http.Request, map[string]upload.Upload, *item.APIError) {
+	if _, ok := item.Types[op.Type]; !ok {
+		return nil, nil, item.NewAPIError(http.StatusNotFound, errUnknownType, "There is no content type named "+op.Type)
+	}
+
+	var id string
+	switch op.Op {
+	case batchCreate:
+		if op.ID != "" {
+			return nil, nil, item.NewAPIError(http.StatusBadRequest, errInvalidParam, "A create operation can't have an id")
+		}
+
+	case batchRead, batchUpdate, batchDelete:
+		id = op.ID.String()
+		if !db.IsValidID(id) {
+			return nil, nil, item.NewAPIError(http.StatusBadRequest, errInvalidParam, "The id must be a positive integer")
+		}
+
+	default:
+		return nil, nil, item.NewAPIError(http.StatusBadRequest, errInvalidParam, "The op must be read, create, update or delete")
+	}
+
+	method := http.MethodPost
+	if op.Op == batchRead {
+		method = http.MethodGet
+	}
+
+	r := req.Clone(req.Context())
+	r.Method = method
+	r.Body = http.NoBody
+	r.ContentLength = 0
+
+	// params of the batch request, such as fields, apply to each operation
+	q := r.URL.Query()
+	q.Set("type", op.Type)
+	q.Del("id")
+	if id != "" {
+		q.Set("id", id)
+	}
+	r.URL.RawQuery = q.Encode()
+
+	r.Form = url.Values{}
+	r.PostForm = url.Values{}
+	r.MultipartForm = &multipart.Form{
+		Value: r.PostForm,
+		File:  make(map[string][]*multipart.FileHeader),
+	}
+
+	if op.Op == batchRead {
+		return r, nil, nil
+	}
+
+	err := addJSONForm(r, op.Data)
+	if err != nil {
+		log.Println("[Batch] error:", err)
+		return nil, nil, item.NewAPIError(http.StatusBadRequest, errInvalidBody, "The data must be a JSON object")
+	}
+
+	// a slug provided by the client must not already be in use
+	if slug := r.PostForm.Get("slug"); slug != "" && op.Op == batchCreate {
+		st, _, _ := db.ContentBySlug(slug)
+		if st != "" {
+			return nil, nil, item.NewAPIError(http.StatusConflict, errSlugConflict, "The slug "+slug+" is already in use")
+		}
+	}
+
+	if op.Op == batchDelete {
+		return r, nil, nil
+	}
+
+	ts := fmt.Sprintf("%d", time.Now().UnixNano()/int64(time.Millisecond))
+	if op.Op == batchCreate {
+		r.PostForm.Set("timestamp", ts)
+	}
+	r.PostForm.Set("updated", ts)
+
+	// files are stored with their upload records, so they can be removed if
+	// an atomic batch isn't committed
+	uploads, err := upload.StoreUploads(r)
+	if err != nil {
+		log.Println("[Batch]", err)
+		return nil, nil, internalError()
+	}
+
+	for name, u := range uploads {
+		r.PostForm.Set(name, u.URLPath)
+	}
+	normalizeFormFields(r.PostForm)
+
+	return r, uploads, nil
+}
+
+// removeBatchUploads removes the files stored for the operations of a batch
+// which wasn't committed
+func removeBatchUploads(uploads []map[string]upload.Upload) {
+	for _, u := range uploads {
+		err := upload.RemoveUploads(u)
+		if err != nil {
+			log.Println("[Batch] error removing uploads:", err)
+		}
+	}
+}
+
+// beginBatchOperation loads the content the operation applies to and calls
+// the same interfaces and hooks as the content API would before applying it.
+// A read is completed, setting the result's status and data, while for other
+// operations the change to apply is returned. If the operation fails, the
+// result's error is set and nil is returned.
+func beginBatchOperation(w *batchResponseWriter, req *http.Request, op batchOperation, result *batchResult) *contentChange {
+	t := op.Type
+	post := item.Types[t]()
+
+	if op.Op != batchCreate {
+		id, _ := strconv.Atoi(op.ID.String())
+		result.ID = id
+
+		existing, err := db.Content(fmt.Sprintf("%s:%d", t, id))
+		if err != nil {
+			log.Println("[Batch] error getting content:", t, id, err)
+			batchError(result, w, internalError())
+			return nil
+		}
+
+		if len(existing) == 0 {
+			batchError(result, w, item.NewAPIError(http.StatusNotFound, errNotFound, fmt.Sprintf("There is no %s content with the id %d", t, id)))
+			return nil
+		}
+
+		err = json.Unmarshal(existing, post)
+		if err != nil {
+			log.Println("[Batch] error populating data in type:", t, err)
+			batchError(result, w, internalError())
+			return nil
+		}
+
+		// the slug identifies the content, so can't be changed by an update
+		slug := gjson.GetBytes(existing, "slug").String()
+		if s := req.PostForm.Get("slug"); op.Op == batchUpdate && s != "" && s != slug {
+			batchError(result, w, item.NewAPIError(http.StatusConflict, errSlugConflict, "The slug of existing content can't be changed"))
+			return nil
+		}
+
+		if op.Op == batchRead {
+			result.Data, result.Status = readBatchItem(w, req, post, existing)
+			if result.Status >= http.StatusBadRequest {
+				batchError(result, w, internalError())
+			}
+			return nil
+		}
+	}
+
+	var c *contentChange
+	var err error
+	switch op.Op {
+	case batchCreate:
+		c, err = prepareCreate(w, req, t, post)
+		if err != nil {
+			batchError(result, w, item.NewAPIError(http.StatusBadRequest, errRejected, "The content wasn't created"))
+		}
+
+	case batchUpdate:
+		c, err = prepareUpdate(w, req, t, op.ID.String(), post, false)
+		if err != nil {
+			batchError(result, w, item.NewAPIError(http.StatusBadRequest, errRejected, "The content wasn't updated"))
+		}
+
+	case batchDelete:
+		c, err = prepareDelete(w, req, t, op.ID.String(), post)
+		if err != nil {
+			batchError(result, w, item.NewAPIError(http.StatusBadRequest, errRejected, "The content wasn't deleted"))
+		}
+	}
+
+	return c
+}
+
+// applyBatchChange stores the change with s, and reports whether it was stored.
+// If it wasn't, the result's error is set.
+func applyBatchChange(c *contentChange, s contentStore, w *batchResponseWriter, result *batchResult) bool {
+	err := c.apply(s)
+	if err != nil {
+		batchError(result, w, internalError())
+		return false
+	}
+
+	return true
+}
+
+// finishBatchChange calls the After hooks of a stored change, setting the
+// result's status and the state the content was left in
+func finishBatchChange(c *contentChange, w *batchResponseWriter, op batchOperation, result *batchResult) {
+	switch op.Op {
+	case batchCreate:
+		result.State = "public"
+		if c.spec != "" {
+			result.State = strings.TrimPrefix(c.spec, "__")
+		} else {
+			result.ID, _ = strconv.Atoi(c.id)
+		}
+
+	case batchUpdate:
+		result.State = "public"
+
+	case batchDelete:
+		result.State = "deleted"
+	}
+
+	err := c.finish()
+	if err != nil {
+		batchError(result, w, item.NewAPIError(http.StatusBadRequest, errRejected, "The content was saved, but a hook run after saving it failed"))
+		return
+	}
+
+	result.Status = http.StatusOK
+}
+
+// readBatchItem returns the item as it would be returned by /api/content, and the
+// status of the response
+func readBatchItem(w *batchResponseWriter, req *http.Request, post interface{}, existing []byte) (json.RawMessage, int) {
+	if hide(w, req, post) {
+		return nil, w.status
+	}
+
+	j, err := fmtJSON(json.RawMessage(existing))
+	if err != nil {
+		return nil, http.StatusInternalServerError
+	}
+
+	j, err = omit(w, req, post, j)
+	if err != nil {
+		return nil, http.StatusInternalServerError
+	}
+
+	j, err = selectFields(req, j, "data")
+	if err != nil {
+		return nil, http.StatusInternalServerError
+	}
+
+	hook, ok := post.(item.Hookable)
+	if !ok {
+		return nil, http.StatusInternalServerError
+	}
+
+	j, err = hook.BeforeAPIResponse(w, req, j)
+	if err != nil {
+		log.Println("[Batch] error calling BeforeAPIResponse:", err)
+		sendHookError(w, err)
+		return nil, w.status
+	}
+
+	err = hook.AfterAPIResponse(w, req, j)
+	if err != nil {
+		log.Println("[Batch] error calling AfterAPIResponse:", err)
+	}
+
+	return json.RawMessage(gjson.GetBytes(j, "data.0").Raw), http.StatusOK
+}
+
+// batchError sets the result's error to the error written by the pipeline or
+// its hooks, or to e with the status written, if any
+func batchError(result *batchResult, w *batchResponseWriter, e *item.APIError) {
+	switch {
+	case w.apiErr != nil:
+		e = w.apiErr
+
+	case w.status >= http.StatusBadRequest:
+		e.Status = w.status
+	}
+
+	result.Status = e.Status
+	result.Error = e
+}
+
+// abortBatch marks the results of an atomic batch whose operation failed as
+// rolled back or not run
+func abortBatch(results []batchResult, failed int) {
+	for i := range results {
+		switch {
+		case i < failed:
+			results[i].Status = http.StatusFailedDependency
+			results[i].State = ""
+			results[i].ID = 0
+			results[i].Data = nil
+			results[i].Error = batchAborted(errRolledBack, fmt.Sprintf("Rolled back, operation %d failed", failed), failed)
+
+		case i > failed:
+			results[i].Status = http.StatusFailedDependency
+			results[i].Error = batchAborted(errNotRun, fmt.Sprintf("Not run, operation %d failed", failed), failed)
+		}
+	}
+}
+
+// batchAborted returns the error for an operation of an atomic batch which was
+// rolled back or not run as the operation failed did
+func batchAborted(code, message string, failed int) *item.APIError {
+	e := item.NewAPIError(http.StatusFailedDependency, code, message)
+	e.Details = map[string]int{"failed": failed}
+
+	return e
+}
+
+func sendBatch(res http.ResponseWriter, req *http.Request, status int, results []batchResult, committed bool) {
+	j, err := json.Marshal(map[string]interface{}{
+		"data":      results,
+		"committed": committed,
+	})
+	if err != nil {
+		log.Println("[Batch] error marshalling response to JSON:", err)
+		sendInternalError(res)
+		return
+	}
+
+	// a batch may change content, so its response must not be cached
+	res.Header().Set("Cache-Control", "no-store")
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	_, err = res.Write(j)
+	if err != nil {
+		log.Println("[Batch] error writing response:", err)
+	}
+}
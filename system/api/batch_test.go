@@ -0,0 +1,176 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kudzu-cms/kudzu/system/cfg"
+	"github.com/kudzu-cms/kudzu/system/db"
+	"github.com/kudzu-cms/kudzu/system/item"
+)
+
+type batchTestPost struct {
+	item.Item
+
+	Title string `json:"title"`
+}
+
+func (p *batchTestPost) String() string { return p.Title }
+
+func (p *batchTestPost) Create(http.ResponseWriter, *http.Request) error { return nil }
+
+func (p *batchTestPost) AutoApprove(http.ResponseWriter, *http.Request) error { return nil }
+
+// TestMain opens a db in a temporary data directory, shared by every test, as
+// db.Init opens the db once for the life of the process
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "kudzu-batch")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	os.Setenv("KUDZU_DATA_DIR", dir)
+	item.Types["BatchTestPost"] = func() interface{} { return new(batchTestPost) }
+
+	db.Init()
+
+	code := m.Run()
+
+	db.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestAtomicBatchCreatesUniqueSlugs(t *testing.T) {
+	body := `{
+		"atomic": true,
+		"operations": [
+			{ "op": "create", "type": "BatchTestPost", "data": { "title": "Same Title" } },
+			{ "op": "create", "type": "BatchTestPost", "data": { "title": "Same Title" } }
+		]
+	}`
+
+	req := httptest.NewRequest(http.MethodPost, "/api/batch", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	res := httptest.NewRecorder()
+
+	batchHandler(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", res.Code, res.Body.String())
+	}
+
+	var resp struct {
+		Committed bool          `json:"committed"`
+		Data      []batchResult `json:"data"`
+	}
+	err := json.Unmarshal(res.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !resp.Committed || len(resp.Data) != 2 {
+		t.Fatalf("expected 2 committed results, got %s", res.Body.String())
+	}
+
+	slugs := make(map[string]int)
+	for _, r := range resp.Data {
+		if r.Status != http.StatusOK || r.ID == 0 {
+			t.Fatalf("expected each create to succeed, got %s", res.Body.String())
+		}
+
+		typ, data, err := db.ContentBySlug(slugOf(t, r.ID))
+		if err != nil || typ != "BatchTestPost" || len(data) == 0 {
+			t.Fatalf("expected content %d in the content index, got type %q: %v", r.ID, typ, err)
+		}
+
+		var p batchTestPost
+		err = json.Unmarshal(data, &p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if p.ID != r.ID {
+			t.Errorf("expected slug %s to belong to content %d, got %d", p.Slug, r.ID, p.ID)
+		}
+
+		slugs[p.Slug]++
+	}
+
+	if len(slugs) != 2 {
+		t.Errorf("expected each create to have its own slug, got %v", slugs)
+	}
+}
+
+func TestAbortedAtomicBatchRemovesUploads(t *testing.T) {
+	// the update fails as there is no content with its id, after the create
+	// has stored its file
+	body := `{
+		"atomic": true,
+		"operations": [
+			{ "op": "create", "type": "BatchTestPost", "data": {
+				"title": "With File",
+				"photo": { "filename": "batch-photo.txt", "data": "aGVsbG8=" }
+			} },
+			{ "op": "update", "type": "BatchTestPost", "id": 999, "data": { "title": "Missing" } }
+		]
+	}`
+
+	req := httptest.NewRequest(http.MethodPost, "/api/batch", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	res := httptest.NewRecorder()
+
+	batchHandler(res, req)
+
+	if res.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d: %s", res.Code, res.Body.String())
+	}
+
+	for _, u := range db.UploadAll() {
+		if strings.Contains(string(u), "batch-photo") {
+			t.Errorf("expected the upload record to be removed, got %s", u)
+		}
+	}
+
+	var files []string
+	err := filepath.Walk(cfg.UploadDir(), func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 0 {
+		t.Errorf("expected the uploaded file to be removed, got %v", files)
+	}
+}
+
+// slugOf returns the slug stored with the BatchTestPost content with the id
+func slugOf(t *testing.T, id int) string {
+	t.Helper()
+
+	data, err := db.Content("BatchTestPost:" + strconv.Itoa(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var p batchTestPost
+	err = json.Unmarshal(data, &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p.Slug
+}
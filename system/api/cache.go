@@ -0,0 +1,116 @@
+package api
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kudzu-cms/kudzu/system/admin/apikey"
+	"github.com/kudzu-cms/kudzu/system/db"
+	"github.com/kudzu-cms/kudzu/system/item"
+
+	"github.com/tidwall/gjson"
+)
+
+// cacheControl sets the Cache-Control header for a response containing content
+// of the type it, if the type is item.Cacheable
+func cacheControl(res http.ResponseWriter, req *http.Request, it interface{}) {
+	c, ok := it.(item.Cacheable)
+	if !ok || db.ConfigCache("cache_disabled").(bool) {
+		return
+	}
+
+	policy, err := c.CacheControl(res, req)
+	if err != nil {
+		log.Println("[Cacheable] error:", err)
+		return
+	}
+
+	if policy == "" {
+		return
+	}
+
+	res.Header().Set("Cache-Control", policy)
+	if apikey.FromContext(req.Context()) != nil {
+		privateCache(res)
+	}
+}
+
+// notModified sets the ETag and Last-Modified headers for a GET or HEAD response
+// with the data, and responds with 304 Not Modified if the request's conditions
+// show the client already has the data. The ETag is a hash of the data and the
+// query which selected it. Last-Modified is only set for a single item, from its
+// "updated" time, since removing an item from a list doesn't change the others.
+func notModified(res http.ResponseWriter, req *http.Request, data []byte) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+
+	var modified time.Time
+	if gjson.GetBytes(data, "data.#").Int() == 1 {
+		if updated := gjson.GetBytes(data, "data.0.updated"); updated.Exists() {
+			modified = time.Unix(0, updated.Int()*int64(time.Millisecond))
+		}
+	}
+
+	return validate(res, req, responseEtag(req, data), modified)
+}
+
+// validate sets the ETag header, and the Last-Modified header unless modified
+// is zero, and responds with 304 Not Modified if the request's conditions show
+// the client already has the response they identify
+func validate(res http.ResponseWriter, req *http.Request, etag string, modified time.Time) bool {
+	res.Header().Set("ETag", etag)
+
+	if !modified.IsZero() {
+		modified = modified.UTC().Truncate(time.Second)
+		res.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+	}
+
+	// If-Modified-Since is ignored when If-None-Match is sent, see RFC 7232 3.3
+	if match := req.Header.Get("If-None-Match"); match != "" {
+		if !etagMatch(match, etag) {
+			return false
+		}
+	} else {
+		since, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+		if err != nil || modified.IsZero() || modified.After(since) {
+			return false
+		}
+	}
+
+	res.Header().Del("Content-Type")
+	res.Header().Del("Content-Encoding")
+	res.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// responseEtag returns a weak ETag for the data, since the same data may be sent
+// with different content encodings. The system's etag is included, so that
+// invalidating the cache in the configuration changes every response's ETag.
+func responseEtag(req *http.Request, data []byte) string {
+	h := sha1.New()
+	h.Write([]byte(db.ConfigCache("etag").(string)))
+	h.Write([]byte{0})
+	h.Write([]byte(req.URL.RawQuery))
+	h.Write([]byte{0})
+	h.Write(data)
+
+	return `W/"` + hex.EncodeToString(h.Sum(nil)) + `"`
+}
+
+// etagMatch reports whether an If-None-Match header matches the etag, using
+// the weak comparison required for If-None-Match
+func etagMatch(header, etag string) bool {
+	for _, m := range strings.Split(header, ",") {
+		m = strings.TrimSpace(m)
+		if m == "*" || strings.TrimPrefix(m, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
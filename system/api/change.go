@@ -0,0 +1,55 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// contentChange is a create, update or delete of content through the content
+// API which its Before hooks have accepted. Storing it and calling its After
+// hooks are separate steps, so an atomic batch can call every Before hook
+// before its db.Tx begins, and every After hook once it's committed.
+type contentChange struct {
+	res   http.ResponseWriter
+	req   *http.Request
+	t     string
+	id    string
+	spec  string
+	store func(s contentStore) (int, error)
+	after func() error
+}
+
+// apply stores the change with s. If an error is returned, apply will have
+// written the response error.
+func (c *contentChange) apply(s contentStore) error {
+	id, err := c.store(s)
+	if err != nil {
+		log.Println("[Content] error storing", c.t+c.spec+":"+c.id, err)
+		sendInternalError(c.res)
+		return err
+	}
+
+	if id > 0 {
+		c.id = strconv.Itoa(id)
+	}
+
+	return nil
+}
+
+// finish calls the change's After hooks. If an error is returned, the hooks will
+// have written any response status or error.
+func (c *contentChange) finish() error {
+	return c.after()
+}
+
+// run stores the change with s and calls its After hooks, as a change made
+// outside an atomic batch is
+func (c *contentChange) run(s contentStore) error {
+	err := c.apply(s)
+	if err != nil {
+		return err
+	}
+
+	return c.finish()
+}
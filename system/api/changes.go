@@ -0,0 +1,143 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kudzu-cms/kudzu/system/db"
+	"github.com/kudzu-cms/kudzu/system/item"
+)
+
+// maxChangesWait is the longest a client may wait for new changes when long-polling
+const maxChangesWait = 60
+
+func changesHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		sendMethodError(res, http.MethodGet)
+		return
+	}
+
+	q := req.URL.Query()
+	t := q.Get("type")
+	if t != "" {
+		it, ok := item.Types[t]
+		if !ok {
+			sendUnknownType(res, http.StatusNotFound, t)
+			return
+		}
+
+		if hide(res, req, it()) {
+			return
+		}
+	}
+
+	since, err := strconv.ParseUint(q.Get("since"), 10, 64) // uint: sequence number of the last change seen (0 default)
+	if err != nil {
+		if q.Get("since") == "" {
+			since = 0
+		} else {
+			sendParamError(res, errInvalidParam, "since", "The since param must be a sequence number")
+			return
+		}
+	}
+
+	count, err := strconv.Atoi(q.Get("count")) // int: determines number of changes to return (100 default, -1 is all)
+	if err != nil {
+		if q.Get("count") == "" {
+			count = 100
+		} else {
+			sendParamError(res, errInvalidParam, "count", "The count param must be an integer")
+			return
+		}
+	}
+
+	wait, err := strconv.Atoi(q.Get("wait")) // int: seconds to wait for a change if there are none (0 default)
+	if err != nil {
+		if q.Get("wait") == "" {
+			wait = 0
+		} else {
+			sendParamError(res, errInvalidParam, "wait", "The wait param must be a number of seconds")
+			return
+		}
+	}
+	if wait > maxChangesWait {
+		wait = maxChangesWait
+	}
+
+	// the change feed must always be fresh, so must not be cached
+	res.Header().Set("Cache-Control", "no-store")
+
+	timeout := time.After(time.Duration(wait) * time.Second)
+	next := since
+	var changes []db.Change
+	for {
+		// get the notification channel before reading so a change committed
+		// between the read and the wait can't be missed
+		notify := db.ChangeNotify()
+
+		all, err := db.Changes(next, count, t)
+		if err != nil {
+			log.Println("[Changes] error:", err)
+			sendInternalError(res)
+			return
+		}
+
+		// advance past changes to hidden types too, so clients don't re-read them
+		if len(all) > 0 {
+			next = all[len(all)-1].Seq
+		}
+
+		changes = visibleChanges(res, req, all)
+		if len(changes) > 0 || wait <= 0 {
+			break
+		}
+
+		select {
+		case <-notify:
+			continue
+		case <-timeout:
+		case <-req.Context().Done():
+			return
+		}
+
+		break
+	}
+
+	j, err := json.Marshal(map[string]interface{}{
+		"data": changes,
+		"next": next,
+	})
+	if err != nil {
+		log.Println("[Changes] error marshalling response to JSON:", err)
+		sendInternalError(res)
+		return
+	}
+
+	sendData(res, req, j)
+}
+
+// visibleChanges removes changes to types which are hidden from the request
+func visibleChanges(res http.ResponseWriter, req *http.Request, changes []db.Change) []db.Change {
+	visible := []db.Change{}
+	hidden := make(map[string]bool)
+
+	for _, c := range changes {
+		h, ok := hidden[c.Type]
+		if !ok {
+			if it, found := item.Types[c.Type]; found {
+				h = isHidden(res, req, c.Type, it())
+			}
+
+			hidden[c.Type] = h
+		}
+
+		if !h {
+			visible = append(visible, c)
+		}
+	}
+
+	return visible
+}
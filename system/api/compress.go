@@ -0,0 +1,506 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kudzu-cms/kudzu/system/db"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// defaultCompressMinSize is the smallest response body compressed, in bytes,
+	// if not set in the configuration. Smaller bodies gain little or even grow.
+	defaultCompressMinSize = 1024
+
+	// maxCompressCacheSize is the most bytes of compressed responses kept by
+	// compressCache
+	maxCompressCacheSize = 16 << 20
+)
+
+// defaultCompressTypes are the media types compressed if not set in the
+// configuration. A "*" matches any part of a media type.
+var defaultCompressTypes = []string{
+	"text/*",
+	"application/json",
+	"application/*+json",
+	"application/javascript",
+	"application/xml",
+	"application/*+xml",
+	"image/svg+xml",
+}
+
+// encodings lists the content encodings responses may be compressed with, in
+// the order they are preferred when a client accepts several equally
+var encodings = []string{"br", "zstd", "gzip"}
+
+// encoder is a compressor which can be reset to write to another writer, so it
+// can be reused
+type encoder interface {
+	io.WriteCloser
+	Reset(io.Writer)
+}
+
+// encoders pools the encoders for each content encoding, since they allocate
+// large buffers
+var encoders = map[string]*sync.Pool{
+	"br": {New: func() interface{} {
+		return brotli.NewWriterLevel(nil, brotli.DefaultCompression)
+	}},
+	"zstd": {New: func() interface{} {
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithLowerEncoderMem(true))
+		if err != nil {
+			panic(err)
+		}
+		return enc
+	}},
+	"gzip": {New: func() interface{} {
+		return gzip.NewWriter(nil)
+	}},
+}
+
+// compressedResponses keeps the compressed bodies of responses which have a
+// validator, an ETag or Last-Modified header, so that a response which hasn't
+// changed is only compressed once for each encoding
+type compressedResponses struct {
+	mu     sync.Mutex
+	bodies map[string][]byte
+	size   int
+}
+
+var compressCache = &compressedResponses{
+	bodies: make(map[string][]byte),
+}
+
+// Compress wraps a HandlerFunc to compress responses with the content encoding
+// the client prefers of Brotli, Zstandard and gzip. Only responses of the media
+// types and at least the size set in the configuration are compressed.
+func Compress(next http.HandlerFunc) http.HandlerFunc {
+	return compress(next, false)
+}
+
+// CompressCached wraps a HandlerFunc as Compress does, and keeps the compressed
+// bodies of responses with an ETag or Last-Modified header in memory, so that
+// responses which haven't changed aren't compressed again. It must only wrap
+// handlers whose response bodies are identified by those headers.
+func CompressCached(next http.HandlerFunc) http.HandlerFunc {
+	return compress(next, true)
+}
+
+// Gzip wraps a HandlerFunc to compress responses when possible.
+//
+// Deprecated: Gzip is the same as Compress, which also negotiates Brotli and
+// Zstandard encodings.
+func Gzip(next http.HandlerFunc) http.HandlerFunc {
+	return Compress(next)
+}
+
+func compress(next http.HandlerFunc, cached bool) http.HandlerFunc {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if db.ConfigCache("gzip_disabled").(bool) == true {
+			next.ServeHTTP(res, req)
+			return
+		}
+
+		// a range of the compressed body isn't a range of the file requested
+		encoding := negotiateEncoding(req.Header.Get("Accept-Encoding"))
+		if encoding == "" || req.Header.Get("Range") != "" {
+			next.ServeHTTP(res, req)
+			return
+		}
+
+		cw := &compressResponseWriter{
+			ResponseWriter: res,
+			req:            req,
+			encoding:       encoding,
+			cached:         cached,
+			minSize:        defaultCompressMinSize,
+			types:          defaultCompressTypes,
+		}
+		if pusher, ok := res.(http.Pusher); ok {
+			cw.pusher = pusher
+		}
+		if v, ok := db.ConfigCache("compress_min_size").(float64); ok && v > 0 {
+			cw.minSize = int(v)
+		}
+		if v, ok := db.ConfigCache("compress_types").(string); ok && strings.TrimSpace(v) != "" {
+			cw.types = splitList(v)
+		}
+
+		defer cw.Close()
+
+		next.ServeHTTP(cw, req)
+	})
+}
+
+// negotiateEncoding returns the content encoding with the highest quality in
+// an Accept-Encoding header, or "" if none of the encodings are acceptable
+func negotiateEncoding(header string) string {
+	if header == "" {
+		return ""
+	}
+
+	quality := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		q := 1.0
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				v, err := strconv.ParseFloat(p[2:], 64)
+				if err == nil {
+					q = v
+				}
+			}
+		}
+
+		quality[name] = q
+	}
+
+	var best string
+	var bestQ float64
+	for _, enc := range encodings {
+		q, ok := quality[enc]
+		if !ok {
+			q = quality["*"]
+		}
+
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+
+	return best
+}
+
+// compressibleType reports whether the media type of a Content-Type header
+// matches one of the types, where "*" in a type matches any part
+func compressibleType(contentType string, types []string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	if mediaType == "" {
+		return false
+	}
+
+	for _, t := range types {
+		t = strings.ToLower(t)
+		i := strings.Index(t, "*")
+		if i < 0 {
+			if mediaType == t {
+				return true
+			}
+			continue
+		}
+
+		prefix, suffix := t[:i], t[i+1:]
+		if len(mediaType) >= len(prefix)+len(suffix) &&
+			strings.HasPrefix(mediaType, prefix) && strings.HasSuffix(mediaType, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addVary adds the header name to the Vary header, unless it is already listed
+func addVary(h http.Header, name string) {
+	for _, v := range h.Values("Vary") {
+		for _, n := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(n), name) {
+				return
+			}
+		}
+	}
+
+	h.Add("Vary", name)
+}
+
+// compressResponseWriter holds back a response until it can tell whether the
+// body is worth compressing, from its Content-Type and Content-Length headers or
+// the first bytes written. Cached responses are held back entirely.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	pusher http.Pusher
+
+	req      *http.Request
+	encoding string
+	cached   bool
+	minSize  int
+	types    []string
+
+	status  int
+	decided bool
+	buf     []byte
+	enc     encoder
+}
+
+// WriteHeader holds back the status until the body is written, unless the
+// response has no body to compress
+func (cw *compressResponseWriter) WriteHeader(status int) {
+	if cw.status != 0 {
+		return
+	}
+	cw.status = status
+
+	h := cw.Header()
+	switch {
+	case status < http.StatusOK,
+		status == http.StatusNoContent,
+		status == http.StatusNotModified,
+		status == http.StatusPartialContent,
+		h.Get("Content-Encoding") != "":
+		cw.passthrough()
+		return
+	}
+
+	if h.Get("Content-Type") != "" && !compressibleType(h.Get("Content-Type"), cw.types) {
+		cw.passthrough()
+		return
+	}
+
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil {
+		if n < cw.minSize {
+			cw.passthrough()
+			return
+		}
+
+		if !cw.cached {
+			cw.startEncoding()
+		}
+	}
+}
+
+func (cw *compressResponseWriter) Write(p []byte) (int, error) {
+	// set as the server would, so the type can be checked
+	if !cw.decided && cw.Header().Get("Content-Type") == "" {
+		cw.Header().Set("Content-Type", http.DetectContentType(p))
+	}
+
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if cw.decided {
+		if cw.enc != nil {
+			return cw.enc.Write(p)
+		}
+
+		return cw.ResponseWriter.Write(p)
+	}
+
+	cw.buf = append(cw.buf, p...)
+	if !compressibleType(cw.Header().Get("Content-Type"), cw.types) {
+		cw.passthrough()
+		return len(p), nil
+	}
+
+	if !cw.cached && len(cw.buf) >= cw.minSize {
+		cw.startEncoding()
+	}
+
+	return len(p), nil
+}
+
+// passthrough writes the status and any body held back, without compressing it
+func (cw *compressResponseWriter) passthrough() {
+	cw.decided = true
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	if len(cw.buf) > 0 {
+		_, err := cw.ResponseWriter.Write(cw.buf)
+		if err != nil {
+			log.Println("[Compress] error writing response:", err)
+		}
+		cw.buf = nil
+	}
+}
+
+// setEncodingHeaders sets the headers of a compressed response
+func (cw *compressResponseWriter) setEncodingHeaders() {
+	h := cw.Header()
+	h.Set("Content-Encoding", cw.encoding)
+	h.Del("Content-Length")
+	addVary(h, "Accept-Encoding")
+}
+
+// startEncoding writes the status, and compresses the body held back and the
+// rest of the body as it is written
+func (cw *compressResponseWriter) startEncoding() {
+	cw.decided = true
+	cw.setEncodingHeaders()
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	cw.enc = encoders[cw.encoding].Get().(encoder)
+	cw.enc.Reset(cw.ResponseWriter)
+
+	if len(cw.buf) > 0 {
+		_, err := cw.enc.Write(cw.buf)
+		if err != nil {
+			log.Println("[Compress] error writing response:", err)
+		}
+		cw.buf = nil
+	}
+}
+
+// Close finishes the response, compressing the body held back if it is large
+// enough, and returns the encoder to its pool
+func (cw *compressResponseWriter) Close() error {
+	if cw.status == 0 {
+		if len(cw.buf) == 0 && cw.Header().Get("Content-Type") == "" {
+			return nil
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if !cw.decided {
+		if len(cw.buf) < cw.minSize || !compressibleType(cw.Header().Get("Content-Type"), cw.types) {
+			cw.passthrough()
+			return nil
+		}
+
+		if cw.cached {
+			return cw.writeCached()
+		}
+
+		cw.startEncoding()
+	}
+
+	if cw.enc == nil {
+		return nil
+	}
+
+	err := cw.enc.Close()
+	cw.enc.Reset(nil)
+	encoders[cw.encoding].Put(cw.enc)
+	cw.enc = nil
+
+	return err
+}
+
+// writeCached writes the compressed body held back, from the cache if the
+// response hasn't changed since it was compressed
+func (cw *compressResponseWriter) writeCached() error {
+	cw.decided = true
+
+	key := cw.cacheKey()
+	body, ok := compressCache.get(key)
+	if !ok {
+		var err error
+		body, err = compressBody(cw.encoding, cw.buf)
+		if err != nil {
+			log.Println("[Compress] error compressing response:", err)
+			cw.passthrough()
+			return err
+		}
+
+		if key != "" {
+			compressCache.put(key, body)
+		}
+	}
+
+	cw.buf = nil
+	cw.setEncodingHeaders()
+	cw.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	_, err := cw.ResponseWriter.Write(body)
+	return err
+}
+
+// cacheKey identifies the compressed body of a response by its path, encoding
+// and validator, or returns "" if the response has no validator
+func (cw *compressResponseWriter) cacheKey() string {
+	validator := cw.Header().Get("ETag")
+	if validator == "" {
+		validator = cw.Header().Get("Last-Modified")
+	}
+	if validator == "" || cw.status != http.StatusOK {
+		return ""
+	}
+
+	return cw.encoding + " " + cw.req.URL.Path + " " + validator
+}
+
+func (cw *compressResponseWriter) Push(target string, opts *http.PushOptions) error {
+	if cw.pusher == nil {
+		return nil
+	}
+
+	if opts == nil {
+		opts = &http.PushOptions{
+			Header: make(http.Header),
+		}
+	}
+
+	opts.Header.Set("Accept-Encoding", cw.req.Header.Get("Accept-Encoding"))
+
+	return cw.pusher.Push(target, opts)
+}
+
+// compressBody compresses the body with a pooled encoder
+func compressBody(encoding string, body []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := encoders[encoding].Get().(encoder)
+	defer encoders[encoding].Put(enc)
+
+	enc.Reset(buf)
+	_, err := enc.Write(body)
+	if err != nil {
+		return nil, err
+	}
+
+	err = enc.Close()
+	enc.Reset(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (c *compressedResponses) get(key string) ([]byte, bool) {
+	if key == "" {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	body, ok := c.bodies[key]
+	return body, ok
+}
+
+// put keeps the compressed body, first dropping others if the cache would grow
+// larger than maxCompressCacheSize
+func (c *compressedResponses) put(key string, body []byte) {
+	if len(body) > maxCompressCacheSize/16 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.bodies[key]; ok {
+		return
+	}
+
+	for k, b := range c.bodies {
+		if c.size+len(body) <= maxCompressCacheSize {
+			break
+		}
+
+		delete(c.bodies, k)
+		c.size -= len(b)
+	}
+
+	c.bodies[key] = body
+	c.size += len(body)
+}
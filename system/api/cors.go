@@ -4,68 +4,259 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
 
 	"github.com/kudzu-cms/kudzu/system/db"
 )
 
-// sendPreflight is used to respond to a cross-origin "OPTIONS" request
-func sendPreflight(res http.ResponseWriter) {
-	res.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type")
-	res.Header().Set("Access-Control-Allow-Origin", "*")
-	res.WriteHeader(200)
-	return
+const (
+	defaultCORSMethods = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+	defaultCORSHeaders = "Accept, Authorization, Content-Type"
+)
+
+// originPattern matches the origins of cross-origin requests. An empty scheme
+// or port matches any, and a host starting with "*." matches its subdomains.
+type originPattern struct {
+	scheme string
+	host   string
+	port   string
+}
+
+// corsOrigins keeps the allowed origins parsed from the system configuration
+type corsOrigins struct {
+	mu       sync.Mutex
+	config   string
+	any      bool
+	patterns []originPattern
+	warned   bool
+}
+
+var allowedOrigins = &corsOrigins{}
+
+// corsPolicy is the CORS configuration applied to a request
+type corsPolicy struct {
+	any         bool
+	patterns    []originPattern
+	methods     string
+	headers     string
+	exposed     string
+	credentials bool
+	maxAge      int64
+}
+
+// currentCORSPolicy returns the CORS configuration, parsing the allowed origins
+// again only if they have changed. With CORS disabled, only the Domain may make
+// cross-origin requests, and with no allowed origins set, any origin may, but
+// without credentials.
+func currentCORSPolicy() corsPolicy {
+	p := corsPolicy{
+		methods: defaultCORSMethods,
+		headers: defaultCORSHeaders,
+	}
+
+	if v, ok := db.ConfigCache("cors_allowed_methods").(string); ok && strings.TrimSpace(v) != "" {
+		p.methods = joinHeaderList(v)
+	}
+	if v, ok := db.ConfigCache("cors_allowed_headers").(string); ok && strings.TrimSpace(v) != "" {
+		p.headers = joinHeaderList(v)
+	}
+	if v, ok := db.ConfigCache("cors_exposed_headers").(string); ok {
+		p.exposed = joinHeaderList(v)
+	}
+	p.credentials, _ = db.ConfigCache("cors_allow_credentials").(bool)
+	if v, ok := db.ConfigCache("cors_max_age").(float64); ok {
+		p.maxAge = int64(v)
+	}
+
+	origins, _ := db.ConfigCache("cors_allowed_origins").(string)
+	disabled, _ := db.ConfigCache("cors_disabled").(bool)
+	if disabled {
+		origins, _ = db.ConfigCache("domain").(string)
+	}
+
+	allowedOrigins.mu.Lock()
+	defer allowedOrigins.mu.Unlock()
+
+	if origins != allowedOrigins.config || allowedOrigins.patterns == nil {
+		allowedOrigins.any, allowedOrigins.patterns = parseOrigins(origins)
+		allowedOrigins.config = origins
+		allowedOrigins.warned = false
+	}
+
+	p.any = allowedOrigins.any || (!disabled && len(allowedOrigins.patterns) == 0)
+	p.patterns = allowedOrigins.patterns
+
+	// credentials are only allowed for listed origins, since allowing them for
+	// any origin would let every website read the API as its visitors
+	if p.credentials && p.any {
+		p.credentials = false
+		if !allowedOrigins.warned {
+			log.Println("[CORS] ignoring allowed credentials, which require a list of allowed origins without \"*\"")
+			allowedOrigins.warned = true
+		}
+	}
+
+	return p
 }
 
-func responseWithCORS(res http.ResponseWriter, req *http.Request) (http.ResponseWriter, bool) {
-	if db.ConfigCache("cors_disabled").(bool) == true {
-		// check origin matches config domain
-		domain := db.ConfigCache("domain").(string)
-		origin := req.Header.Get("Origin")
-		u, err := url.Parse(origin)
-		if err != nil {
-			log.Println("Error parsing URL from request Origin header:", origin)
-			return res, false
+// parseOrigins parses allowed origins, one per line or separated by commas, as
+// [scheme://]host[:port], and reports whether a "*" entry allows any origin
+func parseOrigins(config string) (bool, []originPattern) {
+	var any bool
+	patterns := []originPattern{}
+	for _, entry := range splitList(config) {
+		if entry == "*" {
+			any = true
+			continue
 		}
 
-		// hack to get dev environments to bypass cors since u.Host (below) will
-		// be empty, based on Go's url.Parse function
-		if domain == "localhost" {
-			domain = ""
+		p := originPattern{}
+		if i := strings.Index(entry, "://"); i >= 0 {
+			p.scheme, entry = entry[:i], entry[i+3:]
 		}
-		origin = u.Host
-
-		// currently, this will check for exact match. will need feedback to
-		// determine if subdomains should be allowed or allow multiple domains
-		// in config
-		if origin == domain {
-			// apply limited CORS headers and return
-			res.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type")
-			res.Header().Set("Access-Control-Allow-Origin", domain)
-			return res, true
+
+		p.host = strings.TrimSuffix(entry, "/")
+		if i := strings.LastIndex(p.host, ":"); i >= 0 {
+			p.host, p.port = p.host[:i], p.host[i+1:]
+		}
+
+		p.scheme, p.host = strings.ToLower(p.scheme), strings.ToLower(p.host)
+		patterns = append(patterns, p)
+	}
+
+	return any, patterns
+}
+
+// matches reports whether the pattern matches the origin
+func (p originPattern) matches(origin *url.URL) bool {
+	if p.scheme != "" && p.scheme != strings.ToLower(origin.Scheme) {
+		return false
+	}
+
+	if p.port != "" && p.port != origin.Port() {
+		return false
+	}
+
+	host := strings.ToLower(origin.Hostname())
+	if strings.HasPrefix(p.host, "*.") {
+		return strings.HasSuffix(host, p.host[1:])
+	}
+
+	return host == p.host
+}
+
+// allows reports whether the policy allows cross-origin requests from origin
+func (p corsPolicy) allows(origin *url.URL) bool {
+	if p.any {
+		return true
+	}
+
+	for _, pattern := range p.patterns {
+		if pattern.matches(origin) {
+			return true
 		}
+	}
+
+	return false
+}
+
+// splitList splits a list from the configuration separated by commas, spaces
+// or lines
+func splitList(list string) []string {
+	return strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
+// joinHeaderList normalizes a list of methods or headers to the form sent in a
+// response header
+func joinHeaderList(list string) string {
+	return strings.Join(splitList(list), ", ")
+}
+
+// sendPreflight is used to respond to a cross-origin "OPTIONS" request with the
+// methods and headers which may be used, and how long the response may be cached.
+// A "*" in the allowed methods or headers allows those requested.
+func sendPreflight(res http.ResponseWriter, req *http.Request, p corsPolicy) {
+	methods := p.methods
+	if methods == "*" && req.Header.Get("Access-Control-Request-Method") != "" {
+		methods = req.Header.Get("Access-Control-Request-Method")
+	}
 
-		// disallow request
-		res.WriteHeader(http.StatusForbidden)
+	headers := p.headers
+	if headers == "*" {
+		headers = req.Header.Get("Access-Control-Request-Headers")
+	}
+
+	res.Header().Set("Access-Control-Allow-Methods", methods)
+	if headers != "" {
+		res.Header().Set("Access-Control-Allow-Headers", headers)
+	}
+	if p.maxAge > 0 {
+		res.Header().Set("Access-Control-Max-Age", strconv.FormatInt(p.maxAge, 10))
+	}
+
+	res.Header().Add("Vary", "Access-Control-Request-Method")
+	res.Header().Add("Vary", "Access-Control-Request-Headers")
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// responseWithCORS applies the CORS headers for the request's Origin, or rejects
+// the request with 403 Forbidden if the origin isn't allowed. Requests without
+// an Origin, or from the server's own origin, aren't cross-origin and are left
+// as they are.
+func responseWithCORS(res http.ResponseWriter, req *http.Request, p corsPolicy) (http.ResponseWriter, bool) {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return res, true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" || strings.EqualFold(u.Host, req.Host) {
+		return res, true
+	}
+
+	if !p.allows(u) {
+		res.Header().Add("Vary", "Origin")
+		sendError(res, http.StatusForbidden, errOriginNotAllowed, "Requests from the origin "+origin+" aren't allowed")
 		return res, false
 	}
 
-	// apply full CORS headers and return
-	res.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type")
-	res.Header().Set("Access-Control-Allow-Origin", "*")
+	// credentials can't be sent to "*", so the origin is echoed instead
+	if p.any && !p.credentials {
+		res.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		res.Header().Set("Access-Control-Allow-Origin", origin)
+		res.Header().Add("Vary", "Origin")
+	}
+
+	if p.credentials {
+		res.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if p.exposed != "" {
+		res.Header().Set("Access-Control-Expose-Headers", p.exposed)
+	}
 
 	return res, true
 }
 
-// CORS wraps a HandlerFunc to respond to OPTIONS requests properly
+// CORS wraps a HandlerFunc to apply the configured CORS policy, and to respond
+// to OPTIONS requests properly
 func CORS(next http.HandlerFunc) http.HandlerFunc {
 	return db.CacheControl(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
-		res, cors := responseWithCORS(res, req)
+		p := currentCORSPolicy()
+
+		res, cors := responseWithCORS(res, req, p)
 		if !cors {
 			return
 		}
 
 		if req.Method == http.MethodOptions {
-			sendPreflight(res)
+			sendPreflight(res, req, p)
 			return
 		}
 
@@ -6,11 +6,12 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/kudzu-cms/kudzu/system/admin/upload"
-	"github.com/kudzu-cms/kudzu/system/db"
+	"github.com/kudzu-cms/kudzu/system/admin/apikey"
 	"github.com/kudzu-cms/kudzu/system/item"
 
 	"github.com/gorilla/schema"
@@ -31,36 +32,35 @@ type Trustable interface {
 
 func createContentHandler(res http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodPost {
-		res.WriteHeader(http.StatusMethodNotAllowed)
+		sendMethodError(res, http.MethodPost)
 		return
 	}
 
-	err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
+	err := parseContentForm(req)
 	if err != nil {
 		log.Println("[Create] error:", err)
-		res.WriteHeader(http.StatusInternalServerError)
+		sendError(res, http.StatusBadRequest, errInvalidBody, "The request body must be a valid form or JSON object")
 		return
 	}
 
 	t := req.URL.Query().Get("type")
 	if t == "" {
-		res.WriteHeader(http.StatusBadRequest)
+		sendParamError(res, errMissingParam, "type", "The type param is required")
 		return
 	}
 
 	p, found := item.Types[t]
 	if !found {
 		log.Println("[Create] attempt to submit unknown type:", t, "from:", req.RemoteAddr)
-		res.WriteHeader(http.StatusNotFound)
+		sendUnknownType(res, http.StatusNotFound, t)
 		return
 	}
 
 	post := p()
 
-	ext, ok := post.(Createable)
-	if !ok {
+	if _, ok := post.(Createable); !ok {
 		log.Println("[Create] rejected non-createable type:", t, "from:", req.RemoteAddr)
-		res.WriteHeader(http.StatusBadRequest)
+		sendError(res, http.StatusBadRequest, errNotCreateable, "Content of type "+t+" can't be created through the API")
 		return
 	}
 
@@ -68,99 +68,128 @@ func createContentHandler(res http.ResponseWriter, req *http.Request) {
 	req.PostForm.Set("timestamp", ts)
 	req.PostForm.Set("updated", ts)
 
-	urlPaths, err := upload.StoreFiles(req)
+	err = prepareContentForm(req)
 	if err != nil {
 		log.Println(err)
-		res.WriteHeader(http.StatusInternalServerError)
+		sendInternalError(res)
 		return
 	}
 
-	for name, urlPath := range urlPaths {
-		req.PostForm.Set(name, urlPath)
+	id, spec, err := createContent(res, req, t, post)
+	if err != nil {
+		return
 	}
 
-	// check for any multi-value fields (ex. checkbox fields)
-	// and correctly format for db storage. Essentially, we need
-	// fieldX.0: value1, fieldX.1: value2 => fieldX: []string{value1, value2}
-	fieldOrderValue := make(map[string]map[string][]string)
-	for k, v := range req.PostForm {
-		if strings.Contains(k, ".") {
-			fo := strings.Split(k, ".")
+	// create JSON response to send data back to client
+	var data map[string]interface{}
+	if spec != "" {
+		data = map[string]interface{}{
+			"status": strings.TrimPrefix(spec, "__"),
+			"type":   t,
+		}
+	} else {
+		data = map[string]interface{}{
+			"id":     id,
+			"status": "public",
+			"type":   t,
+		}
+	}
 
-			// put the order and the field value into map
-			field := string(fo[0])
-			order := string(fo[1])
-			if len(fieldOrderValue[field]) == 0 {
-				fieldOrderValue[field] = make(map[string][]string)
-			}
+	resp := map[string]interface{}{
+		"data": []map[string]interface{}{
+			data,
+		},
+	}
 
-			// orderValue is 0:[?type=Thing&id=1]
-			orderValue := fieldOrderValue[field]
-			orderValue[order] = v
-			fieldOrderValue[field] = orderValue
+	j, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("[Create] error marshalling response to JSON:", err)
+		sendInternalError(res)
+		return
+	}
 
-			// discard the post form value with name.N
-			req.PostForm.Del(k)
-		}
+	res.Header().Set("Content-Type", "application/json")
+	_, err = res.Write(j)
+	if err != nil {
+		log.Println("[Create] error writing response:", err)
+		return
+	}
 
+}
+
+// createContent decodes the values in req.PostForm into post, calls the create
+// hooks and stores the content as the type t. It returns the ID of the new
+// content and the bucket specifier it was stored with, which is "__pending"
+// unless the type is Trustable. If an error is returned, the hooks or
+// createContent will have written any response status or error.
+func createContent(res http.ResponseWriter, req *http.Request, t string, post interface{}) (int, string, error) {
+	c, err := prepareCreate(res, req, t, post)
+	if err != nil {
+		return 0, "", err
 	}
 
-	// add/set the key & value to the post form in order
-	for f, ov := range fieldOrderValue {
-		for i := 0; i < len(ov); i++ {
-			position := fmt.Sprintf("%d", i)
-			fieldValue := ov[position]
+	err = c.run(dbStore{})
+	if err != nil {
+		return 0, "", err
+	}
 
-			if req.PostForm.Get(f) == "" {
-				for i, fv := range fieldValue {
-					if i == 0 {
-						req.PostForm.Set(f, fv)
-					} else {
-						req.PostForm.Add(f, fv)
-					}
-				}
-			} else {
-				for _, fv := range fieldValue {
-					req.PostForm.Add(f, fv)
-				}
-			}
-		}
+	id, _ := strconv.Atoi(c.id)
+	return id, c.spec, nil
+}
+
+// prepareCreate decodes the values in req.PostForm into post and calls the
+// hooks run before content of type t is created, returning the change which
+// stores it and calls the hooks run after. If an error is returned, the hooks
+// or prepareCreate will have written any response status or error.
+func prepareCreate(res http.ResponseWriter, req *http.Request, t string, post interface{}) (*contentChange, error) {
+	ext, ok := post.(Createable)
+	if !ok {
+		sendError(res, http.StatusBadRequest, errNotCreateable, "Content of type "+t+" can't be created through the API")
+		return nil, fmt.Errorf("Type %s does not implement api.Createable", t)
+	}
+
+	if !keyAllows(req, t, apikey.ScopeCreate) {
+		sendScopeError(res, t, apikey.ScopeCreate)
+		return nil, fmt.Errorf("API key does not have the create scope for type %s", t)
 	}
 
 	hook, ok := post.(item.Hookable)
 	if !ok {
 		log.Println("[Create] error: Type", t, "does not implement item.Hookable or embed item.Item.")
-		res.WriteHeader(http.StatusBadRequest)
-		return
+		sendInternalError(res)
+		return nil, fmt.Errorf("Type %s does not implement item.Hookable", t)
 	}
 
 	// Let's be nice and make a proper item for the Hookable methods
 	dec := schema.NewDecoder()
 	dec.IgnoreUnknownKeys(true)
 	dec.SetAliasTag("json")
-	err = dec.Decode(post, req.PostForm)
+	err := dec.Decode(post, req.PostForm)
 	if err != nil {
 		log.Println("Error decoding post form for edit handler:", t, err)
-		res.WriteHeader(http.StatusBadRequest)
-		return
+		sendError(res, http.StatusBadRequest, errInvalidBody, "The request body has values which don't match the fields of "+t)
+		return nil, err
 	}
 
 	err = hook.BeforeAPICreate(res, req)
 	if err != nil {
 		log.Println("[Create] error calling BeforeCreate:", err)
-		return
+		hookError(res, err)
+		return nil, err
 	}
 
 	err = ext.Create(res, req)
 	if err != nil {
 		log.Println("[Create] error calling Accept:", err)
-		return
+		hookError(res, err)
+		return nil, err
 	}
 
 	err = hook.BeforeSave(res, req)
 	if err != nil {
 		log.Println("[Create] error calling BeforeSave:", err)
-		return
+		hookError(res, err)
+		return nil, err
 	}
 
 	// set specifier for db bucket in case content is/isn't Trustable
@@ -175,70 +204,92 @@ func createContentHandler(res http.ResponseWriter, req *http.Request) {
 		err := trusted.AutoApprove(res, req)
 		if err != nil {
 			log.Println("[Create] error calling AutoApprove:", err)
-			return
+			hookError(res, err)
+			return nil, err
 		}
 	} else {
 		spec = "__pending"
 	}
 
-	id, err := db.SetContent(t+spec+":-1", req.PostForm)
-	if err != nil {
-		log.Println("[Create] error calling SetContent:", err)
-		res.WriteHeader(http.StatusInternalServerError)
-		return
+	c := &contentChange{res: res, req: req, t: t, id: "-1", spec: spec}
+	c.store = func(s contentStore) (int, error) {
+		return s.SetContent(t+spec+":-1", req.PostForm)
 	}
+	c.after = func() error {
+		// set the target in the context so user can get saved value from db in hook
+		ctx := context.WithValue(req.Context(), "target", t+":"+c.id)
+		req := req.WithContext(ctx)
 
-	// set the target in the context so user can get saved value from db in hook
-	ctx := context.WithValue(req.Context(), "target", fmt.Sprintf("%s:%d", t, id))
-	req = req.WithContext(ctx)
+		err := hook.AfterSave(res, req)
+		if err != nil {
+			log.Println("[Create] error calling AfterSave:", err)
+			hookError(res, err)
+			return err
+		}
 
-	err = hook.AfterSave(res, req)
-	if err != nil {
-		log.Println("[Create] error calling AfterSave:", err)
-		return
-	}
+		err = hook.AfterAPICreate(res, req)
+		if err != nil {
+			log.Println("[Create] error calling AfterAccept:", err)
+			hookError(res, err)
+			return err
+		}
 
-	err = hook.AfterAPICreate(res, req)
-	if err != nil {
-		log.Println("[Create] error calling AfterAccept:", err)
-		return
+		return nil
 	}
 
-	// create JSON response to send data back to client
-	var data map[string]interface{}
-	if spec != "" {
-		spec = strings.TrimPrefix(spec, "__")
-		data = map[string]interface{}{
-			"status": spec,
-			"type":   t,
-		}
-	} else {
-		spec = "public"
-		data = map[string]interface{}{
-			"id":     id,
-			"status": spec,
-			"type":   t,
-		}
-	}
+	return c, nil
+}
 
-	resp := map[string]interface{}{
-		"data": []map[string]interface{}{
-			data,
-		},
-	}
+// normalizeFormFields formats multi-value fields (ex. checkbox fields) submitted
+// as fieldX.0, fieldX.1, ... for db storage as fieldX: []string{value1, value2}
+func normalizeFormFields(form url.Values) {
+	fieldOrderValue := make(map[string]map[string][]string)
+	for k, v := range form {
+		if strings.Contains(k, ".") {
+			fo := strings.Split(k, ".")
 
-	j, err := json.Marshal(resp)
-	if err != nil {
-		log.Println("[Create] error marshalling response to JSON:", err)
-		res.WriteHeader(http.StatusInternalServerError)
-		return
-	}
+			// only fieldX.N names a value of a multi-value field, others such as
+			// fieldX.key or fieldX.N.key are paths into nested structs
+			if _, err := strconv.Atoi(fo[1]); len(fo) != 2 || err != nil {
+				continue
+			}
 
-	res.Header().Set("Content-Type", "application/json")
-	_, err = res.Write(j)
-	if err != nil {
-		log.Println("[Create] error writing response:", err)
-		return
+			// put the order and the field value into map
+			field := string(fo[0])
+			order := string(fo[1])
+			if len(fieldOrderValue[field]) == 0 {
+				fieldOrderValue[field] = make(map[string][]string)
+			}
+
+			// orderValue is 0:[?type=Thing&id=1]
+			orderValue := fieldOrderValue[field]
+			orderValue[order] = v
+			fieldOrderValue[field] = orderValue
+
+			// discard the post form value with name.N
+			form.Del(k)
+		}
 	}
 
+	// add/set the key & value to the post form in order
+	for f, ov := range fieldOrderValue {
+		for i := 0; i < len(ov); i++ {
+			position := fmt.Sprintf("%d", i)
+			fieldValue := ov[position]
+
+			if form.Get(f) == "" {
+				for i, fv := range fieldValue {
+					if i == 0 {
+						form.Set(f, fv)
+					} else {
+						form.Add(f, fv)
+					}
+				}
+			} else {
+				for _, fv := range fieldValue {
+					form.Add(f, fv)
+				}
+			}
+		}
+	}
 }
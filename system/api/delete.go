@@ -2,9 +2,11 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 
+	"github.com/kudzu-cms/kudzu/system/admin/apikey"
 	"github.com/kudzu-cms/kudzu/system/db"
 	"github.com/kudzu-cms/kudzu/system/item"
 )
@@ -18,58 +20,130 @@ type Deleteable interface {
 
 func deleteContentHandler(res http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodPost {
-		res.WriteHeader(http.StatusMethodNotAllowed)
+		sendMethodError(res, http.MethodPost)
 		return
 	}
 
-	err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
+	err := parseContentForm(req)
 	if err != nil {
 		log.Println("[Delete] error:", err)
-		res.WriteHeader(http.StatusInternalServerError)
+		sendError(res, http.StatusBadRequest, errInvalidBody, "The request body must be a valid form or JSON object")
 		return
 	}
 
 	t := req.URL.Query().Get("type")
 	if t == "" {
-		res.WriteHeader(http.StatusBadRequest)
+		sendParamError(res, errMissingParam, "type", "The type param is required")
 		return
 	}
 
 	p, found := item.Types[t]
 	if !found {
 		log.Println("[Delete] attempt to delete content of unknown type:", t, "from:", req.RemoteAddr)
-		res.WriteHeader(http.StatusNotFound)
+		sendUnknownType(res, http.StatusNotFound, t)
 		return
 	}
 
 	id := req.URL.Query().Get("id")
+	if id == "" {
+		log.Println("[Delete] attempt to delete content with missing id from:", req.RemoteAddr)
+		sendParamError(res, errMissingParam, "id", "The id param is required")
+		return
+	}
+
 	if !db.IsValidID(id) {
-		log.Println("[Delete] attempt to delete content with missing or invalid id from:", req.RemoteAddr)
-		res.WriteHeader(http.StatusBadRequest)
+		log.Println("[Delete] attempt to delete content with invalid id from:", req.RemoteAddr)
+		sendParamError(res, errInvalidParam, "id", "The id param must be a positive integer")
 		return
 	}
 
 	post := p()
 
-	ext, ok := post.(Deleteable)
-	if !ok {
+	if _, ok := post.(Deleteable); !ok {
 		log.Println("[Delete] rejected non-deleteable type:", t, "from:", req.RemoteAddr)
-		res.WriteHeader(http.StatusBadRequest)
+		sendError(res, http.StatusBadRequest, errNotDeleteable, "Content of type "+t+" can't be deleted through the API")
+		return
+	}
+
+	err = deleteContent(res, req, t, id, post)
+	if err != nil {
 		return
 	}
 
+	// create JSON response to send data back to client
+	var data = map[string]interface{}{
+		"id":     id,
+		"status": "deleted",
+		"type":   t,
+	}
+
+	resp := map[string]interface{}{
+		"data": []map[string]interface{}{
+			data,
+		},
+	}
+
+	j, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("[Delete] error marshalling response to JSON:", err)
+		sendInternalError(res)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	_, err = res.Write(j)
+	if err != nil {
+		log.Println("[Delete] error writing response:", err)
+		return
+	}
+
+}
+
+// deleteContent loads the stored content of type t with the id provided into
+// post, calls the delete hooks and deletes the content. If an error is returned,
+// the hooks or deleteContent will have written any response status or error.
+func deleteContent(res http.ResponseWriter, req *http.Request, t, id string, post interface{}) error {
+	c, err := prepareDelete(res, req, t, id, post)
+	if err != nil {
+		return err
+	}
+
+	return c.run(dbStore{})
+}
+
+// prepareDelete loads the stored content of type t with the id provided into
+// post and calls the hooks run before it is deleted, returning the change which
+// deletes it and calls the hooks run after. If an error is returned, the hooks
+// or prepareDelete will have written any response status or error.
+func prepareDelete(res http.ResponseWriter, req *http.Request, t, id string, post interface{}) (*contentChange, error) {
+	ext, ok := post.(Deleteable)
+	if !ok {
+		sendError(res, http.StatusBadRequest, errNotDeleteable, "Content of type "+t+" can't be deleted through the API")
+		return nil, fmt.Errorf("Type %s does not implement api.Deleteable", t)
+	}
+
+	if !keyAllows(req, t, apikey.ScopeDelete) {
+		sendScopeError(res, t, apikey.ScopeDelete)
+		return nil, fmt.Errorf("API key does not have the delete scope for type %s", t)
+	}
+
 	hook, ok := post.(item.Hookable)
 	if !ok {
 		log.Println("[Delete] error: Type", t, "does not implement item.Hookable or embed item.Item.")
-		res.WriteHeader(http.StatusBadRequest)
-		return
+		sendInternalError(res)
+		return nil, fmt.Errorf("Type %s does not implement item.Hookable", t)
 	}
 
 	b, err := db.Content(t + ":" + id)
 	if err != nil {
 		log.Println("Error in db.Content ", t+":"+id, err)
-		res.WriteHeader(http.StatusBadRequest)
-		return
+		sendInternalError(res)
+		return nil, err
+	}
+
+	if len(b) == 0 {
+		sendError(res, http.StatusNotFound, errNotFound, "There is no "+t+" content with the id "+id)
+		return nil, fmt.Errorf("No content found for %s:%s", t, id)
 	}
 
 	err = json.Unmarshal(b, post)
@@ -80,73 +154,51 @@ func deleteContentHandler(res http.ResponseWriter, req *http.Request) {
 	err = hook.BeforeAPIDelete(res, req)
 	if err != nil {
 		log.Println("[Delete] error calling BeforeAPIDelete:", err)
-		if err == ErrNoAuth {
+		if !hookError(res, err) && err == ErrNoAuth {
 			// BeforeAPIDelete can check user.IsValid(req) for auth
-			res.WriteHeader(http.StatusUnauthorized)
+			sendError(res, http.StatusUnauthorized, errUnauthorized, "The request isn't authorized")
 		}
-		return
+		return nil, err
 	}
 
 	err = ext.Delete(res, req)
 	if err != nil {
 		log.Println("[Delete] error calling Delete:", err)
-		if err == ErrNoAuth {
+		if !hookError(res, err) && err == ErrNoAuth {
 			// Delete can check user.IsValid(req) or other forms of validation for auth
-			res.WriteHeader(http.StatusUnauthorized)
+			sendError(res, http.StatusUnauthorized, errUnauthorized, "The request isn't authorized")
 		}
-		return
+		return nil, err
 	}
 
 	err = hook.BeforeDelete(res, req)
 	if err != nil {
 		log.Println("[Delete] error calling BeforeSave:", err)
-		return
+		hookError(res, err)
+		return nil, err
 	}
 
-	err = db.DeleteContent(t + ":" + id)
-	if err != nil {
-		log.Println("[Delete] error calling DeleteContent:", err)
-		res.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	err = hook.AfterDelete(res, req)
-	if err != nil {
-		log.Println("[Delete] error calling AfterDelete:", err)
-		return
-	}
-
-	err = hook.AfterAPIDelete(res, req)
-	if err != nil {
-		log.Println("[Delete] error calling AfterAPIDelete:", err)
-		return
-	}
-
-	// create JSON response to send data back to client
-	var data = map[string]interface{}{
-		"id":     id,
-		"status": "deleted",
-		"type":   t,
-	}
-
-	resp := map[string]interface{}{
-		"data": []map[string]interface{}{
-			data,
-		},
+	c := &contentChange{res: res, req: req, t: t, id: id}
+	c.store = func(s contentStore) (int, error) {
+		return 0, s.DeleteContent(t + ":" + id)
 	}
+	c.after = func() error {
+		err := hook.AfterDelete(res, req)
+		if err != nil {
+			log.Println("[Delete] error calling AfterDelete:", err)
+			hookError(res, err)
+			return err
+		}
 
-	j, err := json.Marshal(resp)
-	if err != nil {
-		log.Println("[Delete] error marshalling response to JSON:", err)
-		res.WriteHeader(http.StatusInternalServerError)
-		return
-	}
+		err = hook.AfterAPIDelete(res, req)
+		if err != nil {
+			log.Println("[Delete] error calling AfterAPIDelete:", err)
+			hookError(res, err)
+			return err
+		}
 
-	res.Header().Set("Content-Type", "application/json")
-	_, err = res.Write(j)
-	if err != nil {
-		log.Println("[Delete] error writing response:", err)
-		return
+		return nil
 	}
 
+	return c, nil
 }
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/kudzu-cms/kudzu/system/item"
+)
+
+// Error codes sent in the body of error responses. Clients may rely on them,
+// so they must not be changed once released.
+const (
+	errMethodNotAllowed     = "method_not_allowed"
+	errMissingParam         = "missing_param"
+	errInvalidParam         = "invalid_param"
+	errInvalidBody          = "invalid_body"
+	errUnsupportedMediaType = "unsupported_media_type"
+	errUnknownType          = "unknown_type"
+	errNotFound             = "not_found"
+	errNotCreateable        = "not_createable"
+	errNotUpdateable        = "not_updateable"
+	errNotDeleteable        = "not_deleteable"
+	errSlugConflict         = "slug_conflict"
+	errRejected             = "rejected"
+	errRolledBack           = "rolled_back"
+	errNotRun               = "not_run"
+	errSearchDisabled       = "search_disabled"
+	errFeedDisabled         = "feed_disabled"
+	errUnauthorized         = "unauthorized"
+	errInvalidAPIKey        = "invalid_api_key"
+	errInsufficientScope    = "insufficient_scope"
+	errRateLimited          = "rate_limited"
+	errOriginNotAllowed     = "origin_not_allowed"
+	errInternal             = "internal_error"
+)
+
+// errorResponse is the body of every error response from the API
+type errorResponse struct {
+	Error *item.APIError `json:"error"`
+}
+
+// sendError responds with the status and an error body giving the code and
+// message
+func sendError(res http.ResponseWriter, status int, code, message string) {
+	sendAPIError(res, item.NewAPIError(status, code, message))
+}
+
+// sendParamError responds with 400 Bad Request for a missing or invalid query
+// or form param, naming the param in the error's details
+func sendParamError(res http.ResponseWriter, code, param, message string) {
+	e := item.NewAPIError(http.StatusBadRequest, code, message)
+	e.Details = map[string]string{"param": param}
+
+	sendAPIError(res, e)
+}
+
+// sendMethodError responds with 405 Method Not Allowed, listing the methods
+// allowed in the Allow header
+func sendMethodError(res http.ResponseWriter, allowed ...string) {
+	res.Header().Set("Allow", strings.Join(allowed, ", "))
+	sendError(res, http.StatusMethodNotAllowed, errMethodNotAllowed, "The request method must be "+strings.Join(allowed, " or "))
+}
+
+// sendUnknownType responds with the status for a type param naming a type which
+// isn't registered
+func sendUnknownType(res http.ResponseWriter, status int, t string) {
+	e := item.NewAPIError(status, errUnknownType, "There is no content type named "+t)
+	e.Details = map[string]string{"param": "type"}
+
+	sendAPIError(res, e)
+}
+
+// sendScopeError responds with 403 Forbidden for a request made with an API key
+// which hasn't been granted the scope for the type t
+func sendScopeError(res http.ResponseWriter, t, scope string) {
+	e := item.NewAPIError(http.StatusForbidden, errInsufficientScope, "The API key doesn't have the "+scope+" scope for "+t)
+	e.Details = map[string]string{"type": t, "scope": scope}
+
+	sendAPIError(res, e)
+}
+
+// sendInternalError responds with 500 Internal Server Error. The reason is only
+// logged, as it may reveal details of the server.
+func sendInternalError(res http.ResponseWriter) {
+	sendAPIError(res, internalError())
+}
+
+func internalError() *item.APIError {
+	return item.NewAPIError(http.StatusInternalServerError, errInternal, "The server encountered an error handling the request")
+}
+
+// sendAPIError responds with the error's status and an error body
+func sendAPIError(res http.ResponseWriter, e *item.APIError) {
+	j, err := json.Marshal(errorResponse{Error: e})
+	if err != nil {
+		log.Println("[API] error marshalling error to JSON:", err)
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// an error body applies to this request alone
+	res.Header().Set("Cache-Control", "no-store")
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Del("Content-Length")
+	res.WriteHeader(e.Status)
+	_, err = res.Write(j)
+	if err != nil {
+		log.Println("[API] error writing error response:", err)
+	}
+}
+
+// sendHookError responds with err if it is an *item.APIError returned by a hook
+// or interface method, or with 500 Internal Server Error otherwise
+func sendHookError(res http.ResponseWriter, err error) {
+	if !hookError(res, err) {
+		sendInternalError(res)
+	}
+}
+
+// hookError responds with err if it is an *item.APIError returned by a hook or
+// interface method, and reports whether it did. Other errors are left for the
+// method which returned them to have written a response status.
+func hookError(res http.ResponseWriter, err error) bool {
+	var e *item.APIError
+	if !errors.As(err, &e) {
+		return false
+	}
+
+	// hooks may return a shared error value, so a copy has its status set
+	c := *e
+	if c.Status < http.StatusBadRequest {
+		c.Status = http.StatusBadRequest
+	}
+
+	sendAPIError(res, &c)
+	return true
+}
@@ -0,0 +1,451 @@
+package api
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kudzu-cms/kudzu/management/format"
+	"github.com/kudzu-cms/kudzu/system/cfg"
+	"github.com/kudzu-cms/kudzu/system/db"
+	"github.com/kudzu-cms/kudzu/system/item"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// defaultFeedItems is the number of items in a feed if not requested
+	defaultFeedItems = 20
+
+	// maxFeedItems is the most items a feed may contain
+	maxFeedItems = 100
+
+	jsonFeedVersion = "https://jsonfeed.org/version/1.1"
+	atomNamespace   = "http://www.w3.org/2005/Atom"
+	dcNamespace     = "http://purl.org/dc/elements/1.1/"
+)
+
+// feedContentTypes are the media types of each feed format
+var feedContentTypes = map[string]string{
+	"rss":  "application/rss+xml; charset=utf-8",
+	"atom": "application/atom+xml; charset=utf-8",
+	"json": "application/feed+json; charset=utf-8",
+}
+
+// feed is the most recent content of a type, as an entry for each item, before
+// it is rendered in one of the feed formats
+type feed struct {
+	site    string
+	title   string
+	home    string
+	self    string
+	updated time.Time
+	entries []feedEntry
+}
+
+type feedEntry struct {
+	format.FeedItem
+
+	id      string
+	updated time.Time
+}
+
+func feedHandler(res http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	t := q.Get("type")
+	if t == "" {
+		sendParamError(res, errMissingParam, "type", "The type param is required")
+		return
+	}
+
+	it, ok := item.Types[t]
+	if !ok {
+		sendUnknownType(res, http.StatusNotFound, t)
+		return
+	}
+
+	if _, ok := it().(format.Feedable); !ok {
+		sendError(res, http.StatusNotFound, errFeedDisabled, "Feeds aren't enabled for content of type "+t)
+		return
+	}
+
+	if hide(res, req, it()) {
+		return
+	}
+
+	cacheControl(res, req, it())
+
+	f := strings.ToLower(q.Get("format")) // string: rss, atom or json (rss default)
+	if f == "" {
+		f = "rss"
+	}
+	contentType, ok := feedContentTypes[f]
+	if !ok {
+		sendParamError(res, errInvalidParam, "format", "The format param must be rss, atom or json")
+		return
+	}
+
+	count := defaultFeedItems // int: number of items in the feed (20 default, 100 max)
+	if c := q.Get("count"); c != "" {
+		var err error
+		count, err = strconv.Atoi(c)
+		if err != nil || count < 1 || count > maxFeedItems {
+			sendParamError(res, errInvalidParam, "count", fmt.Sprintf("The count param must be an integer from 1 to %d", maxFeedItems))
+			return
+		}
+	}
+
+	_, bb := db.Query(t+"__sorted", db.QueryOptions{
+		Count:  count,
+		Offset: 0,
+		Order:  "desc",
+	})
+
+	var result = []json.RawMessage{}
+	for i := range bb {
+		result = append(result, bb[i])
+	}
+
+	j, err := fmtJSON(result...)
+	if err != nil {
+		sendInternalError(res)
+		return
+	}
+
+	// fields omitted from the content API are left out of feeds too
+	j, err = omit(res, req, it(), j)
+	if err != nil {
+		sendHookError(res, err)
+		return
+	}
+
+	fd, err := newFeed(req, t, it, j)
+	if err != nil {
+		log.Println("[Feed] error building feed for", t, err)
+		sendInternalError(res)
+		return
+	}
+
+	var body []byte
+	switch f {
+	case "rss":
+		body, err = fd.rss()
+	case "atom":
+		body, err = fd.atom()
+	case "json":
+		body, err = fd.json()
+	}
+	if err != nil {
+		log.Println("[Feed] error rendering", f, "feed for", t, err)
+		sendInternalError(res)
+		return
+	}
+
+	res.Header().Set("Content-Type", contentType)
+	addVary(res.Header(), "Accept-Encoding")
+
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		if validate(res, req, responseEtag(req, body), fd.updated) {
+			return
+		}
+	}
+
+	_, err = res.Write(body)
+	if err != nil {
+		log.Println("[Feed] error writing response:", err)
+	}
+}
+
+// newFeed builds the feed of the items of type t in the data. Links are
+// resolved against the site's URL.
+func newFeed(req *http.Request, t string, it func() interface{}, data []byte) (*feed, error) {
+	base := requestBaseURL(req)
+	self := base.ResolveReference(&url.URL{Path: req.URL.Path, RawQuery: req.URL.RawQuery})
+
+	name, _ := db.ConfigCache("name").(string)
+	if name == "" {
+		name = "kudzu"
+	}
+
+	fd := &feed{
+		site:  name,
+		title: name + ": " + t,
+		home:  base.String(),
+		self:  self.String(),
+	}
+
+	for _, r := range gjson.GetBytes(data, "data").Array() {
+		p := it()
+		err := json.Unmarshal([]byte(r.Raw), p)
+		if err != nil {
+			return nil, err
+		}
+
+		e := feedEntry{FeedItem: p.(format.Feedable).FeedItem()}
+
+		if e.Link != "" {
+			link, err := url.Parse(e.Link)
+			if err != nil {
+				return nil, err
+			}
+			e.Link = base.ResolveReference(link).String()
+		}
+
+		if s, ok := p.(item.Sortable); ok {
+			if e.Date.IsZero() {
+				e.Date = time.Unix(0, s.Time()*int64(time.Millisecond))
+			}
+			e.updated = time.Unix(0, s.Touch()*int64(time.Millisecond))
+		}
+		if e.updated.Before(e.Date) {
+			e.updated = e.Date
+		}
+
+		if i, ok := p.(item.Identifiable); ok {
+			e.id = "urn:uuid:" + i.UniqueID().String()
+		}
+
+		if e.updated.After(fd.updated) {
+			fd.updated = e.updated
+		}
+
+		fd.entries = append(fd.entries, e)
+	}
+
+	return fd, nil
+}
+
+// requestBaseURL returns the root URL of the site, which is the Site URL set in
+// the system configuration, or else the URL the request was made to. The Host
+// header is set by the client, so links in responses which may be cached by
+// shared caches should be made with the Site URL set.
+func requestBaseURL(req *http.Request) *url.URL {
+	if v, ok := db.ConfigCache("site_url").(string); ok && strings.TrimSpace(v) != "" {
+		u, err := url.Parse(strings.TrimSpace(v))
+		if err == nil && u.Scheme != "" && u.Host != "" {
+			return &url.URL{Scheme: u.Scheme, Host: u.Host, Path: strings.TrimSuffix(u.Path, "/") + "/"}
+		}
+
+		log.Println("[Config] ignoring invalid Site URL:", v)
+	}
+
+	// a tenant worker only accepts requests from the multi-tenant front server,
+	// which serves HTTPS and forwards the scheme the client used
+	scheme := "http"
+	if req.TLS != nil || (cfg.Tenant() != "" && req.Header.Get("X-Forwarded-Proto") == "https") {
+		scheme = "https"
+	}
+
+	return &url.URL{Scheme: scheme, Host: req.Host, Path: "/"}
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	DC      string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	Self          atomLink  `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title,omitempty"`
+	Link        string  `xml:"link,omitempty"`
+	Description string  `xml:"description,omitempty"`
+	Creator     string  `xml:"dc:creator,omitempty"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate,omitempty"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// rss renders the feed as RSS 2.0
+func (fd *feed) rss() ([]byte, error) {
+	rss := rssFeed{
+		Version: "2.0",
+		Atom:    atomNamespace,
+		DC:      dcNamespace,
+		Channel: rssChannel{
+			Title:       fd.title,
+			Link:        fd.home,
+			Description: fd.title,
+			Self:        atomLink{Href: fd.self, Rel: "self", Type: "application/rss+xml"},
+		},
+	}
+	if !fd.updated.IsZero() {
+		rss.Channel.LastBuildDate = fd.updated.UTC().Format(time.RFC1123Z)
+	}
+
+	for _, e := range fd.entries {
+		i := rssItem{
+			Title:       e.Title,
+			Link:        e.Link,
+			Description: e.Summary,
+			Creator:     e.Author,
+			GUID:        rssGUID{Value: e.id},
+		}
+		if !e.Date.IsZero() {
+			i.PubDate = e.Date.UTC().Format(time.RFC1123Z)
+		}
+
+		rss.Channel.Items = append(rss.Channel.Items, i)
+	}
+
+	return marshalXML(rss)
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomPerson  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title     string      `xml:"title"`
+	ID        string      `xml:"id"`
+	Link      *atomLink   `xml:"link,omitempty"`
+	Published string      `xml:"published,omitempty"`
+	Updated   string      `xml:"updated"`
+	Summary   string      `xml:"summary,omitempty"`
+	Author    *atomPerson `xml:"author,omitempty"`
+}
+
+// atom renders the feed as Atom. The site's name is the author of entries
+// without one, as Atom requires.
+func (fd *feed) atom() ([]byte, error) {
+	updated := fd.updated
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+
+	atom := atomFeed{
+		Title:   fd.title,
+		ID:      fd.self,
+		Updated: updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: fd.self, Rel: "self", Type: "application/atom+xml"},
+			{Href: fd.home, Rel: "alternate"},
+		},
+		Author: atomPerson{Name: fd.site},
+	}
+
+	for _, e := range fd.entries {
+		a := atomEntry{
+			Title:   e.Title,
+			ID:      e.id,
+			Updated: e.updated.UTC().Format(time.RFC3339),
+			Summary: e.Summary,
+		}
+		if e.Link != "" {
+			a.Link = &atomLink{Href: e.Link, Rel: "alternate"}
+		}
+		if !e.Date.IsZero() {
+			a.Published = e.Date.UTC().Format(time.RFC3339)
+		}
+		if e.Author != "" {
+			a.Author = &atomPerson{Name: e.Author}
+		}
+
+		atom.Entries = append(atom.Entries, a)
+	}
+
+	return marshalXML(atom)
+}
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url,omitempty"`
+	Title         string           `json:"title,omitempty"`
+	Summary       string           `json:"summary,omitempty"`
+	ContentText   string           `json:"content_text"`
+	DatePublished string           `json:"date_published,omitempty"`
+	DateModified  string           `json:"date_modified,omitempty"`
+	Authors       []jsonFeedAuthor `json:"authors,omitempty"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+// json renders the feed as JSON Feed 1.1. Each item's summary is also its
+// content, as JSON Feed requires content.
+func (fd *feed) json() ([]byte, error) {
+	jf := jsonFeed{
+		Version:     jsonFeedVersion,
+		Title:       fd.title,
+		HomePageURL: fd.home,
+		FeedURL:     fd.self,
+		Items:       []jsonFeedItem{},
+	}
+
+	for _, e := range fd.entries {
+		i := jsonFeedItem{
+			ID:          e.id,
+			URL:         e.Link,
+			Title:       e.Title,
+			Summary:     e.Summary,
+			ContentText: e.Summary,
+		}
+		if !e.Date.IsZero() {
+			i.DatePublished = e.Date.UTC().Format(time.RFC3339)
+		}
+		if !e.updated.IsZero() {
+			i.DateModified = e.updated.UTC().Format(time.RFC3339)
+		}
+		if e.Author != "" {
+			i.Authors = []jsonFeedAuthor{{Name: e.Author}}
+		}
+
+		jf.Items = append(jf.Items, i)
+	}
+
+	return json.Marshal(jf)
+}
+
+func marshalXML(v interface{}) ([]byte, error) {
+	b, err := xml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), b...), nil
+}
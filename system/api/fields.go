@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// selectFields projects the JSON objects in the pathPrefix array of data to
+// only the fields named in the request's "fields" query param, such as
+// fields=title,slug,uuid. Nested fields may be selected by their path, such as
+// fields=author.name. Fields which aren't present, including fields removed by
+// an item.Omittable, are left out, so this must be called after omit.
+func selectFields(req *http.Request, data []byte, pathPrefix string) ([]byte, error) {
+	fields := parseFields(req.URL.Query().Get("fields"))
+	if len(fields) == 0 {
+		return data, nil
+	}
+
+	arr := gjson.GetBytes(data, pathPrefix)
+	if !arr.IsArray() {
+		return data, nil
+	}
+
+	// each object is selected on its own, and the array is set in data once,
+	// so the cost stays linear in the number of objects
+	var err error
+	objs := []json.RawMessage{}
+	arr.ForEach(func(_, obj gjson.Result) bool {
+		selected := []byte(`{}`)
+		for _, f := range fields {
+			v := obj.Get(f)
+			if !v.Exists() {
+				continue
+			}
+
+			selected, err = sjson.SetRawBytes(selected, f, []byte(v.Raw))
+			if err != nil {
+				return false
+			}
+		}
+
+		objs = append(objs, selected)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	j, err := json.Marshal(objs)
+	if err != nil {
+		return nil, err
+	}
+
+	return sjson.SetRawBytes(data, pathPrefix, j)
+}
+
+// parseFields splits a comma separated list of field names, dropping any which
+// contain characters gjson would treat as a query rather than a path
+func parseFields(list string) []string {
+	var fields []string
+	for _, f := range strings.Split(list, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" || strings.ContainsAny(f, `*?#|@\`) {
+			continue
+		}
+
+		fields = append(fields, f)
+	}
+
+	return fields
+}
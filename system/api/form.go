@@ -0,0 +1,252 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/kudzu-cms/kudzu/system/admin/upload"
+)
+
+// maxFormMemory is the most memory used to hold a request body's files while
+// parsing, the rest are stored in temporary files
+const maxFormMemory = 1024 * 1024 * 4 // 4MB
+
+// jsonFormField is the name of the multipart form part which may contain a JSON
+// object of field values, sent alongside files in separate parts
+const jsonFormField = "__json"
+
+// jsonFile is a file sent within a JSON request body, with its contents encoded
+// as base64
+type jsonFile struct {
+	Filename string
+	Data     []byte
+}
+
+// parseContentForm parses the body of a request to the content API into
+// req.PostForm and req.MultipartForm. Bodies may be multipart form data, or a
+// JSON object if the Content-Type is application/json. JSON values are converted
+// to the form values a client would have posted for them, so interfaces and
+// hooks see the same decoded item for either:
+//   - arrays of values are posted as multiple values for the field
+//   - objects are posted as field.key, and arrays of objects as field.N.key
+//   - files are objects with "filename" and base64 encoded "data" keys
+//
+// A multipart form may also include a JSON object in a part named __json, with
+// files sent in their own parts.
+func parseContentForm(req *http.Request) error {
+	ct, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		ct = ""
+	}
+
+	if ct == "application/json" {
+		err := req.ParseForm()
+		if err != nil {
+			return err
+		}
+
+		req.MultipartForm = &multipart.Form{
+			Value: req.PostForm,
+			File:  make(map[string][]*multipart.FileHeader),
+		}
+
+		var body map[string]interface{}
+		dec := json.NewDecoder(req.Body)
+		dec.UseNumber()
+		err = dec.Decode(&body)
+		if err != nil {
+			return fmt.Errorf("Invalid JSON request body: %s", err)
+		}
+
+		return addJSONForm(req, body)
+	}
+
+	err = req.ParseMultipartForm(maxFormMemory)
+	if err != nil {
+		return err
+	}
+
+	j := req.PostForm.Get(jsonFormField)
+	if j == "" {
+		return nil
+	}
+
+	req.PostForm.Del(jsonFormField)
+	req.Form.Del(jsonFormField)
+
+	var body map[string]interface{}
+	dec := json.NewDecoder(strings.NewReader(j))
+	dec.UseNumber()
+	err = dec.Decode(&body)
+	if err != nil {
+		return fmt.Errorf("Invalid JSON in %s form value: %s", jsonFormField, err)
+	}
+
+	return addJSONForm(req, body)
+}
+
+// prepareContentForm stores any files sent with the request, setting their
+// fields to the URLs of the stored files, and formats multi-value fields for
+// storage
+func prepareContentForm(req *http.Request) error {
+	urlPaths, err := upload.StoreFiles(req)
+	if err != nil {
+		return err
+	}
+
+	for name, urlPath := range urlPaths {
+		req.PostForm.Set(name, urlPath)
+	}
+
+	normalizeFormFields(req.PostForm)
+
+	return nil
+}
+
+// addJSONForm adds the values of a JSON object to the request's form values,
+// and any files within it to the request's multipart form
+func addJSONForm(req *http.Request, body map[string]interface{}) error {
+	values := url.Values{}
+	files := make(map[string]jsonFile)
+	for k, v := range body {
+		err := formValues(k, v, values, files)
+		if err != nil {
+			return err
+		}
+	}
+
+	for k, vv := range values {
+		req.PostForm[k] = vv
+		req.Form[k] = vv
+	}
+
+	if len(files) == 0 {
+		return nil
+	}
+
+	// multipart.FileHeader can only be created by reading a multipart form, so
+	// write the files into one and read them back
+	buf := &bytes.Buffer{}
+	mw := multipart.NewWriter(buf)
+	for name, f := range files {
+		part, err := mw.CreateFormFile(name, f.Filename)
+		if err != nil {
+			return err
+		}
+
+		_, err = part.Write(f.Data)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := mw.Close()
+	if err != nil {
+		return err
+	}
+
+	form, err := multipart.NewReader(buf, mw.Boundary()).ReadForm(maxFormMemory)
+	if err != nil {
+		return err
+	}
+
+	for name, fhs := range form.File {
+		req.MultipartForm.File[name] = fhs
+	}
+
+	return nil
+}
+
+// formValues adds the form values for the JSON value v, posted as the field
+// named key, to values. If files is not nil, file objects are added to it
+// rather than posted as fields.
+func formValues(key string, v interface{}, values url.Values, files map[string]jsonFile) error {
+	switch v := v.(type) {
+	case nil:
+		values.Set(key, "")
+
+	case string:
+		values.Add(key, v)
+
+	case bool:
+		values.Add(key, strconv.FormatBool(v))
+
+	case json.Number:
+		values.Add(key, v.String())
+
+	case int:
+		values.Add(key, strconv.Itoa(v))
+
+	case float64:
+		values.Add(key, strconv.FormatFloat(v, 'f', -1, 64))
+
+	case []interface{}:
+		for i, elem := range v {
+			switch elem.(type) {
+			case map[string]interface{}, []interface{}:
+				err := formValues(fmt.Sprintf("%s.%d", key, i), elem, values, files)
+				if err != nil {
+					return err
+				}
+
+			case nil:
+				// a null element is an empty value in the list, and mustn't
+				// clear the elements before it as a null field does
+				values.Add(key, "")
+
+			default:
+				err := formValues(key, elem, values, files)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+	case map[string]interface{}:
+		if files != nil && isJSONFile(v) {
+			data, err := base64.StdEncoding.DecodeString(v["data"].(string))
+			if err != nil {
+				return fmt.Errorf("Invalid base64 data for file in field %s: %s", key, err)
+			}
+
+			files[key] = jsonFile{
+				Filename: v["filename"].(string),
+				Data:     data,
+			}
+			return nil
+		}
+
+		for k, elem := range v {
+			err := formValues(key+"."+k, elem, values, files)
+			if err != nil {
+				return err
+			}
+		}
+
+	default:
+		return fmt.Errorf("Unsupported value for field %s: %v", key, v)
+	}
+
+	return nil
+}
+
+// isJSONFile reports whether a JSON object describes a file, by having only
+// string "filename" and "data" keys
+func isJSONFile(v map[string]interface{}) bool {
+	if len(v) != 2 {
+		return false
+	}
+
+	_, name := v["filename"].(string)
+	_, data := v["data"].(string)
+
+	return name && data
+}
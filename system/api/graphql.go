@@ -0,0 +1,786 @@
+package api
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kudzu-cms/kudzu/system/db"
+	"github.com/kudzu-cms/kudzu/system/item"
+	"github.com/kudzu-cms/kudzu/system/search"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+var (
+	graphqlSchema    graphql.Schema
+	graphqlSchemaErr error
+	graphqlOnce      = &sync.Once{}
+
+	// graphql names must match /[_A-Za-z][_0-9A-Za-z]*/, so fields with json
+	// tags which can't be used as a name are left out of the schema
+	graphqlName = regexp.MustCompile(`^[_A-Za-z][_0-9A-Za-z]*$`)
+
+	errGraphQLNotFound = errors.New("Not Found")
+
+	textMarshaler = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+type graphqlContextKey struct{}
+
+// graphqlRequest is the body of a GraphQL request sent as application/json
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// graphqlContext holds the request being served while its fields are resolved,
+// and the AfterAPIResponse hooks to call once the response has been sent
+type graphqlContext struct {
+	res   http.ResponseWriter
+	req   *http.Request
+	after []func()
+}
+
+// graphqlResponseWriter is passed to interfaces and hooks called while resolving
+// a field. Response headers are set on the GraphQL response, but any status is
+// recorded to be reported as an error on the field, and any body is discarded,
+// since a single response may contain the results of many fields. An error body
+// is kept, so its code and message are reported instead.
+type graphqlResponseWriter struct {
+	http.ResponseWriter
+	status int
+	apiErr *item.APIError
+}
+
+func (w *graphqlResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *graphqlResponseWriter) Write(b []byte) (int, error) {
+	if w.status >= http.StatusBadRequest && w.apiErr == nil {
+		var e errorResponse
+		if json.Unmarshal(b, &e) == nil && e.Error != nil {
+			w.apiErr = e.Error
+		}
+	}
+
+	return len(b), nil
+}
+
+// graphqlError is an error reported on a field from the error body written while
+// resolving it, with its code and details as the error's extensions
+type graphqlError struct {
+	*item.APIError
+}
+
+func (e graphqlError) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{"code": e.Code}
+	if e.Details != nil {
+		ext["details"] = e.Details
+	}
+
+	return ext
+}
+
+// err returns an error for a field from the status written while resolving it,
+// falling back to the error provided
+func (w *graphqlResponseWriter) err(err error) error {
+	if w.apiErr != nil {
+		return graphqlError{w.apiErr}
+	}
+
+	if w.status >= http.StatusBadRequest {
+		return errors.New(http.StatusText(w.status))
+	}
+
+	return err
+}
+
+func graphqlHandler(res http.ResponseWriter, req *http.Request) {
+	var gr graphqlRequest
+	switch req.Method {
+	case http.MethodGet:
+		q := req.URL.Query()
+		gr.Query = q.Get("query")
+		gr.OperationName = q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			err := json.Unmarshal([]byte(v), &gr.Variables)
+			if err != nil {
+				sendParamError(res, errInvalidParam, "variables", "The variables param must be a JSON object")
+				return
+			}
+		}
+
+	case http.MethodPost:
+		err := json.NewDecoder(req.Body).Decode(&gr)
+		if err != nil {
+			log.Println("[GraphQL] error decoding request:", err)
+			sendError(res, http.StatusBadRequest, errInvalidBody, "The request body must be a JSON object with a query")
+			return
+		}
+
+	default:
+		sendMethodError(res, http.MethodGet, http.MethodPost)
+		return
+	}
+
+	if gr.Query == "" {
+		sendParamError(res, errMissingParam, "query", "The query is required")
+		return
+	}
+
+	// mutations must be sent as POST requests, so they can't be made from links
+	// or cached by proxies
+	if req.Method == http.MethodGet && isMutation(gr.Query, gr.OperationName) {
+		sendMethodError(res, http.MethodPost)
+		return
+	}
+
+	graphqlOnce.Do(func() {
+		graphqlSchema, graphqlSchemaErr = buildGraphQLSchema()
+	})
+	if graphqlSchemaErr != nil {
+		log.Println("[GraphQL] error building schema:", graphqlSchemaErr)
+		sendInternalError(res)
+		return
+	}
+
+	gc := &graphqlContext{res: res, req: req}
+	result := graphql.Do(graphql.Params{
+		Schema:         graphqlSchema,
+		RequestString:  gr.Query,
+		VariableValues: gr.Variables,
+		OperationName:  gr.OperationName,
+		Context:        context.WithValue(req.Context(), graphqlContextKey{}, gc),
+	})
+
+	j, err := json.Marshal(result)
+	if err != nil {
+		log.Println("[GraphQL] error marshalling response to JSON:", err)
+		sendInternalError(res)
+		return
+	}
+
+	sendData(res, req, j)
+
+	// hook after response
+	for _, fn := range gc.after {
+		fn()
+	}
+}
+
+// isMutation reports whether the operation to be executed from a GraphQL query
+// is a mutation
+func isMutation(query, operationName string) bool {
+	doc, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		// invalid queries are reported by graphql.Do
+		return false
+	}
+
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+
+		if operationName != "" && (op.Name == nil || op.Name.Value != operationName) {
+			continue
+		}
+
+		if op.Operation == ast.OperationTypeMutation {
+			return true
+		}
+	}
+
+	return false
+}
+
+// buildGraphQLSchema generates a schema from the registered content types. For
+// each type, e.g. Song, the schema has:
+//   - Song(id: Int, slug: String): Song
+//   - allSong(count: Int, offset: Int, order: String, search: String): [Song]
+//   - createSong(input: SongInput!): ContentStatus, if Song is Createable
+//   - updateSong(id: Int!, input: SongInput!): ContentStatus, if Song is Updateable
+//   - deleteSong(id: Int!): ContentStatus, if Song is Deleteable
+func buildGraphQLSchema() (graphql.Schema, error) {
+	status := graphql.NewObject(graphql.ObjectConfig{
+		Name: "ContentStatus",
+		Fields: graphql.Fields{
+			"id":     &graphql.Field{Type: graphql.Int},
+			"status": &graphql.Field{Type: graphql.String},
+			"type":   &graphql.Field{Type: graphql.String},
+		},
+	})
+
+	query := graphql.Fields{}
+	mutation := graphql.Fields{}
+
+	// sort type names so the schema is the same each time it is generated
+	var names []string
+	for t := range item.Types {
+		if graphqlName.MatchString(t) {
+			names = append(names, t)
+		}
+	}
+	sort.Strings(names)
+
+	for _, t := range names {
+		it := item.Types[t]
+		rt := reflect.TypeOf(it())
+		for rt.Kind() == reflect.Ptr {
+			rt = rt.Elem()
+		}
+
+		if rt.Kind() != reflect.Struct {
+			continue
+		}
+
+		object := graphql.Fields{}
+		input := graphql.InputObjectConfigFieldMap{}
+		graphqlFields(rt, object, input)
+
+		obj := graphql.NewObject(graphql.ObjectConfig{
+			Name:   t,
+			Fields: object,
+		})
+
+		query[t] = &graphql.Field{
+			Type: obj,
+			Args: graphql.FieldConfigArgument{
+				"id":   &graphql.ArgumentConfig{Type: graphql.Int},
+				"slug": &graphql.ArgumentConfig{Type: graphql.String},
+			},
+			Resolve: resolveContent(t),
+		}
+
+		query["all"+t] = &graphql.Field{
+			Type: graphql.NewList(obj),
+			Args: graphql.FieldConfigArgument{
+				"count":  &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 10},
+				"offset": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+				"order":  &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: "desc"},
+				"search": &graphql.ArgumentConfig{Type: graphql.String},
+			},
+			Resolve: resolveContents(t),
+		}
+
+		post := it()
+		_, createable := post.(Createable)
+		_, updateable := post.(Updateable)
+		_, deleteable := post.(Deleteable)
+
+		if (createable || updateable) && len(input) > 0 {
+			in := graphql.NewInputObject(graphql.InputObjectConfig{
+				Name:   t + "Input",
+				Fields: input,
+			})
+
+			if createable {
+				mutation["create"+t] = &graphql.Field{
+					Type: status,
+					Args: graphql.FieldConfigArgument{
+						"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(in)},
+					},
+					Resolve: resolveCreate(t),
+				}
+			}
+
+			if updateable {
+				mutation["update"+t] = &graphql.Field{
+					Type: status,
+					Args: graphql.FieldConfigArgument{
+						"id":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+						"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(in)},
+					},
+					Resolve: resolveUpdate(t),
+				}
+			}
+		}
+
+		if deleteable {
+			mutation["delete"+t] = &graphql.Field{
+				Type: status,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: resolveDelete(t),
+			}
+		}
+	}
+
+	if len(query) == 0 {
+		return graphql.Schema{}, errors.New("No content types with names usable in a GraphQL schema")
+	}
+
+	config := graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name:   "Query",
+			Fields: query,
+		}),
+	}
+
+	if len(mutation) > 0 {
+		config.Mutation = graphql.NewObject(graphql.ObjectConfig{
+			Name:   "Mutation",
+			Fields: mutation,
+		})
+	}
+
+	return graphql.NewSchema(config)
+}
+
+// graphqlFields adds a field to object for each json-tagged field of the struct
+// type rt, including those of embedded structs such as item.Item. Fields other
+// than those set by the system are also added to input, for use in mutations.
+func graphqlFields(rt reflect.Type, object graphql.Fields, input graphql.InputObjectConfigFieldMap) {
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+
+			if ft.Kind() == reflect.Struct {
+				graphqlFields(ft, object, input)
+				continue
+			}
+		}
+
+		if f.PkgPath != "" || name == "-" {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		if !graphqlName.MatchString(name) {
+			continue
+		}
+
+		typ := graphqlType(f.Type)
+		object[name] = &graphql.Field{Type: typ}
+
+		switch name {
+		case "uuid", "id", "timestamp", "updated":
+			// set by the system when content is stored
+		default:
+			input[name] = &graphql.InputObjectFieldConfig{Type: typ}
+		}
+	}
+}
+
+// graphqlType returns the GraphQL type for a struct field type. GraphQL Int is
+// 32-bit, so 64-bit integers such as timestamps are exposed as Float. Types with
+// no GraphQL equivalent are exposed as the JSON scalar.
+func graphqlType(rt reflect.Type) graphql.Type {
+	if rt.Implements(textMarshaler) || reflect.PtrTo(rt).Implements(textMarshaler) {
+		return graphql.String
+	}
+
+	switch rt.Kind() {
+	case reflect.String:
+		return graphql.String
+
+	case reflect.Bool:
+		return graphql.Boolean
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint8, reflect.Uint16:
+		return graphql.Int
+
+	case reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return graphql.Float
+
+	case reflect.Ptr:
+		return graphqlType(rt.Elem())
+
+	case reflect.Slice, reflect.Array:
+		if rt.Elem().Kind() == reflect.Uint8 {
+			// []byte is encoded as a base64 string
+			return graphql.String
+		}
+
+		return graphql.NewList(graphqlType(rt.Elem()))
+	}
+
+	return graphqlJSON
+}
+
+// graphqlJSON is a scalar for values with no GraphQL equivalent, such as maps
+// and nested structs, which are sent as they would be in the JSON content API
+var graphqlJSON = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "JSON",
+	Description: "Any JSON value",
+	Serialize: func(value interface{}) interface{} {
+		return value
+	},
+	ParseValue: func(value interface{}) interface{} {
+		return value
+	},
+	ParseLiteral: graphqlLiteral,
+})
+
+// graphqlLiteral converts a value written in a GraphQL query to the value it
+// would have been decoded as from JSON
+func graphqlLiteral(v ast.Value) interface{} {
+	switch v := v.(type) {
+	case *ast.StringValue:
+		return v.Value
+	case *ast.EnumValue:
+		return v.Value
+	case *ast.BooleanValue:
+		return v.Value
+	case *ast.IntValue:
+		n, _ := strconv.ParseFloat(v.Value, 64)
+		return n
+	case *ast.FloatValue:
+		n, _ := strconv.ParseFloat(v.Value, 64)
+		return n
+	case *ast.ListValue:
+		list := make([]interface{}, 0, len(v.Values))
+		for _, lv := range v.Values {
+			list = append(list, graphqlLiteral(lv))
+		}
+		return list
+	case *ast.ObjectValue:
+		obj := make(map[string]interface{}, len(v.Fields))
+		for _, f := range v.Fields {
+			obj[f.Name.Value] = graphqlLiteral(f.Value)
+		}
+		return obj
+	}
+
+	return nil
+}
+
+func resolveContent(t string) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		gc := p.Context.Value(graphqlContextKey{}).(*graphqlContext)
+		w := &graphqlResponseWriter{ResponseWriter: gc.res}
+
+		var post []byte
+		if slug, ok := p.Args["slug"].(string); ok {
+			st, b, err := db.ContentBySlug(slug)
+			if st == "" || st != t {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			post = b
+		} else if id, ok := p.Args["id"].(int); ok {
+			b, err := db.Content(fmt.Sprintf("%s:%d", t, id))
+			if err != nil {
+				return nil, err
+			}
+
+			post = b
+		} else {
+			return nil, errors.New("Either id or slug must be provided")
+		}
+
+		if len(post) == 0 {
+			return nil, nil
+		}
+
+		it := item.Types[t]()
+		err := json.Unmarshal(post, it)
+		if err != nil {
+			return nil, err
+		}
+
+		if hide(w, gc.req, it) {
+			return nil, w.err(errGraphQLNotFound)
+		}
+
+		data, err := gc.respond(w, it, post)
+		if err != nil || len(data) == 0 {
+			return nil, err
+		}
+
+		return data[0], nil
+	}
+}
+
+func resolveContents(t string) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		gc := p.Context.Value(graphqlContextKey{}).(*graphqlContext)
+		w := &graphqlResponseWriter{ResponseWriter: gc.res}
+
+		it := item.Types[t]()
+		if hide(w, gc.req, it) {
+			return nil, w.err(errGraphQLNotFound)
+		}
+
+		count, _ := p.Args["count"].(int)
+		offset, _ := p.Args["offset"].(int)
+
+		var bb [][]byte
+		if q, ok := p.Args["search"].(string); ok && q != "" {
+			matches, err := search.TypeQuery(t, q, count, offset)
+			if err != nil {
+				return nil, err
+			}
+
+			bb, err = db.ContentMulti(matches)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			order, _ := p.Args["order"].(string)
+			order = strings.ToLower(order)
+			if order != "asc" {
+				order = "desc"
+			}
+
+			_, bb = db.Query(t+"__sorted", db.QueryOptions{
+				Count:  count,
+				Offset: offset,
+				Order:  order,
+			})
+		}
+
+		return gc.respond(w, it, bb...)
+	}
+}
+
+// respond applies the same omissions and hooks to content resolved for a field
+// as would be applied to an API response containing it, and returns the content
+// for the field to be resolved from
+func (gc *graphqlContext) respond(w *graphqlResponseWriter, it interface{}, bb ...[]byte) ([]map[string]interface{}, error) {
+	var result = []json.RawMessage{}
+	for i := range bb {
+		result = append(result, bb[i])
+	}
+
+	j, err := fmtJSON(result...)
+	if err != nil {
+		return nil, err
+	}
+
+	j, err = omit(w, gc.req, it, j)
+	if err != nil {
+		return nil, w.err(err)
+	}
+
+	// assert hookable
+	hook, ok := it.(item.Hookable)
+	if !ok {
+		return nil, fmt.Errorf("Type does not implement item.Hookable or embed item.Item")
+	}
+
+	// hook before response
+	j, err = hook.BeforeAPIResponse(w, gc.req, j)
+	if err != nil {
+		log.Println("[Response] error calling BeforeAPIResponse:", err)
+		return nil, w.err(err)
+	}
+	if w.status >= http.StatusBadRequest {
+		return nil, w.err(nil)
+	}
+
+	// hook after response, once the GraphQL response has been sent
+	gc.after = append(gc.after, func() {
+		err := hook.AfterAPIResponse(gc.res, gc.req, j)
+		if err != nil {
+			log.Println("[Response] error calling AfterAPIResponse:", err)
+		}
+	})
+
+	var resp struct {
+		Data []map[string]interface{} `json:"data"`
+	}
+	err = json.Unmarshal(j, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Data, nil
+}
+
+func resolveCreate(t string) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		gc := p.Context.Value(graphqlContextKey{}).(*graphqlContext)
+		w := &graphqlResponseWriter{ResponseWriter: gc.res}
+
+		if e := limitType(gc.req, t); e != nil {
+			return nil, graphqlError{e}
+		}
+
+		values, err := graphqlValues(p.Args["input"])
+		if err != nil {
+			return nil, err
+		}
+
+		ts := fmt.Sprintf("%d", time.Now().UnixNano()/int64(time.Millisecond))
+		values.Set("timestamp", ts)
+		values.Set("updated", ts)
+
+		req := gc.formRequest(values, url.Values{"type": {t}})
+		id, spec, err := createContent(w, req, t, item.Types[t]())
+		if err != nil {
+			return nil, w.err(err)
+		}
+
+		if spec != "" {
+			return map[string]interface{}{
+				"status": strings.TrimPrefix(spec, "__"),
+				"type":   t,
+			}, nil
+		}
+
+		return map[string]interface{}{
+			"id":     id,
+			"status": "public",
+			"type":   t,
+		}, nil
+	}
+}
+
+func resolveUpdate(t string) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		gc := p.Context.Value(graphqlContextKey{}).(*graphqlContext)
+		w := &graphqlResponseWriter{ResponseWriter: gc.res}
+
+		if e := limitType(gc.req, t); e != nil {
+			return nil, graphqlError{e}
+		}
+
+		id := strconv.Itoa(p.Args["id"].(int))
+		post, err := graphqlExisting(t, id)
+		if err != nil {
+			return nil, err
+		}
+
+		values, err := graphqlValues(p.Args["input"])
+		if err != nil {
+			return nil, err
+		}
+
+		values.Set("updated", fmt.Sprintf("%d", time.Now().UnixNano()/int64(time.Millisecond)))
+
+		req := gc.formRequest(values, url.Values{"type": {t}, "id": {id}})
+		err = updateContent(w, req, t, id, post, false)
+		if err != nil {
+			return nil, w.err(err)
+		}
+
+		return map[string]interface{}{
+			"id":     p.Args["id"],
+			"status": "public",
+			"type":   t,
+		}, nil
+	}
+}
+
+func resolveDelete(t string) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		gc := p.Context.Value(graphqlContextKey{}).(*graphqlContext)
+		w := &graphqlResponseWriter{ResponseWriter: gc.res}
+
+		if e := limitType(gc.req, t); e != nil {
+			return nil, graphqlError{e}
+		}
+
+		id := strconv.Itoa(p.Args["id"].(int))
+		post, err := graphqlExisting(t, id)
+		if err != nil {
+			return nil, err
+		}
+
+		req := gc.formRequest(url.Values{}, url.Values{"type": {t}, "id": {id}})
+		err = deleteContent(w, req, t, id, post)
+		if err != nil {
+			return nil, w.err(err)
+		}
+
+		return map[string]interface{}{
+			"id":     p.Args["id"],
+			"status": "deleted",
+			"type":   t,
+		}, nil
+	}
+}
+
+// graphqlExisting returns an item of type t populated with the stored content
+// with the id provided, or errGraphQLNotFound if there is none
+func graphqlExisting(t, id string) (interface{}, error) {
+	b, err := db.Content(t + ":" + id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) == 0 {
+		return nil, errGraphQLNotFound
+	}
+
+	post := item.Types[t]()
+	err = json.Unmarshal(b, post)
+	if err != nil {
+		return nil, err
+	}
+
+	return post, nil
+}
+
+// formRequest returns a copy of the GraphQL request as if the values provided
+// had been posted as a form to a URL with the query provided, so interfaces and
+// hooks see mutations the same way as requests to the content API
+func (gc *graphqlContext) formRequest(values url.Values, query url.Values) *http.Request {
+	req := gc.req.Clone(gc.req.Context())
+	req.Method = http.MethodPost
+	req.URL.RawQuery = query.Encode()
+	req.Form = values
+	req.PostForm = values
+	req.MultipartForm = &multipart.Form{
+		Value: values,
+		File:  make(map[string][]*multipart.FileHeader),
+	}
+
+	return req
+}
+
+// graphqlValues converts a mutation's input object to the form values which
+// would be posted for it, as for a JSON request body to the content API
+func graphqlValues(input interface{}) (url.Values, error) {
+	values := url.Values{}
+	fields, ok := input.(map[string]interface{})
+	if !ok {
+		return values, nil
+	}
+
+	for name, v := range fields {
+		err := formValues(name, v, values, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
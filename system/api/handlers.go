@@ -26,7 +26,7 @@ func typesHandler(res http.ResponseWriter, req *http.Request) {
 
 	j, err := toJSON(types)
 	if err != nil {
-		res.WriteHeader(http.StatusInternalServerError)
+		sendInternalError(res)
 		return
 	}
 
@@ -37,13 +37,13 @@ func contentsHandler(res http.ResponseWriter, req *http.Request) {
 	q := req.URL.Query()
 	t := q.Get("type")
 	if t == "" {
-		res.WriteHeader(http.StatusBadRequest)
+		sendParamError(res, errMissingParam, "type", "The type param is required")
 		return
 	}
 
 	it, ok := item.Types[t]
 	if !ok {
-		res.WriteHeader(http.StatusNotFound)
+		sendUnknownType(res, http.StatusNotFound, t)
 		return
 	}
 
@@ -51,12 +51,14 @@ func contentsHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	cacheControl(res, req, it())
+
 	count, err := strconv.Atoi(q.Get("count")) // int: determines number of posts to return (10 default, -1 is all)
 	if err != nil {
 		if q.Get("count") == "" {
 			count = 10
 		} else {
-			res.WriteHeader(http.StatusInternalServerError)
+			sendParamError(res, errInvalidParam, "count", "The count param must be an integer")
 			return
 		}
 	}
@@ -66,7 +68,7 @@ func contentsHandler(res http.ResponseWriter, req *http.Request) {
 		if q.Get("offset") == "" {
 			offset = 0
 		} else {
-			res.WriteHeader(http.StatusInternalServerError)
+			sendParamError(res, errInvalidParam, "offset", "The offset param must be an integer")
 			return
 		}
 	}
@@ -76,6 +78,25 @@ func contentsHandler(res http.ResponseWriter, req *http.Request) {
 		order = "desc"
 	}
 
+	// the version is read first, so a response rendered from content written
+	// meanwhile is discarded
+	key := responseCacheKey(req, t, it())
+	version := db.ContentVersion(t)
+	if j, header, ok := responses.get(key, version); ok {
+		// BeforeAPIResponse isn't called again, so the headers it set when the
+		// response was rendered are sent in its place
+		replayHeader(res, header)
+		sendData(res, req, j)
+
+		if hook, ok := it().(item.Hookable); ok {
+			err := hook.AfterAPIResponse(res, req, j)
+			if err != nil {
+				log.Println("[Response] error calling AfterAPIResponse:", err)
+			}
+		}
+		return
+	}
+
 	opts := db.QueryOptions{
 		Count:  count,
 		Offset: offset,
@@ -90,13 +111,19 @@ func contentsHandler(res http.ResponseWriter, req *http.Request) {
 
 	j, err := fmtJSON(result...)
 	if err != nil {
-		res.WriteHeader(http.StatusInternalServerError)
+		sendInternalError(res)
 		return
 	}
 
 	j, err = omit(res, req, it(), j)
 	if err != nil {
-		res.WriteHeader(http.StatusInternalServerError)
+		sendHookError(res, err)
+		return
+	}
+
+	j, err = selectFields(req, j, "data")
+	if err != nil {
+		sendInternalError(res)
 		return
 	}
 
@@ -105,18 +132,21 @@ func contentsHandler(res http.ResponseWriter, req *http.Request) {
 	hook, ok := get.(item.Hookable)
 	if !ok {
 		log.Println("[Response] error: Type", t, "does not implement item.Hookable or embed item.Item.")
-		res.WriteHeader(http.StatusBadRequest)
+		sendInternalError(res)
 		return
 	}
 
 	// hook before response
+	header := res.Header().Clone()
 	j, err = hook.BeforeAPIResponse(res, req, j)
 	if err != nil {
 		log.Println("[Response] error calling BeforeAPIResponse:", err)
-		res.WriteHeader(http.StatusInternalServerError)
+		sendHookError(res, err)
 		return
 	}
 
+	responses.put(key, version, j, headerChanges(header, res.Header()))
+
 	sendData(res, req, j)
 
 	// hook after response
@@ -138,27 +168,37 @@ func contentHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if t == "" || id == "" {
-		res.WriteHeader(http.StatusBadRequest)
+	if t == "" {
+		sendParamError(res, errMissingParam, "type", "The type param is required")
+		return
+	}
+
+	if id == "" {
+		sendParamError(res, errMissingParam, "id", "The id or slug param is required")
 		return
 	}
 
 	pt, ok := item.Types[t]
 	if !ok {
-		res.WriteHeader(http.StatusNotFound)
+		sendUnknownType(res, http.StatusNotFound, t)
 		return
 	}
 
 	post, err := db.Content(t + ":" + id)
 	if err != nil {
-		res.WriteHeader(http.StatusInternalServerError)
+		sendInternalError(res)
+		return
+	}
+
+	if len(post) == 0 {
+		sendError(res, http.StatusNotFound, errNotFound, "There is no "+t+" content with the id "+id)
 		return
 	}
 
 	p := pt()
 	err = json.Unmarshal(post, p)
 	if err != nil {
-		res.WriteHeader(http.StatusInternalServerError)
+		sendInternalError(res)
 		return
 	}
 
@@ -166,17 +206,25 @@ func contentHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	cacheControl(res, req, p)
+
 	push(res, req, p, post)
 
 	j, err := fmtJSON(json.RawMessage(post))
 	if err != nil {
-		res.WriteHeader(http.StatusInternalServerError)
+		sendInternalError(res)
 		return
 	}
 
 	j, err = omit(res, req, p, j)
 	if err != nil {
-		res.WriteHeader(http.StatusInternalServerError)
+		sendHookError(res, err)
+		return
+	}
+
+	j, err = selectFields(req, j, "data")
+	if err != nil {
+		sendInternalError(res)
 		return
 	}
 
@@ -185,7 +233,7 @@ func contentHandler(res http.ResponseWriter, req *http.Request) {
 	hook, ok := get.(item.Hookable)
 	if !ok {
 		log.Println("[Response] error: Type", t, "does not implement item.Hookable or embed item.Item.")
-		res.WriteHeader(http.StatusBadRequest)
+		sendInternalError(res)
 		return
 	}
 
@@ -193,7 +241,7 @@ func contentHandler(res http.ResponseWriter, req *http.Request) {
 	j, err = hook.BeforeAPIResponse(res, req, j)
 	if err != nil {
 		log.Println("[Response] error calling BeforeAPIResponse:", err)
-		res.WriteHeader(http.StatusInternalServerError)
+		sendHookError(res, err)
 		return
 	}
 
@@ -211,21 +259,25 @@ func contentHandlerBySlug(res http.ResponseWriter, req *http.Request) {
 	slug := req.URL.Query().Get("slug")
 
 	if slug == "" {
-		res.WriteHeader(http.StatusBadRequest)
+		sendParamError(res, errMissingParam, "slug", "The slug param is required")
 		return
 	}
 
 	// lookup type:id by slug key in __contentIndex
 	t, post, err := db.ContentBySlug(slug)
+	if t == "" {
+		sendError(res, http.StatusNotFound, errNotFound, "There is no content with the slug "+slug)
+		return
+	}
 	if err != nil {
 		log.Println("Error finding content by slug:", slug, err)
-		res.WriteHeader(http.StatusInternalServerError)
+		sendInternalError(res)
 		return
 	}
 
 	it, ok := item.Types[t]
-	if !ok {
-		res.WriteHeader(http.StatusBadRequest)
+	if !ok || len(post) == 0 {
+		sendError(res, http.StatusNotFound, errNotFound, "There is no content with the slug "+slug)
 		return
 	}
 
@@ -233,6 +285,7 @@ func contentHandlerBySlug(res http.ResponseWriter, req *http.Request) {
 	err = json.Unmarshal(post, p)
 	if err != nil {
 		log.Println(err)
+		sendInternalError(res)
 		return
 	}
 
@@ -240,17 +293,25 @@ func contentHandlerBySlug(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	cacheControl(res, req, p)
+
 	push(res, req, p, post)
 
 	j, err := fmtJSON(json.RawMessage(post))
 	if err != nil {
-		res.WriteHeader(http.StatusInternalServerError)
+		sendInternalError(res)
 		return
 	}
 
 	j, err = omit(res, req, p, j)
 	if err != nil {
-		res.WriteHeader(http.StatusInternalServerError)
+		sendHookError(res, err)
+		return
+	}
+
+	j, err = selectFields(req, j, "data")
+	if err != nil {
+		sendInternalError(res)
 		return
 	}
 
@@ -259,7 +320,7 @@ func contentHandlerBySlug(res http.ResponseWriter, req *http.Request) {
 	hook, ok := get.(item.Hookable)
 	if !ok {
 		log.Println("[Response] error: Type", t, "does not implement item.Hookable or embed item.Item.")
-		res.WriteHeader(http.StatusBadRequest)
+		sendInternalError(res)
 		return
 	}
 
@@ -267,7 +328,7 @@ func contentHandlerBySlug(res http.ResponseWriter, req *http.Request) {
 	j, err = hook.BeforeAPIResponse(res, req, j)
 	if err != nil {
 		log.Println("[Response] error calling BeforeAPIResponse:", err)
-		res.WriteHeader(http.StatusInternalServerError)
+		sendHookError(res, err)
 		return
 	}
 
@@ -284,20 +345,20 @@ func contentHandlerBySlug(res http.ResponseWriter, req *http.Request) {
 
 func uploadsHandler(res http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodGet {
-		res.WriteHeader(http.StatusMethodNotAllowed)
+		sendMethodError(res, http.MethodGet)
 		return
 	}
 
 	slug := req.URL.Query().Get("slug")
 	if slug == "" {
-		res.WriteHeader(http.StatusBadRequest)
+		sendParamError(res, errMissingParam, "slug", "The slug param is required")
 		return
 	}
 
 	upload, err := db.UploadBySlug(slug)
 	if err != nil {
 		log.Println("Error finding upload by slug:", slug, err)
-		res.WriteHeader(http.StatusNotFound)
+		sendError(res, http.StatusNotFound, errNotFound, "There is no upload with the slug "+slug)
 		return
 	}
 
@@ -310,13 +371,13 @@ func uploadsHandler(res http.ResponseWriter, req *http.Request) {
 	j, err := fmtJSON(json.RawMessage(upload))
 	if err != nil {
 		log.Println("Error fmtJSON on upload:", err)
-		res.WriteHeader(http.StatusInternalServerError)
+		sendInternalError(res)
 		return
 	}
 
 	j, err = omit(res, req, it(), j)
 	if err != nil {
-		res.WriteHeader(http.StatusInternalServerError)
+		sendHookError(res, err)
 		return
 	}
 
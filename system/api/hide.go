@@ -2,11 +2,32 @@ package api
 
 import (
 	"net/http"
+	"reflect"
+	"sync"
 
+	"github.com/kudzu-cms/kudzu/system/admin/apikey"
 	"github.com/kudzu-cms/kudzu/system/item"
 )
 
+var (
+	typeNames     map[reflect.Type]string
+	typeNamesOnce sync.Once
+)
+
 func hide(res http.ResponseWriter, req *http.Request, it interface{}) bool {
+	// requests made with an API key need its scopes to read the type
+	if k := apikey.FromContext(req.Context()); k != nil {
+		t := typeName(it)
+		if !k.Allows(t, apikey.ScopeRead) {
+			sendScopeError(res, t, apikey.ScopeRead)
+			return true
+		}
+
+		if k.Allows(t, apikey.ScopeReadHidden) {
+			return false
+		}
+	}
+
 	// check if should be hidden
 	if h, ok := it.(item.Hideable); ok {
 		err := h.Hide(res, req)
@@ -15,13 +36,66 @@ func hide(res http.ResponseWriter, req *http.Request, it interface{}) bool {
 		}
 
 		if err != nil {
-			res.WriteHeader(http.StatusInternalServerError)
+			sendHookError(res, err)
 			return true
 		}
 
-		res.WriteHeader(http.StatusNotFound)
+		sendError(res, http.StatusNotFound, errNotFound, "The content requested was not found")
 		return true
 	}
 
 	return false
 }
+
+// isHidden reports whether content of the type t is hidden from the request,
+// without writing a response status as hide does. It's used where many types
+// are listed together, so hidden types can be left out.
+func isHidden(res http.ResponseWriter, req *http.Request, t string, it interface{}) bool {
+	if k := apikey.FromContext(req.Context()); k != nil {
+		if !k.Allows(t, apikey.ScopeRead) {
+			return true
+		}
+
+		if k.Allows(t, apikey.ScopeReadHidden) {
+			return false
+		}
+	}
+
+	if h, ok := it.(item.Hideable); ok {
+		return h.Hide(res, req) != item.ErrAllowHiddenItem
+	}
+
+	return false
+}
+
+// keyAllows reports whether the API key a request was made with, if any, has
+// been granted the scope for the type t
+func keyAllows(req *http.Request, t, scope string) bool {
+	k := apikey.FromContext(req.Context())
+	if k == nil {
+		return true
+	}
+
+	return k.Allows(t, scope)
+}
+
+// typeName returns the name the type of it is registered with in item.Types
+func typeName(it interface{}) string {
+	typeNamesOnce.Do(func() {
+		typeNames = make(map[reflect.Type]string)
+		for name, fn := range item.Types {
+			typeNames[reflect.TypeOf(fn())] = name
+		}
+	})
+
+	rt := reflect.TypeOf(it)
+	if name, ok := typeNames[rt]; ok {
+		return name
+	}
+
+	for rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+
+	return rt.Name()
+}
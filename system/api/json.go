@@ -48,7 +48,11 @@ func toJSON(data []string) ([]byte, error) {
 // data back to a foreign client
 func sendData(res http.ResponseWriter, req *http.Request, data []byte) {
 	res.Header().Set("Content-Type", "application/json")
-	res.Header().Set("Vary", "Accept-Encoding")
+	addVary(res.Header(), "Accept-Encoding")
+
+	if notModified(res, req, data) {
+		return
+	}
 
 	_, err := res.Write(data)
 	if err != nil {
@@ -0,0 +1,282 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kudzu-cms/kudzu/system/admin/apikey"
+	"github.com/kudzu-cms/kudzu/system/api/analytics"
+	"github.com/kudzu-cms/kudzu/system/cfg"
+	"github.com/kudzu-cms/kudzu/system/db"
+	"github.com/kudzu-cms/kudzu/system/item"
+)
+
+// limitRule is a rate limit for requests to a route, or for content of a type
+type limitRule struct {
+	target string
+	ip     float64
+	key    float64
+}
+
+// bucket is a token bucket holding the requests a client may still make, which
+// refills at the rate of its limit and holds up to a minute's worth of requests
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter keeps the token buckets of every client for each rate limit
+type limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+
+	rulesConfig string
+	rules       []limitRule
+}
+
+var limits = &limiter{
+	buckets: make(map[string]*bucket),
+}
+
+// Limit wraps a HandlerFunc to reject requests from clients which have exceeded
+// the rate limits set in the system configuration, with a 429 Too Many Requests
+// response. Requests made with an API key are limited by key, and others by IP
+// address, so Limit must be wrapped by KeyAuth.
+func Limit(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		ip, client, key := limitClient(req)
+		global, rules := limits.config()
+
+		wait, name := limits.take(client, "*", global.limit(key), time.Now())
+		if wait == 0 {
+			for _, r := range rules {
+				if !r.matches(req) {
+					continue
+				}
+
+				wait, name = limits.take(client, r.target, r.limit(key), time.Now())
+				if wait > 0 {
+					break
+				}
+			}
+		}
+
+		if wait > 0 {
+			e, retry := limitError(req, ip, key, name, wait)
+			res.Header().Set("Retry-After", strconv.Itoa(retry))
+			sendAPIError(res, e)
+			return
+		}
+
+		next.ServeHTTP(res, req)
+	})
+}
+
+// limitType takes a token for the client making the request from each rate
+// limit rule for content of the type t. Requests such as /api/batch and GraphQL
+// mutations, which may each make many changes to content of any type, call it
+// for every change, so a type's limits can't be avoided by combining requests
+// into one. It returns the error to report if the client has exceeded a limit.
+func limitType(req *http.Request, t string) *item.APIError {
+	ip, client, key := limitClient(req)
+	_, rules := limits.config()
+
+	for _, r := range rules {
+		if r.target != t {
+			continue
+		}
+
+		wait, name := limits.take(client, r.target, r.limit(key), time.Now())
+		if wait > 0 {
+			e, _ := limitError(req, ip, key, name, wait)
+			return e
+		}
+	}
+
+	return nil
+}
+
+// limitClient returns the IP address of the client making the request, the
+// client its token buckets are kept for, and the ID of the API key the request
+// was made with, if any
+func limitClient(req *http.Request) (string, string, string) {
+	ip := clientIP(req)
+	if k := apikey.FromContext(req.Context()); k != nil {
+		return ip, "key:" + k.ID, k.ID
+	}
+
+	return ip, "ip:" + ip, ""
+}
+
+// limitError records a request rejected by the named limit, and returns the
+// error to respond to it with and the seconds to wait before retrying
+func limitError(req *http.Request, ip, key, name string, wait time.Duration) (*item.APIError, int) {
+	go analytics.RecordLimit(req, ip, key, name)
+
+	retry := int(math.Ceil(wait.Seconds()))
+	e := item.NewAPIError(http.StatusTooManyRequests, errRateLimited, "Too many requests, retry after "+strconv.Itoa(retry)+" seconds")
+	e.Details = map[string]interface{}{"limit": name, "retry_after": retry}
+
+	return e, retry
+}
+
+// take removes a token from the client's bucket for the named limit, allowing
+// perMinute requests each minute. It returns how long the client must wait for
+// a token if there are none left, and the name of the limit.
+func (l *limiter) take(client, name string, perMinute float64, now time.Time) (time.Duration, string) {
+	if perMinute <= 0 {
+		return 0, name
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// buckets which have refilled are the same as new ones, so can be dropped
+	if now.Sub(l.swept) > time.Minute {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > time.Minute {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = now
+	}
+
+	k := name + "|" + client
+	b, ok := l.buckets[k]
+	if !ok {
+		b = &bucket{tokens: perMinute, last: now}
+		l.buckets[k] = b
+	}
+
+	rate := perMinute / time.Minute.Seconds()
+	b.tokens = math.Min(perMinute, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / rate * float64(time.Second)), name
+	}
+
+	b.tokens--
+	return 0, name
+}
+
+// config returns the global limits and the rules for routes and types, parsing
+// the rules again only if they have changed
+func (l *limiter) config() (limitRule, []limitRule) {
+	global := limitRule{target: "*"}
+	if v, ok := db.ConfigCache("rate_limit_ip").(float64); ok {
+		global.ip = v
+	}
+	if v, ok := db.ConfigCache("rate_limit_key").(float64); ok {
+		global.key = v
+	}
+
+	rules, _ := db.ConfigCache("rate_limit_rules").(string)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if rules != l.rulesConfig {
+		l.rules = parseLimitRules(rules)
+		l.rulesConfig = rules
+	}
+
+	return global, l.rules
+}
+
+// parseLimitRules parses rate limit rules, one per line as the route or type
+// followed by the requests per minute for each IP address and for each API key
+func parseLimitRules(config string) []limitRule {
+	var rules []limitRule
+	for _, line := range strings.Split(config, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		r, err := parseLimitRule(fields)
+		if err != nil {
+			log.Println("[Limit] ignoring rate limit rule:", line, err)
+			continue
+		}
+
+		rules = append(rules, r)
+	}
+
+	return rules
+}
+
+func parseLimitRule(fields []string) (limitRule, error) {
+	if len(fields) != 3 {
+		return limitRule{}, fmt.Errorf("expected a route or type and 2 limits")
+	}
+
+	ip, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return limitRule{}, err
+	}
+
+	key, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil {
+		return limitRule{}, err
+	}
+
+	return limitRule{target: fields[0], ip: ip, key: key}, nil
+}
+
+// matches reports whether a rule applies to the request. Rules for a route
+// match its path, or any path beneath it if the route ends with "/". Other
+// rules match requests for content of the type they name.
+func (r limitRule) matches(req *http.Request) bool {
+	if strings.HasPrefix(r.target, "/") {
+		if strings.HasSuffix(r.target, "/") {
+			return strings.HasPrefix(req.URL.Path, r.target)
+		}
+
+		return req.URL.Path == r.target
+	}
+
+	t := req.URL.Query().Get("type")
+	if strings.HasPrefix(req.URL.Path, v2Prefix) {
+		t = strings.Split(strings.TrimPrefix(req.URL.Path, v2Prefix), "/")[0]
+	}
+
+	return t == r.target
+}
+
+// limit returns the requests per minute the rule allows for an API key, or for
+// an IP address if key is empty
+func (r limitRule) limit(key string) float64 {
+	if key != "" {
+		return r.key
+	}
+
+	return r.ip
+}
+
+// clientIP returns the IP address of the client making a request. A tenant
+// worker only accepts requests from the multi-tenant front server, so it uses
+// the address the front server forwarded the request for.
+func clientIP(req *http.Request) string {
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		ip = req.RemoteAddr
+	}
+
+	if cfg.Tenant() != "" {
+		fwd := strings.Split(req.Header.Get("X-Forwarded-For"), ",")
+		if f := strings.TrimSpace(fwd[len(fwd)-1]); f != "" {
+			ip = f
+		}
+	}
+
+	return ip
+}
@@ -0,0 +1,643 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kudzu-cms/kudzu/management/format"
+	"github.com/kudzu-cms/kudzu/system/db"
+	"github.com/kudzu-cms/kudzu/system/item"
+	"github.com/kudzu-cms/kudzu/system/search"
+)
+
+// openapiVersion is the version of the OpenAPI Specification the generated
+// document conforms to
+const openapiVersion = "3.0.3"
+
+// maxSchemaDepth limits how deeply nested struct fields are described, so that
+// recursive types can't generate an endless schema
+const maxSchemaDepth = 8
+
+// openapi is a JSON object in an OpenAPI document
+type openapi map[string]interface{}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func openapiHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		sendMethodError(res, http.MethodGet)
+		return
+	}
+
+	j, err := json.Marshal(openapiSpec(res, req))
+	if err != nil {
+		log.Println("[OpenAPI] error marshalling spec to JSON:", err)
+		sendInternalError(res)
+		return
+	}
+
+	sendData(res, req, j)
+}
+
+// openapiSpec generates an OpenAPI document describing the content API for the
+// content types visible to the request. Each type is described at its REST
+// resource paths under /api/v2, and as a value of the type param of the
+// /api/content endpoints. Paths to create, update and delete content are only
+// included for types which implement the interface enabling them.
+func openapiSpec(res http.ResponseWriter, req *http.Request) openapi {
+	schemas := openapi{
+		"ContentStatus": openapi{
+			"type": "object",
+			"properties": openapi{
+				"id":     openapi{"type": "integer", "format": "int64"},
+				"status": openapi{"type": "string", "enum": []string{"public", "pending"}},
+				"type":   openapi{"type": "string"},
+			},
+		},
+		"FileUpload": openapiSchema(reflect.TypeOf(item.FileUpload{}), false, 0),
+		"Error": openapi{
+			"type":     "object",
+			"required": []string{"code", "message"},
+			"properties": openapi{
+				"code":    openapi{"type": "string"},
+				"message": openapi{"type": "string"},
+				"details": openapi{"type": "object"},
+			},
+		},
+	}
+	schemas["ErrorResponse"] = openapi{
+		"type":       "object",
+		"properties": openapi{"error": openapiRef("Error")},
+	}
+	schemas["ContentStatusResponse"] = openapiData(openapiRef("ContentStatus"))
+	schemas["FileUploadResponse"] = openapiData(openapiRef("FileUpload"))
+
+	paths := openapi{}
+
+	var all, createable, updateable, deleteable, searchable, feedable []string
+	var allRefs, inputRefs []interface{}
+	for _, t := range openapiTypes(res, req) {
+		post := item.Types[t]()
+		rt := reflect.TypeOf(post)
+		for rt.Kind() == reflect.Ptr {
+			rt = rt.Elem()
+		}
+
+		schemas[t] = openapiSchema(rt, false, 0)
+		schemas[t+"Input"] = openapiSchema(rt, true, 0)
+		schemas[t+"Response"] = openapiData(openapiRef(t))
+
+		all = append(all, t)
+		allRefs = append(allRefs, openapiRef(t))
+		inputRefs = append(inputRefs, openapiRef(t+"Input"))
+
+		_, c := post.(Createable)
+		_, u := post.(Updateable)
+		_, d := post.(Deleteable)
+		if c {
+			createable = append(createable, t)
+		}
+		if u {
+			updateable = append(updateable, t)
+		}
+		if d {
+			deleteable = append(deleteable, t)
+		}
+		if s, ok := post.(search.Searchable); ok && s.IndexContent() {
+			searchable = append(searchable, t)
+		}
+		if _, ok := post.(format.Feedable); ok {
+			feedable = append(feedable, t)
+		}
+
+		openapiResourcePaths(paths, t, c, u, d)
+	}
+
+	openapiContentPaths(paths, all, createable, updateable, deleteable, searchable, allRefs, inputRefs)
+
+	if len(feedable) > 0 {
+		paths["/api/feed"] = openapi{
+			"get": openapi{
+				"operationId": "getFeed",
+				"summary":     "Get a feed of the most recent content of a type",
+				"tags":        []string{"content"},
+				"parameters": []interface{}{
+					openapiParam("type", "query", "The content type", true, openapi{"type": "string", "enum": feedable}),
+					openapiParam("format", "query", "The format of the feed", false, openapi{"type": "string", "enum": []string{"rss", "atom", "json"}, "default": "rss"}),
+					openapiParam("count", "query", "The number of items in the feed", false, openapi{"type": "integer", "default": 20, "minimum": 1, "maximum": 100}),
+				},
+				"responses": openapiResponses(openapi{
+					"200": openapi{
+						"description": "The feed, as RSS 2.0, Atom or JSON Feed 1.1",
+						"content": openapi{
+							"application/rss+xml":   openapi{"schema": openapi{"type": "string"}},
+							"application/atom+xml":  openapi{"schema": openapi{"type": "string"}},
+							"application/feed+json": openapi{"schema": openapi{"type": "object"}},
+						},
+					},
+				}, "400", "403", "404"),
+			},
+		}
+	}
+
+	paths["/api/uploads"] = openapi{
+		"get": openapi{
+			"operationId": "getUpload",
+			"summary":     "Get the metadata of an uploaded file",
+			"tags":        []string{"uploads"},
+			"parameters": []interface{}{
+				openapiParam("slug", "query", "The slug of the file upload", true, openapi{"type": "string"}),
+			},
+			"responses": openapiResponses(openapi{
+				"200": openapiJSON("The file upload's metadata", openapiRef("FileUploadResponse")),
+			}, "400", "404"),
+		},
+	}
+
+	name, _ := db.ConfigCache("name").(string)
+	if name == "" {
+		name = "kudzu"
+	}
+
+	return openapi{
+		"openapi": openapiVersion,
+		"info": openapi{
+			"title":       name + " Content API",
+			"description": "The content HTTP API of a kudzu CMS, generated from its content types.",
+			"version":     "2.0.0",
+		},
+		"paths": paths,
+		"components": openapi{
+			"schemas": schemas,
+			"securitySchemes": openapi{
+				"apiKey": openapi{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "An API key issued in the kudzu admin, limited to its scopes",
+				},
+			},
+		},
+		// requests may be made without an API key, or with one
+		"security": []interface{}{openapi{}, openapi{"apiKey": []string{}}},
+	}
+}
+
+// openapiTypes returns the sorted names of the content types visible to the request
+func openapiTypes(res http.ResponseWriter, req *http.Request) []string {
+	var names []string
+	for t, it := range item.Types {
+		if !isHidden(res, req, t, it()) {
+			names = append(names, t)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// openapiResourcePaths adds the /api/v2 REST resource paths of the type t
+func openapiResourcePaths(paths openapi, t string, createable, updateable, deleteable bool) {
+	tags := []string{t}
+	collection := openapi{
+		"get": openapi{
+			"operationId": "list" + t,
+			"summary":     "List " + t + " content",
+			"tags":        tags,
+			"parameters":  openapiListParams(),
+			"responses": openapiResponses(openapi{
+				"200": openapiJSON("The list of content", openapiRef(t+"Response")),
+			}, "403"),
+		},
+	}
+
+	if createable {
+		collection["post"] = openapi{
+			"operationId": "create" + t,
+			"summary":     "Create " + t + " content",
+			"tags":        tags,
+			"requestBody": openapiBody(openapiRef(t + "Input")),
+			"responses": openapiResponses(openapi{
+				"201": openapi{
+					"description": "The content was created",
+					"headers": openapi{
+						"Location": openapi{
+							"description": "The path of the new content",
+							"schema":      openapi{"type": "string"},
+						},
+					},
+					"content": openapi{
+						"application/json": openapi{"schema": openapiRef("ContentStatusResponse")},
+					},
+				},
+				"202": openapiJSON("The content was created, and is pending approval", openapiRef("ContentStatusResponse")),
+				"409": openapi{"description": "The slug is already in use"},
+			}, "400", "403"),
+		}
+	}
+
+	paths[v2Prefix+t] = collection
+
+	for _, p := range []struct {
+		path, suffix string
+		param        openapi
+	}{
+		{v2Prefix + t + "/{id}", "", openapiParam("id", "path", "The ID of the content", true, openapi{"type": "integer", "format": "int64"})},
+		{v2Prefix + t + "/slug/{slug}", "BySlug", openapiParam("slug", "path", "The slug of the content", true, openapi{"type": "string"})},
+	} {
+		resource := openapi{
+			"parameters": []interface{}{p.param},
+			"get": openapi{
+				"operationId": "get" + t + p.suffix,
+				"summary":     "Get " + t + " content",
+				"tags":        tags,
+				"parameters":  []interface{}{openapiFieldsParam()},
+				"responses": openapiResponses(openapi{
+					"200": openapiJSON("The content", openapiRef(t+"Response")),
+				}, "403", "404"),
+			},
+		}
+
+		if updateable {
+			resource["put"] = openapi{
+				"operationId": "replace" + t + p.suffix,
+				"summary":     "Replace " + t + " content, resetting fields which aren't sent",
+				"tags":        tags,
+				"requestBody": openapiBody(openapiRef(t + "Input")),
+				"responses": openapiResponses(openapi{
+					"204": openapi{"description": "The content was replaced"},
+					"409": openapi{"description": "The slug can't be changed"},
+				}, "400", "403", "404"),
+			}
+
+			resource["patch"] = openapi{
+				"operationId": "update" + t + p.suffix,
+				"summary":     "Update " + t + " content, keeping fields which aren't sent",
+				"tags":        tags,
+				"requestBody": openapiBody(openapiRef(t + "Input")),
+				"responses": openapiResponses(openapi{
+					"204": openapi{"description": "The content was updated"},
+					"409": openapi{"description": "The slug can't be changed"},
+				}, "400", "403", "404"),
+			}
+		}
+
+		if deleteable {
+			resource["delete"] = openapi{
+				"operationId": "delete" + t + p.suffix,
+				"summary":     "Delete " + t + " content",
+				"tags":        tags,
+				"responses": openapiResponses(openapi{
+					"204": openapi{"description": "The content was deleted"},
+				}, "403", "404"),
+			}
+		}
+
+		paths[p.path] = resource
+	}
+}
+
+// openapiContentPaths adds the /api/content and /api/search paths, where the
+// type param is limited to the types supporting each operation
+func openapiContentPaths(paths openapi, all, createable, updateable, deleteable, searchable []string, allRefs, inputRefs []interface{}) {
+	if len(all) == 0 {
+		return
+	}
+
+	tags := []string{"content"}
+	anyContent := openapiData(openapi{"oneOf": allRefs})
+	typeParam := func(types []string) openapi {
+		return openapiParam("type", "query", "The content type", true, openapi{"type": "string", "enum": types})
+	}
+	idParam := openapiParam("id", "query", "The ID of the content", true, openapi{"type": "integer", "format": "int64"})
+
+	paths["/api/contents"] = openapi{
+		"get": openapi{
+			"operationId": "getContents",
+			"summary":     "List content of a type",
+			"tags":        tags,
+			"parameters":  append([]interface{}{typeParam(all)}, openapiListParams()...),
+			"responses": openapiResponses(openapi{
+				"200": openapiJSON("The list of content", anyContent),
+			}, "400", "403", "404"),
+		},
+	}
+
+	paths["/api/content"] = openapi{
+		"get": openapi{
+			"operationId": "getContent",
+			"summary":     "Get content by its type and ID, or by its slug",
+			"tags":        tags,
+			"parameters": []interface{}{
+				openapiParam("type", "query", "The content type, required with id", false, openapi{"type": "string", "enum": all}),
+				openapiParam("id", "query", "The ID of the content", false, openapi{"type": "integer", "format": "int64"}),
+				openapiParam("slug", "query", "The slug of the content, in place of type and id", false, openapi{"type": "string"}),
+				openapiFieldsParam(),
+			},
+			"responses": openapiResponses(openapi{
+				"200": openapiJSON("The content", anyContent),
+			}, "400", "403", "404"),
+		},
+	}
+
+	body := openapiBody(openapi{"oneOf": inputRefs})
+	if len(createable) > 0 {
+		paths["/api/content/create"] = openapi{
+			"post": openapi{
+				"operationId": "createContent",
+				"summary":     "Create content",
+				"tags":        tags,
+				"parameters":  []interface{}{typeParam(createable)},
+				"requestBody": body,
+				"responses": openapiResponses(openapi{
+					"200": openapiJSON("The content was created, or is pending approval", openapiRef("ContentStatusResponse")),
+				}, "400", "403", "404"),
+			},
+		}
+	}
+
+	if len(updateable) > 0 {
+		paths["/api/content/update"] = openapi{
+			"post": openapi{
+				"operationId": "updateContent",
+				"summary":     "Update content",
+				"tags":        tags,
+				"parameters":  []interface{}{typeParam(updateable), idParam},
+				"requestBody": body,
+				"responses": openapiResponses(openapi{
+					"200": openapiJSON("The content was updated", openapiRef("ContentStatusResponse")),
+				}, "400", "403", "404"),
+			},
+		}
+	}
+
+	if len(deleteable) > 0 {
+		paths["/api/content/delete"] = openapi{
+			"post": openapi{
+				"operationId": "deleteContent",
+				"summary":     "Delete content",
+				"tags":        tags,
+				"parameters":  []interface{}{typeParam(deleteable), idParam},
+				"responses": openapiResponses(openapi{
+					"200": openapiJSON("The content was deleted", openapiRef("ContentStatusResponse")),
+				}, "400", "403", "404"),
+			},
+		}
+	}
+
+	if len(searchable) > 0 {
+		// each hit gives the type of the item at the same index of data
+		searchResults := openapiData(openapi{"oneOf": allRefs})
+		searchResults["properties"].(openapi)["hits"] = openapi{
+			"type": "array",
+			"items": openapi{
+				"type": "object",
+				"properties": openapi{
+					"type":  openapi{"type": "string"},
+					"id":    openapi{"type": "integer", "format": "int64"},
+					"score": openapi{"type": "number", "format": "double"},
+					"fragments": openapi{
+						"type":                 "object",
+						"additionalProperties": openapi{"type": "array", "items": openapi{"type": "string"}},
+					},
+				},
+			},
+		}
+		searchResults["properties"].(openapi)["total"] = openapi{"type": "integer", "format": "int64"}
+		searchResults["properties"].(openapi)["took"] = openapi{"type": "integer", "format": "int64"}
+		searchResults["properties"].(openapi)["facets"] = openapi{
+			"type":                 "object",
+			"additionalProperties": openapi{"type": "object"},
+		}
+
+		paths["/api/search"] = openapi{
+			"get": openapi{
+				"operationId": "searchContent",
+				"summary":     "Search content of one or more types",
+				"tags":        []string{"search"},
+				"parameters": []interface{}{
+					openapiParam("type", "query", "The content type to search", false, openapi{"type": "string", "enum": searchable}),
+					openapiParam("types", "query", "The content types to search, separated by commas, if type isn't set. All types are searched by default.", false, openapi{"type": "string"}),
+					openapiParam("q", "query", "The query, in Bleve query string syntax", true, openapi{"type": "string"}),
+					openapiParam("count", "query", "The number of results to return, or -1 for all", false, openapi{"type": "integer", "default": 10}),
+					openapiParam("offset", "query", "The multiple of count to skip, for pagination", false, openapi{"type": "integer", "default": 0}),
+					openapiParam("sort", "query", "Fields to sort by, separated by commas, prefixed with - for descending order, and _score for relevance", false, openapi{"type": "string"}),
+					openapiParam("highlight", "query", "Include highlighted fragments of the matching fields in hits", false, openapi{"type": "boolean", "default": false}),
+					openapiParam("facets", "query", "Names of facets provided by the types searched to include, separated by commas", false, openapi{"type": "string"}),
+					openapiFieldsParam(),
+				},
+				"responses": openapiResponses(openapi{
+					"200": openapiJSON("The matching content, by relevance or sort", searchResults),
+				}, "400", "403", "404"),
+			},
+		}
+
+		paths["/api/search/suggest"] = openapi{
+			"get": openapi{
+				"operationId": "suggestContent",
+				"summary":     "Suggest content as a query is typed",
+				"tags":        []string{"search"},
+				"parameters": []interface{}{
+					openapiParam("type", "query", "The content type to suggest", true, openapi{"type": "string", "enum": searchable}),
+					openapiParam("q", "query", "The start of the words to match, as typed", true, openapi{"type": "string"}),
+					openapiParam("count", "query", "The number of suggestions to return", false, openapi{"type": "integer", "default": 5, "minimum": 1, "maximum": 20}),
+				},
+				"responses": openapiResponses(openapi{
+					"200": openapiJSON("The matching content, by relevance", openapiData(openapi{
+						"type": "object",
+						"properties": openapi{
+							"id":    openapi{"type": "integer", "format": "int64"},
+							"slug":  openapi{"type": "string"},
+							"title": openapi{"type": "string"},
+						},
+					})),
+				}, "400", "403", "404"),
+			},
+		}
+	}
+}
+
+// openapiSchema returns the schema for values of the Go type rt, as they are
+// encoded in the JSON content API. If input is true, fields set by the system
+// when content is stored are left out of struct schemas.
+func openapiSchema(rt reflect.Type, input bool, depth int) openapi {
+	if rt == timeType {
+		return openapi{"type": "string", "format": "date-time"}
+	}
+
+	if rt.Implements(textMarshaler) || reflect.PtrTo(rt).Implements(textMarshaler) {
+		return openapi{"type": "string"}
+	}
+
+	switch rt.Kind() {
+	case reflect.String:
+		return openapi{"type": "string"}
+
+	case reflect.Bool:
+		return openapi{"type": "boolean"}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return openapi{"type": "integer", "format": "int32"}
+
+	case reflect.Int64:
+		return openapi{"type": "integer", "format": "int64"}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return openapi{"type": "integer", "minimum": 0}
+
+	case reflect.Float32:
+		return openapi{"type": "number", "format": "float"}
+
+	case reflect.Float64:
+		return openapi{"type": "number", "format": "double"}
+
+	case reflect.Ptr:
+		s := openapiSchema(rt.Elem(), input, depth)
+		s["nullable"] = true
+		return s
+
+	case reflect.Slice, reflect.Array:
+		if rt.Elem().Kind() == reflect.Uint8 {
+			// []byte is encoded as a base64 string
+			return openapi{"type": "string", "format": "byte"}
+		}
+
+		return openapi{"type": "array", "items": openapiSchema(rt.Elem(), input, depth+1)}
+
+	case reflect.Map:
+		return openapi{"type": "object", "additionalProperties": openapiSchema(rt.Elem(), input, depth+1)}
+
+	case reflect.Struct:
+		if depth > maxSchemaDepth {
+			return openapi{"type": "object"}
+		}
+
+		props := openapi{}
+		openapiProperties(rt, props, input, depth)
+		return openapi{"type": "object", "properties": props}
+	}
+
+	// interfaces may hold any JSON value
+	return openapi{}
+}
+
+// openapiProperties adds a property to props for each json-tagged field of the
+// struct type rt, including those of embedded structs such as item.Item
+func openapiProperties(rt reflect.Type, props openapi, input bool, depth int) {
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+
+			if ft.Kind() == reflect.Struct {
+				openapiProperties(ft, props, input, depth)
+				continue
+			}
+		}
+
+		if f.PkgPath != "" || name == "-" {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		if input && depth == 0 {
+			switch name {
+			case "uuid", "id", "timestamp", "updated":
+				// set by the system when content is stored
+				continue
+			}
+		}
+
+		props[name] = openapiSchema(f.Type, input, depth+1)
+	}
+}
+
+func openapiRef(name string) openapi {
+	return openapi{"$ref": "#/components/schemas/" + name}
+}
+
+// openapiData returns the schema of a response with the items described by
+// schema in its top-level "data" array
+func openapiData(schema openapi) openapi {
+	return openapi{
+		"type": "object",
+		"properties": openapi{
+			"data": openapi{"type": "array", "items": schema},
+		},
+	}
+}
+
+func openapiParam(name, in, description string, required bool, schema openapi) openapi {
+	return openapi{
+		"name":        name,
+		"in":          in,
+		"description": description,
+		"required":    required,
+		"schema":      schema,
+	}
+}
+
+func openapiFieldsParam() openapi {
+	return openapiParam("fields", "query", "The fields to include in each item, separated by commas", false, openapi{"type": "string"})
+}
+
+func openapiListParams() []interface{} {
+	return []interface{}{
+		openapiParam("count", "query", "The number of items to return, or -1 for all", false, openapi{"type": "integer", "default": 10}),
+		openapiParam("offset", "query", "The multiple of count to skip, for pagination", false, openapi{"type": "integer", "default": 0}),
+		openapiParam("order", "query", "The order of items by timestamp", false, openapi{"type": "string", "enum": []string{"asc", "desc"}, "default": "desc"}),
+		openapiFieldsParam(),
+	}
+}
+
+// openapiBody returns a request body which may be sent as JSON or form data
+func openapiBody(schema openapi) openapi {
+	return openapi{
+		"required": true,
+		"content": openapi{
+			"application/json":    openapi{"schema": schema},
+			"multipart/form-data": openapi{"schema": schema},
+		},
+	}
+}
+
+func openapiJSON(description string, schema openapi) openapi {
+	return openapi{
+		"description": description,
+		"content": openapi{
+			"application/json": openapi{"schema": schema},
+		},
+	}
+}
+
+// openapiResponses adds the error responses for the status codes to responses,
+// along with the responses any request may receive
+func openapiResponses(responses openapi, codes ...string) openapi {
+	descriptions := map[string]string{
+		"400": "The request is invalid",
+		"401": "The API key is invalid, expired or revoked",
+		"403": "The API key doesn't have the scope for the request",
+		"404": "The type or content doesn't exist, or is hidden",
+		"429": "A rate limit was exceeded, retry after the number of seconds in the Retry-After header",
+	}
+
+	for _, code := range append(codes, "401", "429") {
+		if _, ok := responses[code]; !ok {
+			responses[code] = openapiJSON(descriptions[code], openapiRef("ErrorResponse"))
+		}
+	}
+
+	return responses
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"log"
+	"net/http"
+)
+
+// openapiDocsHTML is a self-contained viewer for the document served at
+// /api/openapi.json, so the API can be browsed without any external assets
+const openapiDocsHTML = `<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Content API</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Roboto, Helvetica, Arial, sans-serif; margin: 0; color: #212121; background: #fafafa; }
+header { background: #263238; color: #fff; padding: 16px 32px; }
+header h1 { margin: 0; font-size: 22px; font-weight: 400; }
+header p { margin: 4px 0 0; color: #b0bec5; font-size: 14px; }
+main { max-width: 1000px; margin: 0 auto; padding: 16px 32px 64px; }
+h2 { font-weight: 400; border-bottom: 1px solid #ddd; padding-bottom: 4px; margin-top: 32px; }
+details { background: #fff; border: 1px solid #e0e0e0; border-radius: 3px; margin: 8px 0; }
+summary { cursor: pointer; padding: 8px 12px; font-family: Menlo, Consolas, monospace; font-size: 14px; }
+.method { display: inline-block; min-width: 56px; font-weight: bold; text-transform: uppercase; }
+.get { color: #1e88e5; } .post { color: #43a047; } .put { color: #fb8c00; } .patch { color: #8e24aa; } .delete { color: #e53935; }
+.op { padding: 0 16px 12px; font-size: 14px; }
+.op h4 { margin: 12px 0 4px; font-size: 13px; text-transform: uppercase; color: #757575; }
+table { border-collapse: collapse; width: 100%; font-size: 13px; }
+td, th { text-align: left; padding: 4px 8px; border-bottom: 1px solid #eee; vertical-align: top; }
+code, pre { font-family: Menlo, Consolas, monospace; font-size: 12px; }
+pre { background: #f5f5f5; padding: 8px; overflow: auto; margin: 4px 0; }
+.muted { color: #9e9e9e; }
+</style>
+</head>
+<body>
+<header><h1 id="title">Content API</h1><p id="description"></p></header>
+<main id="main"><p class="muted">Loading <a href="/api/openapi.json">/api/openapi.json</a>...</p></main>
+<script>
+(function() {
+    var spec;
+    var methods = ['get', 'post', 'put', 'patch', 'delete'];
+
+    function el(tag, attrs, children) {
+        var e = document.createElement(tag);
+        for (var k in attrs || {}) { e.setAttribute(k, attrs[k]); }
+        (children || []).forEach(function(c) {
+            e.appendChild(typeof c === 'string' ? document.createTextNode(c) : c);
+        });
+        return e;
+    }
+
+    function resolve(schema) {
+        if (schema && schema.$ref) {
+            return spec.components.schemas[schema.$ref.split('/').pop()];
+        }
+        return schema || {};
+    }
+
+    function describe(schema, depth) {
+        depth = depth || 0;
+        if (schema.$ref) {
+            var name = schema.$ref.split('/').pop();
+            return depth > 3 ? name : describe(resolve(schema), depth + 1);
+        }
+        if (schema.oneOf) {
+            return { oneOf: schema.oneOf.map(function(s) { return s.$ref ? s.$ref.split('/').pop() : describe(s, depth + 1); }) };
+        }
+        if (schema.type === 'object' && schema.properties) {
+            var out = {};
+            for (var k in schema.properties) { out[k] = describe(schema.properties[k], depth + 1); }
+            return out;
+        }
+        if (schema.type === 'array') {
+            return [describe(schema.items || {}, depth + 1)];
+        }
+        return (schema.type || 'any') + (schema.format ? ' (' + schema.format + ')' : '');
+    }
+
+    function operation(path, method, op, shared) {
+        var body = el('div', { 'class': 'op' }, [op.summary || '']);
+        var params = (shared || []).concat(op.parameters || []);
+        if (params.length) {
+            var rows = params.map(function(p) {
+                var s = p.schema || {};
+                var type = s.enum ? s.enum.join(' | ') : (s.type || '');
+                return el('tr', {}, [
+                    el('td', {}, [el('code', {}, [p.name])]),
+                    el('td', {}, [p.in + (p.required ? ', required' : '')]),
+                    el('td', {}, [el('code', {}, [type])]),
+                    el('td', {}, [p.description || ''])
+                ]);
+            });
+            body.appendChild(el('h4', {}, ['Parameters']));
+            body.appendChild(el('table', {}, rows));
+        }
+        if (op.requestBody) {
+            var types = Object.keys(op.requestBody.content);
+            var schema = op.requestBody.content[types[0]].schema;
+            body.appendChild(el('h4', {}, ['Request body (' + types.join(', ') + ')']));
+            body.appendChild(el('pre', {}, [JSON.stringify(describe(schema), null, 2)]));
+        }
+        body.appendChild(el('h4', {}, ['Responses']));
+        var rows = Object.keys(op.responses).sort().map(function(code) {
+            var r = op.responses[code];
+            var cell = el('td', {}, [r.description || '']);
+            if (r.content) {
+                cell.appendChild(el('pre', {}, [JSON.stringify(describe(r.content['application/json'].schema), null, 2)]));
+            }
+            return el('tr', {}, [el('td', {}, [el('code', {}, [code])]), cell]);
+        });
+        body.appendChild(el('table', {}, rows));
+
+        return el('details', {}, [
+            el('summary', {}, [el('span', { 'class': 'method ' + method }, [method]), ' ' + path]),
+            body
+        ]);
+    }
+
+    function render() {
+        document.title = spec.info.title;
+        document.getElementById('title').textContent = spec.info.title;
+        document.getElementById('description').textContent = spec.info.description + ' OpenAPI ' + spec.openapi + ', ';
+        document.getElementById('description').appendChild(el('a', { href: '/api/openapi.json', style: 'color: #80cbc4' }, ['download']));
+
+        var groups = {};
+        Object.keys(spec.paths).sort().forEach(function(path) {
+            var item = spec.paths[path];
+            methods.forEach(function(m) {
+                if (!item[m]) { return; }
+                var tag = (item[m].tags || ['other'])[0];
+                (groups[tag] = groups[tag] || []).push(operation(path, m, item[m], item.parameters));
+            });
+        });
+
+        var main = document.getElementById('main');
+        main.innerHTML = '';
+        Object.keys(groups).sort().forEach(function(tag) {
+            main.appendChild(el('h2', {}, [tag]));
+            groups[tag].forEach(function(op) { main.appendChild(op); });
+        });
+    }
+
+    var xhr = new XMLHttpRequest();
+    xhr.open('GET', '/api/openapi.json');
+    xhr.onload = function() {
+        if (xhr.status !== 200) {
+            document.getElementById('main').textContent = 'Failed to load the API description: ' + xhr.status;
+            return;
+        }
+        spec = JSON.parse(xhr.responseText);
+        render();
+    };
+    xhr.send();
+})();
+</script>
+</body>
+</html>
+`
+
+func openapiDocsHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		sendMethodError(res, http.MethodGet)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, err := res.Write([]byte(openapiDocsHTML))
+	if err != nil {
+		log.Println("[OpenAPI] error writing docs viewer:", err)
+	}
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"container/list"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/kudzu-cms/kudzu/system/db"
+	"github.com/kudzu-cms/kudzu/system/item"
+)
+
+// responseCache keeps rendered API responses in memory, up to max bytes of
+// response data, dropping the least recently used first
+type responseCache struct {
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+	size    int
+	max     int
+}
+
+// cachedResponse is the rendered data of a response, the headers set while it
+// was rendered, and the version of its type's content it was rendered from
+type cachedResponse struct {
+	key     string
+	version uint64
+	data    []byte
+	header  http.Header
+}
+
+var responses = &responseCache{
+	order:   list.New(),
+	entries: make(map[string]*list.Element),
+}
+
+// responseCacheKey returns the key a response for content of the type t is
+// cached by, made of the request's path, query and the headers the type varies
+// by. It returns "" if the response cache is disabled, or the type isn't
+// item.ResponseCacheable.
+func responseCacheKey(req *http.Request, t string, it interface{}) string {
+	var max int
+	if v, ok := db.ConfigCache("response_cache_size").(float64); ok && v > 0 {
+		max = int(v) << 20
+	}
+	responses.resize(max)
+
+	rc, ok := it.(item.ResponseCacheable)
+	if !ok || max == 0 {
+		return ""
+	}
+
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return ""
+	}
+
+	key := &strings.Builder{}
+	key.WriteString(t)
+	key.WriteByte(0)
+	key.WriteString(req.URL.Path)
+	key.WriteByte('?')
+	key.WriteString(req.URL.Query().Encode())
+	for _, h := range rc.CacheVary() {
+		key.WriteByte(0)
+		key.WriteString(http.CanonicalHeaderKey(h))
+		key.WriteByte(':')
+		key.WriteString(strings.Join(req.Header.Values(h), ","))
+	}
+
+	return key.String()
+}
+
+// get returns the data and headers of the response cached by key, if it was
+// rendered from the current version of its type's content
+func (c *responseCache) get(key string, version uint64) ([]byte, http.Header, bool) {
+	if key == "" {
+		return nil, nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, nil, false
+	}
+
+	r := e.Value.(*cachedResponse)
+	if r.version != version {
+		c.remove(e)
+		return nil, nil, false
+	}
+
+	c.order.MoveToFront(e)
+	return r.data, r.header, true
+}
+
+// put caches the data and headers of a response rendered from the version of
+// its type's content. Responses larger than an eighth of the cache aren't kept.
+func (c *responseCache) put(key string, version uint64, data []byte, header http.Header) {
+	if key == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(data) > c.max/8 {
+		return
+	}
+
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+
+	c.entries[key] = c.order.PushFront(&cachedResponse{key: key, version: version, data: data, header: header})
+	c.size += len(data)
+	c.evict()
+}
+
+// resize sets the most bytes of response data kept, dropping responses if the
+// cache has shrunk
+func (c *responseCache) resize(max int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.max == max {
+		return
+	}
+
+	c.max = max
+	c.evict()
+}
+
+func (c *responseCache) evict() {
+	for c.size > c.max {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *responseCache) remove(e *list.Element) {
+	r := c.order.Remove(e).(*cachedResponse)
+	delete(c.entries, r.key)
+	c.size -= len(r.data)
+}
+
+// headerChanges returns the headers of after which were added or changed since
+// before, a copy of the headers taken earlier while writing the same response
+func headerChanges(before, after http.Header) http.Header {
+	changed := http.Header{}
+	for k, vv := range after {
+		if strings.Join(vv, "\x00") != strings.Join(before[k], "\x00") {
+			changed[k] = append([]string(nil), vv...)
+		}
+	}
+
+	return changed
+}
+
+// replayHeader sets the headers of a cached response on res
+func replayHeader(res http.ResponseWriter, header http.Header) {
+	for k, vv := range header {
+		res.Header()[k] = append([]string(nil), vv...)
+	}
+}
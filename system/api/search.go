@@ -2,44 +2,60 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"net/url"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/kudzu-cms/kudzu/system/db"
 	"github.com/kudzu-cms/kudzu/system/item"
 	"github.com/kudzu-cms/kudzu/system/search"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
 )
 
 func searchContentHandler(res http.ResponseWriter, req *http.Request) {
 	qs := req.URL.Query()
-	t := qs.Get("type")
-	// type must be set, future version may compile multi-type result set
-	if t == "" {
-		res.WriteHeader(http.StatusBadRequest)
-		return
-	}
 
-	it, ok := item.Types[t]
-	if !ok {
-		res.WriteHeader(http.StatusBadRequest)
-		return
-	}
+	// search a single type, the types listed, or every searchable type
+	var types []string
+	if t := qs.Get("type"); t != "" {
+		it, ok := item.Types[t]
+		if !ok {
+			sendUnknownType(res, http.StatusBadRequest, t)
+			return
+		}
 
-	if hide(res, req, it()) {
-		return
+		if hide(res, req, it()) {
+			return
+		}
+
+		cacheControl(res, req, it())
+
+		types = []string{t}
+	} else {
+		var ok bool
+		types, ok = searchTypes(res, req, qs.Get("types"))
+		if !ok {
+			sendParamError(res, errUnknownType, "types", "The types param names a content type which doesn't exist")
+			return
+		}
 	}
 
 	q, err := url.QueryUnescape(qs.Get("q"))
 	if err != nil {
-		res.WriteHeader(http.StatusInternalServerError)
+		sendParamError(res, errInvalidParam, "q", "The q param must be URL encoded")
 		return
 	}
 
 	// q must be set
 	if q == "" {
-		res.WriteHeader(http.StatusBadRequest)
+		sendParamError(res, errMissingParam, "q", "The q param is required")
 		return
 	}
 
@@ -48,7 +64,7 @@ func searchContentHandler(res http.ResponseWriter, req *http.Request) {
 		if qs.Get("count") == "" {
 			count = 10
 		} else {
-			res.WriteHeader(http.StatusInternalServerError)
+			sendParamError(res, errInvalidParam, "count", "The count param must be an integer")
 			return
 		}
 	}
@@ -58,52 +74,212 @@ func searchContentHandler(res http.ResponseWriter, req *http.Request) {
 		if qs.Get("offset") == "" {
 			offset = 0
 		} else {
-			res.WriteHeader(http.StatusInternalServerError)
+			sendParamError(res, errInvalidParam, "offset", "The offset param must be an integer")
 			return
 		}
 	}
 
-	// execute search for query provided, if no index for type send 404
-	matches, err := search.TypeQuery(t, q, count, offset)
+	opts := search.Options{
+		Count:     count,
+		Offset:    offset,
+		Highlight: qs.Get("highlight") == "true", // bool: include highlighted fragments of matching fields in hits (false default)
+	}
+
+	// string: fields to sort by, separated by commas, "-" prefixed for descending
+	// order and "_score" for relevance (relevance default)
+	if s := qs.Get("sort"); s != "" {
+		for _, field := range strings.Split(s, ",") {
+			field = strings.TrimSpace(field)
+			if !searchSortField.MatchString(field) {
+				sendParamError(res, errInvalidParam, "sort", "The sort param must list field names, separated by commas")
+				return
+			}
+
+			opts.Sort = append(opts.Sort, field)
+		}
+	}
+
+	// string: names of the facets provided by the types searched to include,
+	// separated by commas
+	if f := qs.Get("facets"); f != "" {
+		var ok bool
+		opts.Facets, ok = searchFacets(types, f)
+		if !ok {
+			sendParamError(res, errInvalidParam, "facets", "The facets param names a facet the types searched don't provide")
+			return
+		}
+	}
+
+	// execute search for query provided, if no index for a single type send 404
+	result, err := search.Query(types, q, opts)
 	if err == search.ErrNoIndex {
-		res.WriteHeader(http.StatusNotFound)
+		if qs.Get("type") != "" {
+			sendError(res, http.StatusNotFound, errSearchDisabled, "Search isn't enabled for content of type "+qs.Get("type"))
+			return
+		}
+
+		result, err = &search.Result{Hits: []search.Hit{}}, nil
+	}
+	if errors.Is(err, search.ErrInvalidQuery) {
+		sendParamError(res, errInvalidParam, "q", err.Error())
 		return
 	}
 	if err != nil {
 		log.Println("[search] Error:", err)
-		res.WriteHeader(http.StatusInternalServerError)
+		sendInternalError(res)
 		return
 	}
 
-	// respond with json formatted results
-	bb, err := db.ContentMulti(matches)
+	// respond with json formatted results, each with a hit giving its type
+	var data = []json.RawMessage{}
+	var found = []search.Hit{}
+	for _, hit := range result.Hits {
+		b, err := db.Content(hit.Target())
+		if err != nil {
+			log.Println("[search] Error:", err)
+			sendInternalError(res)
+			return
+		}
+
+		// the index may briefly hold content which has been deleted
+		if len(b) == 0 {
+			continue
+		}
+
+		it := item.Types[hit.Type]()
+
+		// if we have matches, push the first as its matched by relevance
+		if len(data) == 0 {
+			push(res, req, it, b)
+		}
+
+		j, err := searchResult(res, req, it, b, &hit)
+		if err != nil {
+			sendInternalError(res)
+			return
+		}
+
+		data = append(data, j)
+		found = append(found, hit)
+	}
+
+	j, err := fmtJSON(data...)
 	if err != nil {
-		log.Println("[search] Error:", err)
-		res.WriteHeader(http.StatusInternalServerError)
+		sendInternalError(res)
 		return
 	}
 
-	// if we have matches, push the first as its matched by relevance
-	if len(bb) > 0 {
-		push(res, req, it(), bb[0])
+	fields := map[string]interface{}{
+		"hits":  found,
+		"total": result.Total,
+		"took":  result.Took.Milliseconds(),
+	}
+	if len(opts.Facets) > 0 {
+		fields["facets"] = result.Facets
+	}
+
+	for k, v := range fields {
+		j, err = sjson.SetBytes(j, k, v)
+		if err != nil {
+			sendInternalError(res)
+			return
+		}
+	}
+
+	sendData(res, req, j)
+}
+
+// searchSortField matches a field a search may be sorted by
+var searchSortField = regexp.MustCompile(`^-?[A-Za-z0-9_.]+$`)
+
+// searchFacets returns the facets named in list, separated by commas, from
+// those provided by the types. It returns false if a facet named isn't provided.
+func searchFacets(types []string, list string) ([]search.Facet, bool) {
+	provided := make(map[string]search.Facet)
+	for _, t := range types {
+		f, ok := item.Types[t]().(search.Facetable)
+		if !ok {
+			continue
+		}
+
+		for _, facet := range f.SearchFacets() {
+			provided[facet.Name] = facet
+		}
+	}
+
+	var facets []search.Facet
+	for _, name := range strings.Split(list, ",") {
+		facet, ok := provided[strings.TrimSpace(name)]
+		if !ok {
+			return nil, false
+		}
+
+		facets = append(facets, facet)
+	}
+
+	return facets, true
+}
+
+// searchTypes returns the searchable types listed, separated by commas, or all
+// searchable types if list is empty, leaving out types hidden from the request.
+// It returns false if a type listed doesn't exist.
+func searchTypes(res http.ResponseWriter, req *http.Request, list string) ([]string, bool) {
+	var names []string
+	if list == "" {
+		for t := range search.Search {
+			names = append(names, t)
+		}
+		sort.Strings(names)
+	} else {
+		for _, t := range strings.Split(list, ",") {
+			t = strings.TrimSpace(t)
+			if _, ok := item.Types[t]; !ok {
+				return nil, false
+			}
+
+			names = append(names, t)
+		}
+	}
+
+	var types []string
+	for _, t := range names {
+		it, ok := item.Types[t]
+		if !ok {
+			continue
+		}
+
+		if !isHidden(res, req, t, it()) {
+			types = append(types, t)
+		}
+	}
+
+	return types, true
+}
+
+// searchResult returns the JSON of a search result for the item it, with its
+// omitted fields removed and only the requested fields, if any. The hit is
+// highlighted from the item once its omitted fields are removed, so fragments
+// never reveal them.
+func searchResult(res http.ResponseWriter, req *http.Request, it interface{}, b []byte, hit *search.Hit) (json.RawMessage, error) {
+	j, err := fmtJSON(json.RawMessage(b))
+	if err != nil {
+		return nil, err
 	}
 
-	var result = []json.RawMessage{}
-	for i := range bb {
-		result = append(result, bb[i])
+	j, err = omit(res, req, it, j)
+	if err != nil {
+		return nil, err
 	}
 
-	j, err := fmtJSON(result...)
+	err = hit.Highlight([]byte(gjson.GetBytes(j, "data.0").Raw))
 	if err != nil {
-		res.WriteHeader(http.StatusInternalServerError)
-		return
+		log.Println("[search] Error highlighting hit:", hit.Target(), err)
 	}
 
-	j, err = omit(res, req, it(), j)
+	j, err = selectFields(req, j, "data")
 	if err != nil {
-		res.WriteHeader(http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 
-	sendData(res, req, j)
+	return json.RawMessage(gjson.GetBytes(j, "data.0").Raw), nil
 }
@@ -7,17 +7,39 @@ import "net/http"
 
 // Run adds Handlers to default http listener for API
 func Run() {
-	http.HandleFunc("/api/contents", Record(CORS(Gzip(contentsHandler))))
+	http.HandleFunc("/api/contents", Record(CORS(KeyAuth(Limit(CompressCached(contentsHandler))))))
 
-	http.HandleFunc("/api/content", Record(CORS(Gzip(contentHandler))))
+	http.HandleFunc("/api/content", Record(CORS(KeyAuth(Limit(Compress(contentHandler))))))
 
-	http.HandleFunc("/api/content/create", Record(CORS(createContentHandler)))
+	http.HandleFunc("/api/content/create", Record(CORS(KeyAuth(Limit(createContentHandler)))))
 
-	http.HandleFunc("/api/content/update", Record(CORS(updateContentHandler)))
+	http.HandleFunc("/api/content/update", Record(CORS(KeyAuth(Limit(updateContentHandler)))))
 
-	http.HandleFunc("/api/content/delete", Record(CORS(deleteContentHandler)))
+	http.HandleFunc("/api/content/delete", Record(CORS(KeyAuth(Limit(deleteContentHandler)))))
 
-	http.HandleFunc("/api/search", Record(CORS(Gzip(searchContentHandler))))
+	http.HandleFunc("/api/batch", Record(CORS(KeyAuth(Limit(batchHandler)))))
 
-	http.HandleFunc("/api/uploads", Record(CORS(Gzip(uploadsHandler))))
+	http.HandleFunc("/api/search", Record(CORS(KeyAuth(Limit(Compress(searchContentHandler))))))
+
+	http.HandleFunc("/api/search/suggest", Record(CORS(KeyAuth(Limit(Compress(suggestHandler))))))
+
+	http.HandleFunc("/api/feed", Record(CORS(KeyAuth(Limit(Compress(feedHandler))))))
+
+	http.HandleFunc("/api/uploads", Record(CORS(KeyAuth(Limit(Compress(uploadsHandler))))))
+
+	http.HandleFunc("/api/changes", Record(CORS(KeyAuth(Limit(Compress(changesHandler))))))
+
+	http.HandleFunc("/api/sync", Record(CORS(KeyAuth(Limit(Compress(syncHandler))))))
+
+	http.HandleFunc("/api/stream", Record(CORS(KeyAuth(Limit(streamHandler)))))
+
+	http.HandleFunc("/api/graphql", Record(CORS(KeyAuth(Limit(Compress(graphqlHandler))))))
+
+	http.HandleFunc("/api/openapi.json", Record(CORS(KeyAuth(Limit(Compress(openapiHandler))))))
+
+	http.HandleFunc("/api/docs", Record(Compress(openapiDocsHandler)))
+
+	http.HandleFunc("/api/v2/", Record(CORS(KeyAuth(Limit(v2Handler)))))
+
+	http.HandleFunc("/sitemap.xml", Record(CORS(Limit(Compress(sitemapHandler)))))
 }
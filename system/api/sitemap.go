@@ -0,0 +1,283 @@
+package api
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kudzu-cms/kudzu/system/db"
+	"github.com/kudzu-cms/kudzu/system/item"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// maxSitemapURLs is the most URLs a sitemap may list. Sites with more are
+	// split into pages, listed by a sitemap index.
+	maxSitemapURLs = 50000
+
+	defaultSitemapPattern = "/{type}/{slug}"
+)
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// sitemapEntry is the URL of an item in the sitemap, and when it was updated
+type sitemapEntry struct {
+	loc     string
+	updated time.Time
+}
+
+// sitemapCache keeps the entries of the sitemap last built, and the key of the
+// site URL, configuration and versions of content it was built from, so the
+// content index is only read again once something has changed
+var sitemapCache struct {
+	sync.Mutex
+	key     string
+	entries []sitemapEntry
+}
+
+func sitemapHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		sendMethodError(res, http.MethodGet, http.MethodHead)
+		return
+	}
+
+	entries, err := cachedSitemapEntries(req)
+	if err != nil {
+		log.Println("[Sitemap] error building sitemap:", err)
+		sendInternalError(res)
+		return
+	}
+
+	pages := (len(entries) + maxSitemapURLs - 1) / maxSitemapURLs
+	if pages == 0 {
+		pages = 1
+	}
+
+	var body []byte
+	var updated time.Time
+	if p := req.URL.Query().Get("page"); p != "" {
+		var page int // int: page of the sitemap listed by the sitemap index, from 1
+		page, err = strconv.Atoi(p)
+		if err != nil || page < 1 || page > pages {
+			sendParamError(res, errInvalidParam, "page", fmt.Sprintf("The page param must be an integer from 1 to %d", pages))
+			return
+		}
+
+		body, updated, err = renderURLSet(sitemapPage(entries, page))
+	} else if pages > 1 {
+		body, updated, err = renderSitemapIndex(req, entries, pages)
+	} else {
+		body, updated, err = renderURLSet(entries)
+	}
+	if err != nil {
+		log.Println("[Sitemap] error rendering sitemap:", err)
+		sendInternalError(res)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	addVary(res.Header(), "Accept-Encoding")
+
+	if validate(res, req, responseEtag(req, body), updated) {
+		return
+	}
+
+	_, err = res.Write(body)
+	if err != nil {
+		log.Println("[Sitemap] error writing response:", err)
+	}
+}
+
+// cachedSitemapEntries returns the entries of the sitemap, building them only
+// if the site URL, sitemap configuration or content of any type has changed
+// since they were last built
+func cachedSitemapEntries(req *http.Request) ([]sitemapEntry, error) {
+	base := requestBaseURL(req)
+
+	pattern, _ := db.ConfigCache("sitemap_url_pattern").(string)
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		pattern = defaultSitemapPattern
+	}
+
+	excluded := make(map[string]bool)
+	if v, ok := db.ConfigCache("sitemap_exclude").(string); ok {
+		for _, t := range splitList(v) {
+			excluded[t] = true
+		}
+	}
+
+	// versions are read before the content, so a change made while the entries
+	// are built makes them stale rather than being missed
+	types := make([]string, 0, len(item.Types))
+	for t := range item.Types {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	key := &strings.Builder{}
+	fmt.Fprintf(key, "%s\x00%s\x00%v", base, pattern, excluded)
+	for _, t := range types {
+		fmt.Fprintf(key, "\x00%s:%d", t, db.ContentVersion(t))
+	}
+
+	sitemapCache.Lock()
+	defer sitemapCache.Unlock()
+
+	if sitemapCache.entries != nil && sitemapCache.key == key.String() {
+		return sitemapCache.entries, nil
+	}
+
+	entries, err := sitemapEntries(base, pattern, excluded)
+	if err != nil {
+		return nil, err
+	}
+
+	sitemapCache.key = key.String()
+	sitemapCache.entries = entries
+
+	return entries, nil
+}
+
+// sitemapEntries returns the URL of each item of public content with a slug,
+// in order of slug. Content types which are Hideable or excluded aren't
+// included, and URLs are resolved against the base URL.
+func sitemapEntries(base *url.URL, pattern string, excluded map[string]bool) ([]sitemapEntry, error) {
+	entries := []sitemapEntry{}
+	err := db.ContentIndex(func(slug, t string, data []byte) error {
+		it, ok := item.Types[t]
+		if !ok || excluded[t] {
+			return nil
+		}
+
+		p := it()
+		if _, ok := p.(item.Hideable); ok {
+			return nil
+		}
+
+		err := json.Unmarshal(data, p)
+		if err != nil {
+			return err
+		}
+
+		var loc string
+		if m, ok := p.(item.Mappable); ok {
+			loc = m.SitemapURL()
+			if loc == "" {
+				return nil
+			}
+		} else {
+			loc = strings.NewReplacer(
+				"{type}", strings.ToLower(t),
+				"{slug}", url.PathEscape(slug),
+				"{id}", gjson.GetBytes(data, "id").String(),
+			).Replace(pattern)
+		}
+
+		u, err := url.Parse(loc)
+		if err != nil {
+			log.Println("[Sitemap] skipping invalid URL", loc, "for", t+":", err)
+			return nil
+		}
+
+		e := sitemapEntry{loc: base.ResolveReference(u).String()}
+		if s, ok := p.(item.Sortable); ok && s.Touch() > 0 {
+			e.updated = time.Unix(0, s.Touch()*int64(time.Millisecond))
+		}
+
+		entries = append(entries, e)
+		return nil
+	})
+
+	return entries, err
+}
+
+// renderURLSet renders the entries as a sitemap, and returns when the latest of
+// them was updated
+func renderURLSet(entries []sitemapEntry) ([]byte, time.Time, error) {
+	var updated time.Time
+	set := sitemapURLSet{URLs: []sitemapURL{}}
+	for _, e := range entries {
+		set.URLs = append(set.URLs, sitemapURL{Loc: e.loc, LastMod: lastMod(e.updated)})
+
+		if e.updated.After(updated) {
+			updated = e.updated
+		}
+	}
+
+	b, err := marshalXML(set)
+	return b, updated, err
+}
+
+// renderSitemapIndex renders a sitemap index listing each page of the entries,
+// and returns when the latest of them was updated
+func renderSitemapIndex(req *http.Request, entries []sitemapEntry, pages int) ([]byte, time.Time, error) {
+	base := requestBaseURL(req)
+
+	var updated time.Time
+	index := sitemapIndex{}
+	for page := 1; page <= pages; page++ {
+		var pageUpdated time.Time
+		for _, e := range sitemapPage(entries, page) {
+			if e.updated.After(pageUpdated) {
+				pageUpdated = e.updated
+			}
+		}
+
+		loc := base.ResolveReference(&url.URL{
+			Path:     req.URL.Path,
+			RawQuery: url.Values{"page": {strconv.Itoa(page)}}.Encode(),
+		})
+		index.Sitemaps = append(index.Sitemaps, sitemapURL{Loc: loc.String(), LastMod: lastMod(pageUpdated)})
+
+		if pageUpdated.After(updated) {
+			updated = pageUpdated
+		}
+	}
+
+	b, err := marshalXML(index)
+	return b, updated, err
+}
+
+// lastMod formats t in the W3C Datetime format of sitemaps, or returns "" if t
+// is zero
+func lastMod(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
+
+// sitemapPage returns the entries on a page of the sitemap, numbered from 1
+func sitemapPage(entries []sitemapEntry, page int) []sitemapEntry {
+	start := (page - 1) * maxSitemapURLs
+	end := start + maxSitemapURLs
+	if end > len(entries) {
+		end = len(entries)
+	}
+
+	return entries[start:end]
+}
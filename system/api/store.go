@@ -0,0 +1,29 @@
+package api
+
+import (
+	"net/url"
+
+	"github.com/kudzu-cms/kudzu/system/db"
+)
+
+// contentStore writes content for the content API. The changes of an
+// atomic batch are stored with its db.Tx, so they are committed together.
+type contentStore interface {
+	SetContent(target string, data url.Values) (int, error)
+	UpdateContent(target string, data url.Values) (int, error)
+	DeleteContent(target string) error
+}
+
+type dbStore struct{}
+
+func (dbStore) SetContent(target string, data url.Values) (int, error) {
+	return db.SetContent(target, data)
+}
+
+func (dbStore) UpdateContent(target string, data url.Values) (int, error) {
+	return db.UpdateContent(target, data)
+}
+
+func (dbStore) DeleteContent(target string) error {
+	return db.DeleteContent(target)
+}
@@ -0,0 +1,261 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kudzu-cms/kudzu/system/db"
+	"github.com/kudzu-cms/kudzu/system/item"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// streamKeepAlive is how often a comment is sent on an idle stream, so
+	// proxies don't close the connection
+	streamKeepAlive = time.Second * 25
+
+	// streamRetry is the reconnection delay in milliseconds sent to clients
+	streamRetry = 3000
+
+	// streamBatch is the number of changes read from the change log at a time
+	streamBatch = 100
+)
+
+// streamUploads is the type of changes to file uploads in the change log, which
+// are only streamed when requested by type
+const streamUploads = "__uploads"
+
+// streamEvents maps the ops recorded in the change log to the events sent on
+// the stream. Approvals are left out as they follow an insert of the item.
+var streamEvents = map[string]string{
+	db.ChangeInsert: "create",
+	db.ChangeUpload: "create",
+	db.ChangeUpdate: "update",
+	db.ChangeDelete: "delete",
+}
+
+// streamEvent is the data of an event sent on the stream
+type streamEvent struct {
+	Seq       uint64          `json:"seq"`
+	Type      string          `json:"type"`
+	ID        int             `json:"id"`
+	Timestamp int64           `json:"timestamp"`
+	Item      json.RawMessage `json:"item,omitempty"`
+}
+
+// streamHandler sends changes to content as server-sent events. Each stream
+// waits on db.ChangeNotify and reads the change log itself, so a slow client
+// never holds up writes or other streams.
+func streamHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		sendMethodError(res, http.MethodGet)
+		return
+	}
+
+	flusher, ok := res.(http.Flusher)
+	if !ok {
+		log.Println("[Stream] error: response does not support streaming")
+		sendInternalError(res)
+		return
+	}
+
+	q := req.URL.Query()
+	t := q.Get("type")
+	if t != "" && t != streamUploads {
+		it, ok := item.Types[t]
+		if !ok {
+			sendUnknownType(res, http.StatusNotFound, t)
+			return
+		}
+
+		if hide(res, req, it()) {
+			return
+		}
+	}
+
+	withItems := q.Get("items") == "true" // bool: include the item in create and update events (false default)
+
+	// resume from the last event a reconnecting client received, or from a
+	// sequence number given by the client, otherwise start with new changes
+	last := req.Header.Get("Last-Event-ID")
+	if last == "" {
+		last = q.Get("since")
+	}
+
+	var since uint64
+	if last != "" {
+		var err error
+		since, err = strconv.ParseUint(last, 10, 64)
+		if err != nil {
+			sendParamError(res, errInvalidParam, "since", "The since param and Last-Event-ID header must be sequence numbers")
+			return
+		}
+	} else {
+		var err error
+		since, err = db.LastChange()
+		if err != nil {
+			log.Println("[Stream] error:", err)
+			sendInternalError(res)
+			return
+		}
+	}
+
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+
+	_, err := fmt.Fprintf(res, "retry: %d\n\n", streamRetry)
+	if err != nil {
+		return
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	hidden := make(map[string]bool)
+	for {
+		// get the notification channel before reading so a change committed
+		// between the read and the wait can't be missed
+		notify := db.ChangeNotify()
+
+		changes, err := db.Changes(since, streamBatch, t)
+		if err != nil {
+			log.Println("[Stream] error reading change log:", err)
+			return
+		}
+
+		for _, c := range changes {
+			since = c.Seq
+
+			event, ok := streamEvents[c.Op]
+			if !ok || typeHidden(res, req, c.Type, hidden) {
+				continue
+			}
+
+			data := streamEvent{
+				Seq:       c.Seq,
+				Type:      c.Type,
+				ID:        c.ID,
+				Timestamp: c.Timestamp,
+			}
+
+			if withItems && event != "delete" {
+				j, show, err := streamItem(res, req, c)
+				if err != nil {
+					log.Println("[Stream] error reading item:", c.Type, c.ID, err)
+					return
+				}
+
+				if !show {
+					continue
+				}
+
+				data.Item = j
+			}
+
+			j, err := json.Marshal(data)
+			if err != nil {
+				log.Println("[Stream] error marshalling event to JSON:", err)
+				return
+			}
+
+			_, err = fmt.Fprintf(res, "id: %d\nevent: %s\ndata: %s\n\n", c.Seq, event, j)
+			if err != nil {
+				return
+			}
+		}
+
+		if len(changes) > 0 {
+			flusher.Flush()
+		}
+
+		if len(changes) == streamBatch {
+			continue
+		}
+
+		select {
+		case <-notify:
+		case <-keepAlive.C:
+			_, err := fmt.Fprint(res, ": keep-alive\n\n")
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// typeHidden reports whether content of the type is hidden from the request,
+// remembering the result for the rest of the stream. System types such as
+// __uploads are hidden unless the stream was requested for the type.
+func typeHidden(res http.ResponseWriter, req *http.Request, t string, hidden map[string]bool) bool {
+	h, ok := hidden[t]
+	if !ok {
+		if it, found := item.Types[t]; found {
+			h = isHidden(res, req, t, it())
+		} else {
+			h = strings.HasPrefix(t, "__") && req.URL.Query().Get("type") != t
+		}
+
+		hidden[t] = h
+	}
+
+	return h
+}
+
+// streamItem returns the current JSON of the item changed, with its omitted
+// fields removed and only the requested fields, if any. It returns false if the
+// item has since been deleted or is hidden from the request. Uploads are sent
+// without an item.
+func streamItem(res http.ResponseWriter, req *http.Request, c db.Change) ([]byte, bool, error) {
+	it, ok := item.Types[c.Type]
+	if !ok {
+		return nil, c.Type == streamUploads, nil
+	}
+
+	b, err := db.Content(fmt.Sprintf("%s:%d", c.Type, c.ID))
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(b) == 0 {
+		return nil, false, nil
+	}
+
+	p := it()
+	err = json.Unmarshal(b, p)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if isHidden(res, req, c.Type, p) {
+		return nil, false, nil
+	}
+
+	j, err := fmtJSON(json.RawMessage(b))
+	if err != nil {
+		return nil, false, err
+	}
+
+	j, err = omit(res, req, p, j)
+	if err != nil {
+		return nil, false, err
+	}
+
+	j, err = selectFields(req, j, "data")
+	if err != nil {
+		return nil, false, err
+	}
+
+	return []byte(gjson.GetBytes(j, "data.0").Raw), true, nil
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kudzu-cms/kudzu/system/db"
+	"github.com/kudzu-cms/kudzu/system/item"
+	"github.com/kudzu-cms/kudzu/system/search"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// defaultSuggestions is the number of suggestions returned if not requested
+	defaultSuggestions = 5
+
+	// maxSuggestions is the most suggestions a request may return
+	maxSuggestions = 20
+)
+
+// suggestion is the lightweight form of content returned by the suggest API
+type suggestion struct {
+	ID    int    `json:"id"`
+	Slug  string `json:"slug"`
+	Title string `json:"title"`
+}
+
+func suggestHandler(res http.ResponseWriter, req *http.Request) {
+	qs := req.URL.Query()
+
+	t := qs.Get("type")
+	if t == "" {
+		sendParamError(res, errMissingParam, "type", "The type param is required")
+		return
+	}
+
+	it, ok := item.Types[t]
+	if !ok {
+		sendUnknownType(res, http.StatusBadRequest, t)
+		return
+	}
+
+	if hide(res, req, it()) {
+		return
+	}
+
+	cacheControl(res, req, it())
+
+	// q must be set
+	q := strings.TrimSpace(qs.Get("q"))
+	if q == "" {
+		sendParamError(res, errMissingParam, "q", "The q param is required")
+		return
+	}
+
+	count := defaultSuggestions // int: number of suggestions to return (5 default, 20 max)
+	if c := qs.Get("count"); c != "" {
+		var err error
+		count, err = strconv.Atoi(c)
+		if err != nil || count < 1 || count > maxSuggestions {
+			sendParamError(res, errInvalidParam, "count", fmt.Sprintf("The count param must be an integer from 1 to %d", maxSuggestions))
+			return
+		}
+	}
+
+	// no index, or nothing to suggest from, for the type sends 404
+	hits, err := search.Suggest(t, q, count)
+	if err == search.ErrNoIndex || err == search.ErrNoSuggest {
+		sendError(res, http.StatusNotFound, errSearchDisabled, "Suggestions aren't enabled for content of type "+t)
+		return
+	}
+	if err != nil {
+		log.Println("[search] Error:", err)
+		sendInternalError(res)
+		return
+	}
+
+	var data = []suggestion{}
+	for _, hit := range hits {
+		b, err := db.Content(fmt.Sprintf("%s:%d", t, hit.ID))
+		if err != nil {
+			log.Println("[search] Error:", err)
+			sendInternalError(res)
+			return
+		}
+
+		// the index may briefly hold content which has been deleted
+		if len(b) == 0 {
+			continue
+		}
+
+		p := it()
+		err = json.Unmarshal(b, p)
+		if err != nil {
+			log.Println("[search] Error:", err)
+			sendInternalError(res)
+			return
+		}
+
+		if isHidden(res, req, t, p) {
+			continue
+		}
+
+		// the title is built from the item as other responses show it, since
+		// String may use fields the type omits
+		j, err := fmtJSON(b)
+		if err != nil {
+			sendInternalError(res)
+			return
+		}
+
+		j, err = omit(res, req, it(), j)
+		if err != nil {
+			sendHookError(res, err)
+			return
+		}
+
+		shown := it()
+		err = json.Unmarshal([]byte(gjson.GetBytes(j, "data.0").Raw), shown)
+		if err != nil {
+			log.Println("[search] Error:", err)
+			sendInternalError(res)
+			return
+		}
+
+		s := suggestion{ID: hit.ID, Slug: gjson.GetBytes(j, "data.0.slug").String()}
+		if i, ok := shown.(item.Identifiable); ok {
+			s.Title = i.String()
+		}
+
+		data = append(data, s)
+	}
+
+	j, err := json.Marshal(map[string]interface{}{"data": data})
+	if err != nil {
+		sendInternalError(res)
+		return
+	}
+
+	// a title or slug the type omits from responses is left out
+	j, err = omit(res, req, it(), j)
+	if err != nil {
+		sendHookError(res, err)
+		return
+	}
+
+	sendData(res, req, j)
+}
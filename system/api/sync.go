@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/kudzu-cms/kudzu/system/db"
+	"github.com/kudzu-cms/kudzu/system/item"
+
+	"github.com/tidwall/sjson"
+)
+
+// timestampCursor is the smallest since param treated as a timestamp cursor
+// rather than a sequence number, 2001-09-09 in milliseconds since Unix epoch
+const timestampCursor = 1000000000000
+
+type tombstone struct {
+	ID      int   `json:"id"`
+	Deleted int64 `json:"deleted"`
+}
+
+func syncHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		sendMethodError(res, http.MethodGet)
+		return
+	}
+
+	q := req.URL.Query()
+	t := q.Get("type")
+	if t == "" {
+		sendParamError(res, errMissingParam, "type", "The type param is required")
+		return
+	}
+
+	it, ok := item.Types[t]
+	if !ok {
+		sendUnknownType(res, http.StatusNotFound, t)
+		return
+	}
+
+	if hide(res, req, it()) {
+		return
+	}
+
+	since, err := strconv.ParseUint(q.Get("since"), 10, 64) // int: cursor from the previous sync, a change log sequence number (0 default)
+	if err != nil {
+		if q.Get("since") == "" {
+			since = 0
+		} else {
+			sendParamError(res, errInvalidParam, "since", "The since param must be a cursor from a previous sync")
+			return
+		}
+	}
+
+	// since may also be the latest updated timestamp, in milliseconds, of the
+	// items a client has. Timestamps are far larger than any sequence number,
+	// so one is read as the last change made at or before it, or a reset if
+	// the change log doesn't go back that far.
+	var reset bool
+	if since >= timestampCursor {
+		seq, ok, err := db.ChangeAt(int64(since))
+		if err != nil {
+			log.Println("[Sync] error migrating timestamp cursor for type:", t, err)
+			sendInternalError(res)
+			return
+		}
+
+		since, reset = seq, !ok
+	}
+
+	// content and the change log are read together, so the cursor is the last
+	// change included, and anything committed after it is picked up next time.
+	// If changes since the cursor have been pruned, deletions may have been
+	// missed, so the client must replace its replica entirely.
+	changes, err := db.ContentChangedSince(t, since)
+	if err != nil {
+		log.Println("[Sync] error reading changes for type:", t, err)
+		sendInternalError(res)
+		return
+	}
+
+	if reset {
+		changes.Reset = true
+	}
+
+	deleted := []tombstone{}
+	for _, d := range changes.Deletions {
+		deleted = append(deleted, tombstone{ID: d.ID, Deleted: d.Timestamp})
+	}
+
+	var result = []json.RawMessage{}
+	for i := range changes.Items {
+		result = append(result, changes.Items[i])
+	}
+
+	j, err := fmtJSON(result...)
+	if err != nil {
+		sendInternalError(res)
+		return
+	}
+
+	j, err = omit(res, req, it(), j)
+	if err != nil {
+		sendHookError(res, err)
+		return
+	}
+
+	j, err = sjson.SetBytes(j, "deleted", deleted)
+	if err != nil {
+		sendInternalError(res)
+		return
+	}
+
+	j, err = sjson.SetBytes(j, "cursor", changes.Cursor)
+	if err != nil {
+		sendInternalError(res)
+		return
+	}
+
+	j, err = sjson.SetBytes(j, "reset", changes.Reset)
+	if err != nil {
+		sendInternalError(res)
+		return
+	}
+
+	// assert hookable
+	get := it()
+	hook, ok := get.(item.Hookable)
+	if !ok {
+		log.Println("[Response] error: Type", t, "does not implement item.Hookable or embed item.Item.")
+		sendInternalError(res)
+		return
+	}
+
+	// hook before response
+	j, err = hook.BeforeAPIResponse(res, req, j)
+	if err != nil {
+		log.Println("[Response] error calling BeforeAPIResponse:", err)
+		sendHookError(res, err)
+		return
+	}
+
+	// each sync response depends on its cursor, so must not be cached
+	res.Header().Set("Cache-Control", "no-store")
+
+	sendData(res, req, j)
+
+	// hook after response
+	err = hook.AfterAPIResponse(res, req, j)
+	if err != nil {
+		log.Println("[Response] error calling AfterAPIResponse:", err)
+		return
+	}
+}
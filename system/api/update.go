@@ -6,10 +6,9 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"strings"
 	"time"
 
-	"github.com/kudzu-cms/kudzu/system/admin/upload"
+	"github.com/kudzu-cms/kudzu/system/admin/apikey"
 	"github.com/kudzu-cms/kudzu/system/db"
 	"github.com/kudzu-cms/kudzu/system/item"
 
@@ -25,34 +24,40 @@ type Updateable interface {
 
 func updateContentHandler(res http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodPost {
-		res.WriteHeader(http.StatusMethodNotAllowed)
+		sendMethodError(res, http.MethodPost)
 		return
 	}
 
-	err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
+	err := parseContentForm(req)
 	if err != nil {
 		log.Println("[Update] error:", err)
-		res.WriteHeader(http.StatusInternalServerError)
+		sendError(res, http.StatusBadRequest, errInvalidBody, "The request body must be a valid form or JSON object")
 		return
 	}
 
 	t := req.URL.Query().Get("type")
 	if t == "" {
-		res.WriteHeader(http.StatusBadRequest)
+		sendParamError(res, errMissingParam, "type", "The type param is required")
 		return
 	}
 
 	p, found := item.Types[t]
 	if !found {
 		log.Println("[Update] attempt to update content unknown type:", t, "from:", req.RemoteAddr)
-		res.WriteHeader(http.StatusNotFound)
+		sendUnknownType(res, http.StatusNotFound, t)
 		return
 	}
 
 	id := req.URL.Query().Get("id")
+	if id == "" {
+		log.Println("[Update] attempt to update content with missing id from:", req.RemoteAddr)
+		sendParamError(res, errMissingParam, "id", "The id param is required")
+		return
+	}
+
 	if !db.IsValidID(id) {
-		log.Println("[Update] attempt to update content with missing or invalid id from:", req.RemoteAddr)
-		res.WriteHeader(http.StatusBadRequest)
+		log.Println("[Update] attempt to update content with invalid id from:", req.RemoteAddr)
+		sendParamError(res, errInvalidParam, "id", "The id param must be a positive integer")
 		return
 	}
 
@@ -61,21 +66,25 @@ func updateContentHandler(res http.ResponseWriter, req *http.Request) {
 	j, err := db.Content(t + ":" + id)
 	if err != nil {
 		log.Println("[Update] error getting content for type:", t, err)
-		res.WriteHeader(http.StatusInternalServerError)
+		sendInternalError(res)
+		return
+	}
+
+	if len(j) == 0 {
+		sendError(res, http.StatusNotFound, errNotFound, "There is no "+t+" content with the id "+id)
 		return
 	}
 
 	err = json.Unmarshal(j, post)
 	if err != nil {
 		log.Println("[Update] error populating data in type:", t, err)
-		res.WriteHeader(http.StatusInternalServerError)
+		sendInternalError(res)
 		return
 	}
 
-	ext, ok := post.(Updateable)
-	if !ok {
+	if _, ok := post.(Updateable); !ok {
 		log.Println("[Update] rejected non-updateable type:", t, "from:", req.RemoteAddr)
-		res.WriteHeader(http.StatusBadRequest)
+		sendError(res, http.StatusBadRequest, errNotUpdateable, "Content of type "+t+" can't be updated through the API")
 		return
 	}
 
@@ -83,170 +92,152 @@ func updateContentHandler(res http.ResponseWriter, req *http.Request) {
 	req.PostForm.Set("timestamp", ts)
 	req.PostForm.Set("updated", ts)
 
-	urlPaths, err := upload.StoreFiles(req)
+	err = prepareContentForm(req)
 	if err != nil {
 		log.Println(err)
-		res.WriteHeader(http.StatusInternalServerError)
+		sendInternalError(res)
 		return
 	}
 
-	for name, urlPath := range urlPaths {
-		req.PostForm.Set(name, urlPath)
+	err = updateContent(res, req, t, id, post, false)
+	if err != nil {
+		return
 	}
 
-	// check for any multi-value fields (ex. checkbox fields)
-	// and correctly format for db storage. Essentially, we need
-	// fieldX.0: value1, fieldX.1: value2 => fieldX: []string{value1, value2}
-	fieldOrderValue := make(map[string]map[string][]string)
-	for k, v := range req.PostForm {
-		if strings.Contains(k, ".") {
-			fo := strings.Split(k, ".")
+	// create JSON response to send data back to client
+	data := map[string]interface{}{
+		"id":     id,
+		"status": "public",
+		"type":   t,
+	}
 
-			// put the order and the field value into map
-			field := string(fo[0])
-			order := string(fo[1])
-			if len(fieldOrderValue[field]) == 0 {
-				fieldOrderValue[field] = make(map[string][]string)
-			}
+	resp := map[string]interface{}{
+		"data": []map[string]interface{}{
+			data,
+		},
+	}
+
+	j, err = json.Marshal(resp)
+	if err != nil {
+		log.Println("[Update] error marshalling response to JSON:", err)
+		sendInternalError(res)
+		return
+	}
 
-			// orderValue is 0:[?type=Thing&id=1]
-			orderValue := fieldOrderValue[field]
-			orderValue[order] = v
-			fieldOrderValue[field] = orderValue
+	res.Header().Set("Content-Type", "application/json")
+	_, err = res.Write(j)
+	if err != nil {
+		log.Println("[Update] error writing response:", err)
+		return
+	}
 
-			// discard the post form value with name.N
-			req.PostForm.Del(k)
-		}
+}
 
+// updateContent decodes the values in req.PostForm into post, calls the update
+// hooks and merges the values into the stored content of type t with the id
+// provided, or replaces the stored content with them if replace is true. If an
+// error is returned, the hooks or updateContent will have written any response
+// status or error.
+func updateContent(res http.ResponseWriter, req *http.Request, t, id string, post interface{}, replace bool) error {
+	c, err := prepareUpdate(res, req, t, id, post, replace)
+	if err != nil {
+		return err
 	}
 
-	// add/set the key & value to the post form in order
-	for f, ov := range fieldOrderValue {
-		for i := 0; i < len(ov); i++ {
-			position := fmt.Sprintf("%d", i)
-			fieldValue := ov[position]
-
-			if req.PostForm.Get(f) == "" {
-				for i, fv := range fieldValue {
-					if i == 0 {
-						req.PostForm.Set(f, fv)
-					} else {
-						req.PostForm.Add(f, fv)
-					}
-				}
-			} else {
-				for _, fv := range fieldValue {
-					req.PostForm.Add(f, fv)
-				}
-			}
-		}
+	return c.run(dbStore{})
+}
+
+// prepareUpdate decodes the values in req.PostForm into post and calls the
+// hooks run before the content of type t with the id provided is updated,
+// returning the change which stores it and calls the hooks run after. If an
+// error is returned, the hooks or prepareUpdate will have written any response
+// status or error.
+func prepareUpdate(res http.ResponseWriter, req *http.Request, t, id string, post interface{}, replace bool) (*contentChange, error) {
+	ext, ok := post.(Updateable)
+	if !ok {
+		sendError(res, http.StatusBadRequest, errNotUpdateable, "Content of type "+t+" can't be updated through the API")
+		return nil, fmt.Errorf("Type %s does not implement api.Updateable", t)
+	}
+
+	if !keyAllows(req, t, apikey.ScopeUpdate) {
+		sendScopeError(res, t, apikey.ScopeUpdate)
+		return nil, fmt.Errorf("API key does not have the update scope for type %s", t)
 	}
 
 	hook, ok := post.(item.Hookable)
 	if !ok {
 		log.Println("[Update] error: Type", t, "does not implement item.Hookable or embed item.Item.")
-		res.WriteHeader(http.StatusBadRequest)
-		return
+		sendInternalError(res)
+		return nil, fmt.Errorf("Type %s does not implement item.Hookable", t)
 	}
 
 	// Let's be nice and make a proper item for the Hookable methods
 	dec := schema.NewDecoder()
 	dec.IgnoreUnknownKeys(true)
 	dec.SetAliasTag("json")
-	err = dec.Decode(post, req.PostForm)
+	err := dec.Decode(post, req.PostForm)
 	if err != nil {
 		log.Println("Error decoding post form for edit handler:", t, err)
-		res.WriteHeader(http.StatusInternalServerError)
-		return
+		sendError(res, http.StatusBadRequest, errInvalidBody, "The request body has values which don't match the fields of "+t)
+		return nil, err
 	}
 
 	err = hook.BeforeAPIUpdate(res, req)
 	if err != nil {
 		log.Println("[Update] error calling BeforeAPIUpdate:", err)
-		if err == ErrNoAuth {
+		if !hookError(res, err) && err == ErrNoAuth {
 			// BeforeAPIUpdate can check user.IsValid(req) for auth
-			res.WriteHeader(http.StatusUnauthorized)
+			sendError(res, http.StatusUnauthorized, errUnauthorized, "The request isn't authorized")
 		}
-		return
+		return nil, err
 	}
 
 	err = ext.Update(res, req)
 	if err != nil {
 		log.Println("[Update] error calling Update:", err)
-		if err == ErrNoAuth {
+		if !hookError(res, err) && err == ErrNoAuth {
 			// Update can check user.IsValid(req) or other forms of validation for auth
-			res.WriteHeader(http.StatusUnauthorized)
+			sendError(res, http.StatusUnauthorized, errUnauthorized, "The request isn't authorized")
 		}
-		return
+		return nil, err
 	}
 
 	err = hook.BeforeSave(res, req)
 	if err != nil {
 		log.Println("[Update] error calling BeforeSave:", err)
-		return
+		hookError(res, err)
+		return nil, err
 	}
 
-	// set specifier for db bucket in case content is/isn't Trustable
-	var spec string
-
-	_, err = db.UpdateContent(t+spec+":"+id, req.PostForm)
-	if err != nil {
-		log.Println("[Update] error calling UpdateContent:", err)
-		res.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	// set the target in the context so user can get saved value from db in hook
-	ctx := context.WithValue(req.Context(), "target", fmt.Sprintf("%s:%s", t, id))
-	req = req.WithContext(ctx)
-
-	err = hook.AfterSave(res, req)
-	if err != nil {
-		log.Println("[Update] error calling AfterSave:", err)
-		return
-	}
+	c := &contentChange{res: res, req: req, t: t, id: id}
+	c.store = func(s contentStore) (int, error) {
+		if replace {
+			return s.SetContent(t+":"+id, req.PostForm)
+		}
 
-	err = hook.AfterAPIUpdate(res, req)
-	if err != nil {
-		log.Println("[Update] error calling AfterAPIUpdate:", err)
-		return
+		return s.UpdateContent(t+":"+id, req.PostForm)
 	}
+	c.after = func() error {
+		// set the target in the context so user can get saved value from db in hook
+		ctx := context.WithValue(req.Context(), "target", fmt.Sprintf("%s:%s", t, id))
+		req := req.WithContext(ctx)
 
-	// create JSON response to send data back to client
-	var data map[string]interface{}
-	if spec != "" {
-		spec = strings.TrimPrefix(spec, "__")
-		data = map[string]interface{}{
-			"status": spec,
-			"type":   t,
+		err := hook.AfterSave(res, req)
+		if err != nil {
+			log.Println("[Update] error calling AfterSave:", err)
+			hookError(res, err)
+			return err
 		}
-	} else {
-		spec = "public"
-		data = map[string]interface{}{
-			"id":     id,
-			"status": spec,
-			"type":   t,
-		}
-	}
-
-	resp := map[string]interface{}{
-		"data": []map[string]interface{}{
-			data,
-		},
-	}
 
-	j, err = json.Marshal(resp)
-	if err != nil {
-		log.Println("[Update] error marshalling response to JSON:", err)
-		res.WriteHeader(http.StatusInternalServerError)
-		return
-	}
+		err = hook.AfterAPIUpdate(res, req)
+		if err != nil {
+			log.Println("[Update] error calling AfterAPIUpdate:", err)
+			hookError(res, err)
+			return err
+		}
 
-	res.Header().Set("Content-Type", "application/json")
-	_, err = res.Write(j)
-	if err != nil {
-		log.Println("[Update] error writing response:", err)
-		return
+		return nil
 	}
 
+	return c, nil
 }
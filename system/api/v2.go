@@ -0,0 +1,270 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kudzu-cms/kudzu/system/db"
+	"github.com/kudzu-cms/kudzu/system/item"
+
+	"github.com/tidwall/gjson"
+)
+
+// v2Prefix is the path under which content is served as REST resources:
+//   - /api/v2/{type}: GET to list content, POST to create content
+//   - /api/v2/{type}/{id}: GET, PUT to replace, PATCH to update, DELETE
+//   - /api/v2/{type}/slug/{slug}: as above, for the content with the slug
+const v2Prefix = "/api/v2/"
+
+func v2Handler(res http.ResponseWriter, req *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(req.URL.Path, v2Prefix), "/")
+	parts := strings.Split(path, "/")
+
+	t := parts[0]
+	if _, ok := item.Types[t]; !ok {
+		sendError(res, http.StatusNotFound, errUnknownType, "There is no content type named "+t)
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		v2CollectionHandler(res, req, t)
+
+	case len(parts) == 2 && db.IsValidID(parts[1]):
+		v2ItemHandler(res, req, t, parts[1])
+
+	case len(parts) == 3 && parts[1] == "slug":
+		st, post, err := db.ContentBySlug(parts[2])
+		if st != t {
+			sendError(res, http.StatusNotFound, errNotFound, "There is no "+t+" content with the slug "+parts[2])
+			return
+		}
+		if err != nil {
+			log.Println("[v2] error finding content by slug:", parts[2], err)
+			sendInternalError(res)
+			return
+		}
+
+		v2ItemHandler(res, req, t, gjson.GetBytes(post, "id").String())
+
+	default:
+		sendError(res, http.StatusNotFound, errNotFound, "There is no resource at "+req.URL.Path)
+	}
+}
+
+func v2CollectionHandler(res http.ResponseWriter, req *http.Request, t string) {
+	switch req.Method {
+	case http.MethodGet:
+		CompressCached(contentsHandler)(res, v2Request(req, t, ""))
+
+	case http.MethodPost:
+		v2CreateHandler(res, req, t)
+
+	default:
+		sendMethodError(res, http.MethodGet, http.MethodPost)
+	}
+}
+
+func v2ItemHandler(res http.ResponseWriter, req *http.Request, t, id string) {
+	existing, err := db.Content(t + ":" + id)
+	if err != nil {
+		log.Println("[v2] error getting content:", t, id, err)
+		sendInternalError(res)
+		return
+	}
+
+	if len(existing) == 0 {
+		sendError(res, http.StatusNotFound, errNotFound, "There is no "+t+" content with the id "+id)
+		return
+	}
+
+	post := item.Types[t]()
+	_, updateable := post.(Updateable)
+	_, deleteable := post.(Deleteable)
+
+	allow := []string{http.MethodGet}
+	if updateable {
+		allow = append(allow, http.MethodPut, http.MethodPatch)
+	}
+	if deleteable {
+		allow = append(allow, http.MethodDelete)
+	}
+
+	switch {
+	case req.Method == http.MethodGet:
+		Compress(contentHandler)(res, v2Request(req, t, id))
+
+	case (req.Method == http.MethodPut || req.Method == http.MethodPatch) && updateable:
+		v2UpdateHandler(res, req, t, id, existing)
+
+	case req.Method == http.MethodDelete && deleteable:
+		v2DeleteHandler(res, req, t, id, existing)
+
+	default:
+		sendMethodError(res, allow...)
+	}
+}
+
+func v2CreateHandler(res http.ResponseWriter, req *http.Request, t string) {
+	post := item.Types[t]()
+	if _, ok := post.(Createable); !ok {
+		sendMethodError(res, http.MethodGet)
+		return
+	}
+
+	err := parseContentForm(req)
+	if err != nil {
+		log.Println("[v2] error:", err)
+		sendError(res, http.StatusBadRequest, errInvalidBody, "The request body must be a valid form or JSON object")
+		return
+	}
+
+	// a slug provided by the client must not already be in use
+	if slug := req.PostForm.Get("slug"); slug != "" {
+		st, _, _ := db.ContentBySlug(slug)
+		if st != "" {
+			sendError(res, http.StatusConflict, errSlugConflict, "The slug "+slug+" is already in use")
+			return
+		}
+	}
+
+	ts := fmt.Sprintf("%d", time.Now().UnixNano()/int64(time.Millisecond))
+	req.PostForm.Set("timestamp", ts)
+	req.PostForm.Set("updated", ts)
+
+	err = prepareContentForm(req)
+	if err != nil {
+		log.Println("[v2]", err)
+		sendInternalError(res)
+		return
+	}
+
+	id, spec, err := createContent(res, v2Request(req, t, ""), t, post)
+	if err != nil {
+		return
+	}
+
+	// content which isn't Trustable is pending approval, so has no location yet
+	status := http.StatusAccepted
+	data := map[string]interface{}{
+		"status": strings.TrimPrefix(spec, "__"),
+		"type":   t,
+	}
+
+	if spec == "" {
+		status = http.StatusCreated
+		data["id"] = id
+		data["status"] = "public"
+		res.Header().Set("Location", fmt.Sprintf("%s%s/%d", v2Prefix, t, id))
+	}
+
+	j, err := json.Marshal(map[string]interface{}{
+		"data": []map[string]interface{}{data},
+	})
+	if err != nil {
+		log.Println("[v2] error marshalling response to JSON:", err)
+		sendInternalError(res)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	_, err = res.Write(j)
+	if err != nil {
+		log.Println("[v2] error writing response:", err)
+	}
+}
+
+// v2UpdateHandler replaces the stored content with the request's values for a
+// PUT request, and merges the values into the stored content for PATCH
+func v2UpdateHandler(res http.ResponseWriter, req *http.Request, t, id string, existing []byte) {
+	err := parseContentForm(req)
+	if err != nil {
+		log.Println("[v2] error:", err)
+		sendError(res, http.StatusBadRequest, errInvalidBody, "The request body must be a valid form or JSON object")
+		return
+	}
+
+	// the slug identifies the content, so can't be changed by an update
+	slug := gjson.GetBytes(existing, "slug").String()
+	if s := req.PostForm.Get("slug"); s != "" && s != slug {
+		sendError(res, http.StatusConflict, errSlugConflict, "The slug of existing content can't be changed")
+		return
+	}
+
+	replace := req.Method == http.MethodPut
+	post := item.Types[t]()
+	if replace {
+		// fields set by the system are kept, all others are replaced
+		for _, k := range []string{"uuid", "id", "slug", "timestamp"} {
+			req.PostForm.Set(k, gjson.GetBytes(existing, k).String())
+		}
+	} else {
+		err = json.Unmarshal(existing, post)
+		if err != nil {
+			log.Println("[v2] error populating data in type:", t, err)
+			sendInternalError(res)
+			return
+		}
+	}
+
+	req.PostForm.Set("updated", fmt.Sprintf("%d", time.Now().UnixNano()/int64(time.Millisecond)))
+
+	err = prepareContentForm(req)
+	if err != nil {
+		log.Println("[v2]", err)
+		sendInternalError(res)
+		return
+	}
+
+	err = updateContent(res, v2Request(req, t, id), t, id, post, replace)
+	if err != nil {
+		return
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+func v2DeleteHandler(res http.ResponseWriter, req *http.Request, t, id string, existing []byte) {
+	// a body is optional for DELETE requests
+	if req.Header.Get("Content-Type") != "" {
+		err := parseContentForm(req)
+		if err != nil {
+			log.Println("[v2] error:", err)
+			sendError(res, http.StatusBadRequest, errInvalidBody, "The request body must be a valid form or JSON object")
+			return
+		}
+	}
+
+	post := item.Types[t]()
+	err := json.Unmarshal(existing, post)
+	if err != nil {
+		log.Println("Error unmarshalling ", t, "=", id, err, " Hooks will be called on a zero-value.")
+	}
+
+	err = deleteContent(res, v2Request(req, t, id), t, id, post)
+	if err != nil {
+		return
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// v2Request returns a copy of the request with the type and id of the resource
+// set in its query, as they would be for the equivalent content API request
+func v2Request(req *http.Request, t, id string) *http.Request {
+	r := req.Clone(req.Context())
+
+	q := r.URL.Query()
+	q.Set("type", t)
+	if id != "" {
+		q.Set("id", id)
+	}
+	r.URL.RawQuery = q.Encode()
+
+	return r
+}
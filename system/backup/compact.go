@@ -0,0 +1,170 @@
+package backup
+
+import (
+	"os"
+	"sort"
+
+	"github.com/boltdb/bolt"
+)
+
+// compactTxMaxSize is the number of bytes copied into the destination database
+// before its write transaction is committed and a new one is started, keeping
+// memory usage bounded when compacting large databases
+const compactTxMaxSize = 64 * 1024 * 1024
+
+// BucketStats reports the number of keys and the bytes used by a top-level
+// bucket (including its nested buckets) in a bolt database
+type BucketStats struct {
+	Name  string `json:"name"`
+	Keys  int    `json:"keys"`
+	Inuse int    `json:"inuse"`
+	Alloc int    `json:"alloc"`
+}
+
+// Stats returns a report for every top-level bucket in the database, sorted by
+// the number of bytes allocated to each, largest first
+func Stats(store *bolt.DB) ([]BucketStats, error) {
+	var stats []BucketStats
+	err := store.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			s := b.Stats()
+			stats = append(stats, BucketStats{
+				Name:  string(name),
+				Keys:  s.KeyN,
+				Inuse: s.BranchInuse + s.LeafInuse,
+				Alloc: s.BranchAlloc + s.LeafAlloc,
+			})
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Alloc > stats[j].Alloc
+	})
+
+	return stats, nil
+}
+
+// Compact copies every live bucket, key and bucket sequence from the bolt
+// database file at src into a new database file at dst. Since bolt never
+// returns freed pages to the filesystem, the new file will usually be much
+// smaller than the original. The database at src must not be open elsewhere
+// in the process, as bolt holds an exclusive file lock while it is open.
+func Compact(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	from, err := bolt.Open(src, info.Mode(), &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+
+	to, err := bolt.Open(dst, info.Mode(), nil)
+	if err != nil {
+		return err
+	}
+
+	err = compact(to, from)
+	if err != nil {
+		to.Close()
+		os.Remove(dst)
+		return err
+	}
+
+	return to.Close()
+}
+
+func compact(dst, src *bolt.DB) error {
+	tx, err := dst.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// tx is replaced after every intermediate commit, so rollback whichever
+		// transaction is current if we return early with an error
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var size int
+	err = src.View(func(srcTx *bolt.Tx) error {
+		return srcTx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			return copyBucket(b, [][]byte{name}, func(keys [][]byte, k, v []byte, seq uint64) error {
+				// commit and start a new transaction if we've copied enough
+				if size+len(k)+len(v) > compactTxMaxSize {
+					err := tx.Commit()
+					if err != nil {
+						return err
+					}
+
+					tx, err = dst.Begin(true)
+					if err != nil {
+						return err
+					}
+
+					size = 0
+				}
+				size += len(k) + len(v)
+
+				// walk down to the destination bucket, creating it if needed
+				bucket, err := tx.CreateBucketIfNotExists(keys[0])
+				if err != nil {
+					return err
+				}
+
+				for _, key := range keys[1:] {
+					bucket, err = bucket.CreateBucketIfNotExists(key)
+					if err != nil {
+						return err
+					}
+				}
+
+				// a nil key marks the bucket itself, so keep its sequence to
+				// ensure NextSequence continues to hand out unused IDs
+				if k == nil {
+					return bucket.SetSequence(seq)
+				}
+
+				// keys are copied in order, so pack pages as tightly as possible
+				bucket.FillPercent = 1.0
+
+				return bucket.Put(k, v)
+			})
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	tx = nil
+
+	return err
+}
+
+func copyBucket(b *bolt.Bucket, keys [][]byte, fn func(keys [][]byte, k, v []byte, seq uint64) error) error {
+	err := fn(keys, nil, nil, b.Sequence())
+	if err != nil {
+		return err
+	}
+
+	return b.ForEach(func(k, v []byte) error {
+		// nested buckets have a nil value
+		if v == nil {
+			nested := b.Bucket(k)
+			path := append(append([][]byte{}, keys...), k)
+
+			return copyBucket(nested, path, fn)
+		}
+
+		return fn(keys, k, v, 0)
+	})
+}
@@ -0,0 +1,118 @@
+package backup
+
+import (
+	"os"
+	"sync"
+
+	"github.com/boltdb/bolt"
+)
+
+// Store is a bolt database which may be compacted while it is in use. Every
+// transaction holds a read lock on the Store while it is open, and Compact
+// holds the write lock, so the database file is only swapped once no
+// transaction is open, and transactions begun meanwhile wait until it has been.
+// A function run within a transaction must not begin another, as it would wait
+// on a compaction which is waiting on it.
+type Store struct {
+	mu   sync.RWMutex
+	db   *bolt.DB
+	path string
+	mode os.FileMode
+}
+
+// Open opens the bolt database file at path, creating it with the mode if it
+// doesn't exist
+func Open(path string, mode os.FileMode) (*Store, error) {
+	db, err := bolt.Open(path, mode, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{db: db, path: path, mode: mode}, nil
+}
+
+// View runs fn within a read-only transaction, as bolt.DB.View does
+func (s *Store) View(fn func(*bolt.Tx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.db.View(fn)
+}
+
+// Update runs fn within a read-write transaction, as bolt.DB.Update does
+func (s *Store) Update(fn func(*bolt.Tx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.db.Update(fn)
+}
+
+// Begin starts a transaction, as bolt.DB.Begin does. The returned done func
+// must be called once the transaction is committed or rolled back.
+func (s *Store) Begin(writable bool) (*bolt.Tx, func(), error) {
+	s.mu.RLock()
+
+	tx, err := s.db.Begin(writable)
+	if err != nil {
+		s.mu.RUnlock()
+		return nil, nil, err
+	}
+
+	var once sync.Once
+	return tx, func() { once.Do(s.mu.RUnlock) }, nil
+}
+
+// Path returns the path of the database file
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Close closes the database, waiting for open transactions to finish
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.db.Close()
+}
+
+// Stats returns a report for every top-level bucket in the database, as the
+// Stats func does
+func (s *Store) Stats() ([]BucketStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return Stats(s.db)
+}
+
+// Compact copies every live bucket, key and bucket sequence of the database
+// into a new file, then swaps it in for the original. Transactions begun while
+// the Store is compacted wait until it is done. The compacted database is kept
+// open as it is renamed over the original, so the Store always holds an open
+// database: the original until the rename succeeds, and the compacted one after.
+func (s *Store) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tmp := s.path + ".compact"
+
+	to, err := bolt.Open(tmp, s.mode, nil)
+	if err != nil {
+		return err
+	}
+
+	err = compact(to, s.db)
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+	if err != nil {
+		to.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	from := s.db
+	s.db = to
+
+	// the original file has been replaced, so an error closing it loses nothing
+	return from.Close()
+}
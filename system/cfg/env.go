@@ -57,3 +57,39 @@ func SearchDir() string {
 	}
 	return searchDir
 }
+
+func EncryptionKey() string {
+	return os.Getenv("KUDZU_ENCRYPTION_KEY")
+}
+
+func EncryptionKeyPrevious() string {
+	return os.Getenv("KUDZU_ENCRYPTION_KEY_PREVIOUS")
+}
+
+func EncryptionKeyFile() string {
+	return os.Getenv("KUDZU_ENCRYPTION_KEY_FILE")
+}
+
+func TenantsFile() string {
+	tenantsFile := os.Getenv("KUDZU_TENANTS_FILE")
+	if tenantsFile == "" {
+		tenantsFile = filepath.Join(DataDir(), "tenants.json")
+	}
+	return tenantsFile
+}
+
+func TenantsDir() string {
+	tenantsDir := os.Getenv("KUDZU_TENANTS_DIR")
+	if tenantsDir == "" {
+		tenantsDir = filepath.Join(DataDir(), "tenants")
+	}
+	return tenantsDir
+}
+
+func Tenant() string {
+	return os.Getenv("KUDZU_TENANT")
+}
+
+func TenantPort() string {
+	return os.Getenv("KUDZU_TENANT_PORT")
+}
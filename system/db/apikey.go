@@ -0,0 +1,117 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/kudzu-cms/kudzu/system/admin/apikey"
+
+	"github.com/boltdb/bolt"
+)
+
+// ErrNoAPIKeyExists is used for the db to report a non-existing API key
+var ErrNoAPIKeyExists = errors.New("Error. No API key exists.")
+
+// SetAPIKey saves an API key in the db
+func SetAPIKey(k *apikey.Key) error {
+	return store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__apikeys"))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		j, err := json.Marshal(k)
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(k.ID), j)
+	})
+}
+
+// APIKey gets the API key by ID from the db
+func APIKey(id string) ([]byte, error) {
+	val := &bytes.Buffer{}
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__apikeys"))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		_, err := val.Write(b.Get([]byte(id)))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if val.Len() == 0 {
+		return nil, ErrNoAPIKeyExists
+	}
+
+	return val.Bytes(), nil
+}
+
+// APIKeyAll returns all API keys from the db
+func APIKeyAll() ([][]byte, error) {
+	var keys [][]byte
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__apikeys"))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			keys = append(keys, v)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// DeleteAPIKey revokes an API key by removing it from the db
+func DeleteAPIKey(id string) error {
+	return store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__apikeys"))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		return b.Delete([]byte(id))
+	})
+}
+
+// TouchAPIKey sets the last used time of an API key, if it still exists
+func TouchAPIKey(id string, used time.Time) error {
+	return store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__apikeys"))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		v := b.Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+
+		var k apikey.Key
+		err := json.Unmarshal(v, &k)
+		if err != nil {
+			return err
+		}
+
+		k.LastUsed = used.UnixNano() / int64(time.Millisecond)
+		j, err := json.Marshal(k)
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(id), j)
+	})
+}
@@ -4,11 +4,20 @@ import (
 	"encoding/base64"
 	"fmt"
 	"net/http"
-	"strings"
+	"sync"
 	"time"
 )
 
-// CacheControl sets the default cache policy on static asset responses
+// contentVersions counts the writes to each content type, so that anything
+// derived from a type's content can tell when it is stale
+var contentVersions = struct {
+	sync.Mutex
+	versions map[string]uint64
+}{versions: make(map[string]uint64)}
+
+// CacheControl sets the default cache policy on static asset and API responses.
+// Validators such as ETag and Last-Modified are set by the handlers, since they
+// depend on each response.
 func CacheControl(next http.Handler) http.HandlerFunc {
 	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
 		cacheDisabled := ConfigCache("cache_disabled").(bool)
@@ -17,21 +26,12 @@ func CacheControl(next http.Handler) http.HandlerFunc {
 			next.ServeHTTP(res, req)
 		} else {
 			age := int64(ConfigCache("cache_max_age").(float64))
-			etag := ConfigCache("etag").(string)
 			if age == 0 {
 				age = DefaultMaxAge
 			}
 			policy := fmt.Sprintf("max-age=%d, public", age)
-			res.Header().Add("ETag", etag)
 			res.Header().Add("Cache-Control", policy)
 
-			if match := req.Header.Get("If-None-Match"); match != "" {
-				if strings.Contains(match, etag) {
-					res.WriteHeader(http.StatusNotModified)
-					return
-				}
-			}
-
 			next.ServeHTTP(res, req)
 		}
 	})
@@ -45,7 +45,8 @@ func NewEtag() string {
 	return etag
 }
 
-// InvalidateCache sets a new Etag for http responses
+// InvalidateCache sets a new Etag for http responses, which is combined with the
+// data of each API response to compute the response's ETag header
 func InvalidateCache() error {
 	err := PutConfig("etag", NewEtag())
 	if err != nil {
@@ -54,3 +55,21 @@ func InvalidateCache() error {
 
 	return nil
 }
+
+// ContentVersion returns a number which changes each time content of the type
+// is written, and again once its sorted content has been updated. A cache of
+// responses derived from the type's content should read the version before the
+// content, and discard the response once the version has changed.
+func ContentVersion(typeName string) uint64 {
+	contentVersions.Lock()
+	defer contentVersions.Unlock()
+
+	return contentVersions.versions[typeName]
+}
+
+// touchContent changes the version of the type's content
+func touchContent(typeName string) {
+	contentVersions.Lock()
+	contentVersions.versions[typeName]++
+	contentVersions.Unlock()
+}
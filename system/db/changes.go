@@ -0,0 +1,384 @@
+package db
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	// ChangeInsert is recorded when public content is created
+	ChangeInsert = "insert"
+
+	// ChangeUpdate is recorded when public content is updated or replaced
+	ChangeUpdate = "update"
+
+	// ChangeDelete is recorded when public content or an upload is deleted
+	ChangeDelete = "delete"
+
+	// ChangeApprove is recorded when pending content is approved, following the
+	// insert of the approved content into the public bucket
+	ChangeApprove = "approve"
+
+	// ChangeUpload is recorded when a file upload is stored
+	ChangeUpload = "upload"
+
+	// DefaultChangeRetention provides a 30-day retention period for the change log
+	DefaultChangeRetention = time.Hour * 24 * 30
+)
+
+// Change is an entry in the change log, describing a single write to content
+// or uploads. Seq is monotonically increasing across all changes.
+type Change struct {
+	Seq       uint64 `json:"seq"`
+	Type      string `json:"type"`
+	ID        int    `json:"id"`
+	Op        string `json:"op"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+var (
+	changeMu     = &sync.Mutex{}
+	changeNotify = make(chan struct{})
+)
+
+// appendChange adds an entry to the change log within the provided write
+// transaction, so that it is only recorded if the change itself is committed
+func appendChange(tx *bolt.Tx, typeName string, id int, op string) error {
+	b, err := tx.CreateBucketIfNotExists([]byte("__changes"))
+	if err != nil {
+		return err
+	}
+
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	c := Change{
+		Seq:       seq,
+		Type:      typeName,
+		ID:        id,
+		Op:        op,
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+	}
+
+	j, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	return b.Put(changeKey(seq), j)
+}
+
+// AppendChange adds an entry to the change log for changes which are not made
+// through SetContent, UpdateContent, DeleteContent or SetUpload, such as approvals
+func AppendChange(typeName string, id int, op string) error {
+	err := store.Update(func(tx *bolt.Tx) error {
+		return appendChange(tx, typeName, id, op)
+	})
+	if err != nil {
+		return err
+	}
+
+	notifyChange()
+
+	return nil
+}
+
+// notifyChange wakes any callers waiting on a channel from ChangeNotify. It
+// should be called after a transaction containing a change has been committed.
+func notifyChange() {
+	changeMu.Lock()
+	close(changeNotify)
+	changeNotify = make(chan struct{})
+	changeMu.Unlock()
+}
+
+// ChangeNotify returns a channel which is closed the next time a change is
+// committed to the change log
+func ChangeNotify() <-chan struct{} {
+	changeMu.Lock()
+	defer changeMu.Unlock()
+
+	return changeNotify
+}
+
+// Changes returns up to count entries from the change log with a sequence
+// number greater than since, in order. If typeName is not empty, only changes
+// to that type are included. A count of -1 returns all matching changes.
+func Changes(since uint64, count int, typeName string) ([]Change, error) {
+	changes := []Change{}
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__changes"))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		c := b.Cursor()
+		for k, v := c.Seek(changeKey(since + 1)); k != nil; k, v = c.Next() {
+			if count != -1 && len(changes) >= count {
+				break
+			}
+
+			var change Change
+			err := json.Unmarshal(v, &change)
+			if err != nil {
+				return err
+			}
+
+			if typeName != "" && change.Type != typeName {
+				continue
+			}
+
+			changes = append(changes, change)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// ContentChanges is the content of a type changed after a point in the change
+// log, read in one transaction with the sequence number of the last change
+type ContentChanges struct {
+	// Items is the current data of each item inserted or updated, or of every
+	// item if Reset is true
+	Items [][]byte
+
+	// Deletions is the delete entries in the change log for the type, in order
+	Deletions []Change
+
+	// Cursor is the sequence number of the last change the result includes
+	Cursor uint64
+
+	// Reset is true if changes after the point requested have been pruned from
+	// the change log, or it is from the future, so every item was read instead
+	Reset bool
+}
+
+// ContentChangedSince returns the content of the type provided which was
+// changed after the change log sequence number since. A since of 0 returns
+// every item. Content and the change log are written in the same transaction,
+// so no change committed after the returned cursor is included, and none before
+// it is missed.
+func ContentChangedSince(typeName string, since uint64) (*ContentChanges, error) {
+	changes := &ContentChanges{Items: [][]byte{}, Deletions: []Change{}}
+	err := store.View(func(tx *bolt.Tx) error {
+		cl := tx.Bucket([]byte("__changes"))
+		if cl == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		changes.Cursor = cl.Sequence()
+
+		c := cl.Cursor()
+		if since > 0 {
+			first, _ := c.First()
+			pruned := changes.Cursor > since && (first == nil || binary.BigEndian.Uint64(first) > since+1)
+			if pruned || since > changes.Cursor {
+				changes.Reset = true
+				since = 0
+			}
+		}
+
+		b := tx.Bucket([]byte(typeName))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		if since == 0 {
+			return b.ForEach(func(k, v []byte) error {
+				j, err := decrypt(typeName, append([]byte(nil), v...))
+				if err != nil {
+					return err
+				}
+
+				changes.Items = append(changes.Items, j)
+				return nil
+			})
+		}
+
+		// the latest change to each item decides whether it was written or
+		// deleted, and items are returned in the order of those changes
+		latest := make(map[int]Change)
+		var order []int
+		for k, v := c.Seek(changeKey(since + 1)); k != nil; k, v = c.Next() {
+			var change Change
+			err := json.Unmarshal(v, &change)
+			if err != nil {
+				return err
+			}
+
+			if change.Type != typeName {
+				continue
+			}
+
+			if _, ok := latest[change.ID]; !ok {
+				order = append(order, change.ID)
+			}
+			latest[change.ID] = change
+		}
+
+		for _, id := range order {
+			change := latest[id]
+			if change.Op == ChangeDelete {
+				changes.Deletions = append(changes.Deletions, change)
+				continue
+			}
+
+			v := b.Get([]byte(strconv.Itoa(id)))
+			if v == nil {
+				continue
+			}
+
+			j, err := decrypt(typeName, append([]byte(nil), v...))
+			if err != nil {
+				return err
+			}
+
+			changes.Items = append(changes.Items, j)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// LastChange returns the sequence number of the most recent change, or 0 if no
+// changes have been recorded
+func LastChange() (uint64, error) {
+	var seq uint64
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__changes"))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		seq = b.Sequence()
+		return nil
+	})
+
+	return seq, err
+}
+
+// ChangeAt returns the sequence number of the last change recorded at or before
+// the time provided, in milliseconds since the Unix epoch. It returns false if
+// the change log has no entry that old, as it was empty or has been pruned.
+func ChangeAt(ms int64) (uint64, bool, error) {
+	var seq uint64
+	var found bool
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__changes"))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		// entries are in sequence order, so search back from the latest
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var change Change
+			err := json.Unmarshal(v, &change)
+			if err != nil {
+				return err
+			}
+
+			if change.Timestamp <= ms {
+				seq, found = change.Seq, true
+				break
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+
+	return seq, found, nil
+}
+
+// PruneChanges removes all entries from the change log recorded before the
+// time provided, and returns the number of entries removed
+func PruneChanges(before time.Time) (int, error) {
+	var pruned int
+	threshold := before.UnixNano() / int64(time.Millisecond)
+
+	err := store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__changes"))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		// entries are in sequence order, so stop at the first one to keep
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.First() {
+			var change Change
+			err := json.Unmarshal(v, &change)
+			if err != nil {
+				return err
+			}
+
+			if change.Timestamp >= threshold {
+				break
+			}
+
+			err = c.Delete()
+			if err != nil {
+				return err
+			}
+
+			pruned++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return pruned, nil
+}
+
+// ChangeRetention returns the configured change log retention period. Changes
+// older than this may have been pruned from the change log.
+func ChangeRetention() time.Duration {
+	days, ok := ConfigCache("change_retention_days").(float64)
+	if !ok || days <= 0 {
+		return DefaultChangeRetention
+	}
+
+	return time.Hour * 24 * time.Duration(days)
+}
+
+// serveChangeRetention periodically removes entries from the change log which
+// are older than the configured retention period
+func serveChangeRetention() {
+	ticker := time.NewTicker(time.Hour)
+
+	for range ticker.C {
+		_, err := PruneChanges(time.Now().Add(-ChangeRetention()))
+		if err != nil {
+			log.Println("Error pruning change log:", err)
+		}
+	}
+}
+
+func changeKey(seq uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, seq)
+	return b
+}
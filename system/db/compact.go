@@ -0,0 +1,29 @@
+package db
+
+import (
+	"os"
+
+	"github.com/kudzu-cms/kudzu/system/backup"
+)
+
+// Compact rewrites system.db into a fresh file containing only live data and
+// atomically swaps it in for the original. Requests made during compaction
+// wait for it to finish.
+func Compact() error {
+	return store.Compact()
+}
+
+// Stats returns the number of keys and bytes used by each bucket in system.db
+func Stats() ([]backup.BucketStats, error) {
+	return store.Stats()
+}
+
+// Size returns the size in bytes of the system.db file on disk
+func Size() (int64, error) {
+	info, err := os.Stat(store.Path())
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Size(), nil
+}
@@ -72,6 +72,27 @@ func UpdateContent(target string, data url.Values) (int, error) {
 // if existingContent is non-nil, we merge field values. empty/missing fields are ignored.
 // if existingContent is nil, we replace field values. empty/missing fields are reset.
 func update(ns, id string, data url.Values, existingContent *[]byte) (int, error) {
+	var cid int
+	var after func()
+	err := store.Update(func(tx *bolt.Tx) error {
+		var err error
+		cid, after, err = updateTx(tx, ns, id, data, existingContent)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	after()
+
+	return cid, nil
+}
+
+// updateTx stores the values as the content of type ns with the id within tx,
+// merging them with existingContent as update does. It returns a func to be
+// called once tx is committed, which notifies changes and updates the sorted
+// content and search index.
+func updateTx(tx *bolt.Tx, ns, id string, data url.Values, existingContent *[]byte) (int, func(), error) {
 	var specifier string // i.e. __pending, __sorted, etc.
 	if strings.Contains(ns, "__") {
 		spec := strings.Split(ns, "__")
@@ -81,59 +102,63 @@ func update(ns, id string, data url.Values, existingContent *[]byte) (int, error
 
 	cid, err := strconv.Atoi(id)
 	if err != nil {
-		return 0, err
+		return 0, nil, err
 	}
 
 	var j []byte
 	if existingContent == nil {
-		j, err = postToJSON(ns, data)
+		// the stored id must match the item's key, since encrypted fields are
+		// bound to it
+		data.Set("id", id)
+		j, err = postToJSON(tx, ns, data)
 		if err != nil {
-			return 0, err
+			return 0, nil, err
 		}
 	} else {
 		j, err = mergeData(ns, data, *existingContent)
 		if err != nil {
-			return 0, err
+			return 0, nil, err
 		}
 	}
 
-	err = store.Update(func(tx *bolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists([]byte(ns + specifier))
-		if err != nil {
-			return err
-		}
-
-		err = b.Put([]byte(fmt.Sprintf("%d", cid)), j)
-		if err != nil {
-			return err
-		}
-
-		return nil
-	})
+	enc, err := encrypt(ns+specifier, j)
 	if err != nil {
-		return 0, nil
+		return 0, nil, err
 	}
 
-	if specifier == "" {
-		go SortContent(ns)
+	b, err := tx.CreateBucketIfNotExists([]byte(ns + specifier))
+	if err != nil {
+		return 0, nil, err
 	}
 
-	// update changes data, so invalidate client caching
-	err = InvalidateCache()
+	err = b.Put([]byte(fmt.Sprintf("%d", cid)), enc)
 	if err != nil {
-		return 0, err
+		return 0, nil, err
 	}
 
-	go func() {
-		// update data in search index
-		target := fmt.Sprintf("%s:%s", ns, id)
-		err = search.UpdateIndex(target, j)
+	if specifier == "" {
+		err = appendChange(tx, ns, cid, ChangeUpdate)
 		if err != nil {
-			log.Println("[search] UpdateIndex Error:", err)
+			return 0, nil, err
 		}
-	}()
+	}
 
-	return cid, nil
+	return cid, func() {
+		if specifier == "" {
+			touchContent(ns)
+			notifyChange()
+			go SortContent(ns)
+		}
+
+		go func() {
+			// update data in search index
+			target := fmt.Sprintf("%s:%s", ns, id)
+			err := search.UpdateIndex(target, j)
+			if err != nil {
+				log.Println("[search] UpdateIndex Error:", err)
+			}
+		}()
+	}, nil
 }
 
 func mergeData(ns string, data url.Values, existingContent []byte) ([]byte, error) {
@@ -174,6 +199,25 @@ func mergeData(ns string, data url.Values, existingContent []byte) ([]byte, erro
 
 func insert(ns string, data url.Values) (int, error) {
 	var effectedID int
+	var after func()
+	err := store.Update(func(tx *bolt.Tx) error {
+		var err error
+		effectedID, after, err = insertTx(tx, ns, data)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	after()
+
+	return effectedID, nil
+}
+
+// insertTx stores the values as new content of type ns within tx. It returns
+// the new content's ID and a func to be called once tx is committed, which
+// notifies changes and updates the sorted content and search index.
+func insertTx(tx *bolt.Tx, ns string, data url.Values) (int, func(), error) {
 	var specifier string // i.e. __pending, __sorted, etc.
 	if strings.Contains(ns, "__") {
 		spec := strings.Split(ns, "__")
@@ -181,102 +225,118 @@ func insert(ns string, data url.Values) (int, error) {
 		specifier = "__" + spec[1]
 	}
 
-	var j []byte
-	var cid string
-	err := store.Update(func(tx *bolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists([]byte(ns + specifier))
-		if err != nil {
-			return err
-		}
+	b, err := tx.CreateBucketIfNotExists([]byte(ns + specifier))
+	if err != nil {
+		return 0, nil, err
+	}
 
-		// get the next available ID and convert to string
-		// also set effectedID to int of ID
-		id, err := b.NextSequence()
-		if err != nil {
-			return err
-		}
-		cid = strconv.FormatUint(id, 10)
-		effectedID, err = strconv.Atoi(cid)
-		if err != nil {
-			return err
-		}
-		data.Set("id", cid)
+	// get the next available ID and convert to string
+	// also set effectedID to int of ID
+	id, err := b.NextSequence()
+	if err != nil {
+		return 0, nil, err
+	}
+	cid := strconv.FormatUint(id, 10)
+	effectedID, err := strconv.Atoi(cid)
+	if err != nil {
+		return 0, nil, err
+	}
+	data.Set("id", cid)
 
-		// add UUID to data for use in embedded Item
-		uid, err := uuid.NewV4()
-		if err != nil {
-			return err
-		}
+	// add UUID to data for use in embedded Item
+	uid, err := uuid.NewV4()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	data.Set("uuid", uid.String())
+
+	// if type has a specifier, add it to data for downstream processing
+	if specifier != "" {
+		data.Set("__specifier", specifier)
+	}
+
+	j, err := postToJSON(tx, ns, data)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	enc, err := encrypt(ns+specifier, j)
+	if err != nil {
+		return 0, nil, err
+	}
 
-		data.Set("uuid", uid.String())
+	err = b.Put([]byte(cid), enc)
+	if err != nil {
+		return 0, nil, err
+	}
 
-		// if type has a specifier, add it to data for downstream processing
-		if specifier != "" {
-			data.Set("__specifier", specifier)
+	// store the slug,type:id in contentIndex if public content
+	if specifier == "" {
+		ci := tx.Bucket([]byte("__contentIndex"))
+		if ci == nil {
+			return 0, nil, bolt.ErrBucketNotFound
 		}
 
-		j, err = postToJSON(ns, data)
+		k := []byte(data.Get("slug"))
+		v := []byte(fmt.Sprintf("%s:%d", ns, effectedID))
+		err := ci.Put(k, v)
 		if err != nil {
-			return err
+			return 0, nil, err
 		}
 
-		err = b.Put([]byte(cid), j)
+		err = appendChange(tx, ns, effectedID, ChangeInsert)
 		if err != nil {
-			return err
+			return 0, nil, err
 		}
+	}
 
-		// store the slug,type:id in contentIndex if public content
+	return effectedID, func() {
 		if specifier == "" {
-			ci := tx.Bucket([]byte("__contentIndex"))
-			if ci == nil {
-				return bolt.ErrBucketNotFound
-			}
+			touchContent(ns)
+			notifyChange()
+			go SortContent(ns)
+		}
 
-			k := []byte(data.Get("slug"))
-			v := []byte(fmt.Sprintf("%s:%d", ns, effectedID))
-			err := ci.Put(k, v)
+		go func() {
+			// add data to search index
+			target := fmt.Sprintf("%s:%s", ns, cid)
+			err := search.UpdateIndex(target, j)
 			if err != nil {
-				return err
+				log.Println("[search] UpdateIndex Error:", err)
 			}
-		}
+		}()
+	}, nil
+}
 
-		return nil
+// DeleteContent removes an item from the database. Deleting a non-existent item
+// will return a nil error.
+func DeleteContent(target string) error {
+	var after func()
+	err := store.Update(func(tx *bolt.Tx) error {
+		var err error
+		after, err = deleteTx(tx, target)
+		return err
 	})
 	if err != nil {
-		return 0, err
-	}
-
-	if specifier == "" {
-		go SortContent(ns)
-	}
-
-	// insert changes data, so invalidate client caching
-	err = InvalidateCache()
-	if err != nil {
-		return 0, err
+		return err
 	}
 
-	go func() {
-		// add data to search index
-		target := fmt.Sprintf("%s:%s", ns, cid)
-		err = search.UpdateIndex(target, j)
-		if err != nil {
-			log.Println("[search] UpdateIndex Error:", err)
-		}
-	}()
+	after()
 
-	return effectedID, nil
+	return nil
 }
 
-// DeleteContent removes an item from the database. Deleting a non-existent item
-// will return a nil error.
-func DeleteContent(target string) error {
+// deleteTx removes an item from the database within tx. It returns a func to be
+// called once tx is committed, which notifies changes and updates the sorted
+// content and search index.
+func deleteTx(tx *bolt.Tx, target string) (func(), error) {
 	t := strings.Split(target, ":")
 	ns, id := t[0], t[1]
 
-	b, err := Content(target)
+	b, err := contentTx(tx, target)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// get content slug to delete from __contentIndex if it exists
@@ -285,91 +345,105 @@ func DeleteContent(target string) error {
 	var itm item.Item
 	err = json.Unmarshal(b, &itm)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = store.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(ns))
-		if b == nil {
-			return bolt.ErrBucketNotFound
+	bk := tx.Bucket([]byte(ns))
+	if bk == nil {
+		return nil, bolt.ErrBucketNotFound
+	}
+
+	err = bk.Delete([]byte(id))
+	if err != nil {
+		return nil, err
+	}
+
+	// if content has a slug, also delete it from __contentIndex
+	if itm.Slug != "" {
+		ci := tx.Bucket([]byte("__contentIndex"))
+		if ci == nil {
+			return nil, bolt.ErrBucketNotFound
 		}
 
-		err := b.Delete([]byte(id))
+		err := ci.Delete([]byte(itm.Slug))
 		if err != nil {
-			return err
+			return nil, err
 		}
+	}
 
-		// if content has a slug, also delete it from __contentIndex
-		if itm.Slug != "" {
-			ci := tx.Bucket([]byte("__contentIndex"))
-			if ci == nil {
-				return bolt.ErrBucketNotFound
-			}
-
-			err := ci.Delete([]byte(itm.Slug))
-			if err != nil {
-				return err
-			}
+	// only changes to public content are recorded in the change log
+	if !strings.Contains(ns, "__") {
+		cid, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, err
 		}
 
-		return nil
-	})
-	if err != nil {
-		return err
-	}
-
-	// delete changes data, so invalidate client caching
-	err = InvalidateCache()
-	if err != nil {
-		return err
+		err = appendChange(tx, ns, cid, ChangeDelete)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	go func() {
-		// delete indexed data from search index
+	return func() {
 		if !strings.Contains(ns, "__") {
-			target = fmt.Sprintf("%s:%s", ns, id)
-			err = search.DeleteIndex(target)
-			if err != nil {
-				log.Println("[search] DeleteIndex Error:", err)
-			}
+			touchContent(ns)
+			notifyChange()
 		}
-	}()
 
-	// exception to typical "run in goroutine" pattern:
-	// we want to have an updated admin view as soon as this is deleted, so
-	// in some cases, the delete and redirect is faster than the sort,
-	// thus still showing a deleted post in the admin view.
-	SortContent(ns)
-
-	return nil
+		go func() {
+			// delete indexed data from search index
+			if !strings.Contains(ns, "__") {
+				target := fmt.Sprintf("%s:%s", ns, id)
+				err := search.DeleteIndex(target)
+				if err != nil {
+					log.Println("[search] DeleteIndex Error:", err)
+				}
+			}
+		}()
+
+		// exception to typical "run in goroutine" pattern:
+		// we want to have an updated admin view as soon as this is deleted, so
+		// in some cases, the delete and redirect is faster than the sort,
+		// thus still showing a deleted post in the admin view.
+		SortContent(ns)
+	}, nil
 }
 
 // Content retrives one item from the database. Non-existent values will return an empty []byte
 // The `target` argument is a string made up of namespace:id (string:int)
 func Content(target string) ([]byte, error) {
+	var val []byte
+	err := store.View(func(tx *bolt.Tx) error {
+		var err error
+		val, err = contentTx(tx, target)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return val, nil
+}
+
+// contentTx retrieves one item from the database within tx, as Content does
+func contentTx(tx *bolt.Tx, target string) ([]byte, error) {
 	t := strings.Split(target, ":")
 	ns, id := t[0], t[1]
 
-	val := &bytes.Buffer{}
-	err := store.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(ns))
-		if b == nil {
-			return bolt.ErrBucketNotFound
-		}
-
-		_, err := val.Write(b.Get([]byte(id)))
-		if err != nil {
-			log.Println(err)
-			return err
-		}
+	b := tx.Bucket([]byte(ns))
+	if b == nil {
+		return nil, bolt.ErrBucketNotFound
+	}
 
-		return nil
-	})
+	// copy the value, since it is only valid for the life of the transaction
+	val := &bytes.Buffer{}
+	_, err := val.Write(b.Get([]byte(id)))
 	if err != nil {
+		log.Println(err)
 		return nil, err
 	}
 
-	return val.Bytes(), nil
+	return decrypt(ns, val.Bytes())
 }
 
 // ContentMulti returns a set of content based on the the targets / identifiers
@@ -427,7 +501,41 @@ func ContentBySlug(slug string) (string, []byte, error) {
 		return t, nil, err
 	}
 
-	return t, val.Bytes(), nil
+	j, err := decrypt(t, val.Bytes())
+	if err != nil {
+		return t, nil, err
+	}
+
+	return t, j, nil
+}
+
+// ContentIndex calls fn with the slug, type and data of each item in the content
+// index, which holds every item of public content with a slug, in order of slug.
+// Uploads are left out.
+func ContentIndex(fn func(slug, t string, data []byte) error) error {
+	return store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__contentIndex"))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			tid := strings.Split(string(v), ":")
+			if len(tid) < 2 || strings.HasPrefix(tid[0], "__") {
+				return nil
+			}
+
+			j, err := contentTx(tx, string(v))
+			if err == bolt.ErrBucketNotFound || (err == nil && len(j) == 0) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			return fn(string(k), tid[0], j)
+		})
+	})
 }
 
 // ContentAll retrives all items from the database within the provided namespace
@@ -451,7 +559,7 @@ func ContentAll(namespace string) [][]byte {
 		return nil
 	})
 
-	return posts
+	return decryptAll(namespace, posts)
 }
 
 // QueryOptions holds options for a query
@@ -567,7 +675,7 @@ func Query(namespace string, opts QueryOptions) (int, [][]byte) {
 		return nil
 	})
 
-	return total, posts
+	return total, decryptAll(namespace, posts)
 }
 
 var sortContentCalls = make(map[string]time.Time)
@@ -660,6 +768,12 @@ func SortContent(namespace string) {
 			return
 		}
 
+		j, err = encrypt(namespace+"__sorted", j)
+		if err != nil {
+			log.Println("Error encrypting post in SortContent:", err)
+			return
+		}
+
 		bb = append(bb, j)
 	}
 
@@ -691,6 +805,7 @@ func SortContent(namespace string) {
 		log.Println("Error while updating db with sorted", namespace, err)
 	}
 
+	touchContent(namespace)
 }
 
 type sortableContent []item.Sortable
@@ -707,7 +822,9 @@ func (s sortableContent) Swap(i, j int) {
 	s[i], s[j] = s[j], s[i]
 }
 
-func postToJSON(ns string, data url.Values) ([]byte, error) {
+// postToJSON decodes the values into the content type ns and returns it as JSON,
+// with a slug which isn't in use, including by content added within tx
+func postToJSON(tx *bolt.Tx, ns string, data url.Values) ([]byte, error) {
 	// find the content type and decode values into it
 	t, ok := item.Types[ns]
 	if !ok {
@@ -723,6 +840,12 @@ func postToJSON(ns string, data url.Values) ([]byte, error) {
 		if strings.Contains(k, ".") {
 			fo := strings.Split(k, ".")
 
+			// only fieldX.N names a value of a multi-value field, others such as
+			// fieldX.key or fieldX.N.key are paths into nested structs
+			if _, err := strconv.Atoi(fo[1]); len(fo) != 2 || err != nil {
+				continue
+			}
+
 			// put the order and the field value into map
 			field := string(fo[0])
 			order := string(fo[1])
@@ -778,7 +901,7 @@ func postToJSON(ns string, data url.Values) ([]byte, error) {
 			return nil, err
 		}
 
-		slug, err = checkSlugForDuplicate(slug)
+		slug, err = checkSlugForDuplicateTx(tx, slug)
 		if err != nil {
 			return nil, err
 		}
@@ -797,27 +920,10 @@ func postToJSON(ns string, data url.Values) ([]byte, error) {
 }
 
 func checkSlugForDuplicate(slug string) (string, error) {
-	// check for existing slug in __contentIndex
 	err := store.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("__contentIndex"))
-		if b == nil {
-			return bolt.ErrBucketNotFound
-		}
-		original := slug
-		exists := true
-		i := 0
-		for exists {
-			s := b.Get([]byte(slug))
-			if s == nil {
-				exists = false
-				return nil
-			}
-
-			i++
-			slug = fmt.Sprintf("%s-%d", original, i)
-		}
-
-		return nil
+		var err error
+		slug, err = checkSlugForDuplicateTx(tx, slug)
+		return err
 	})
 	if err != nil {
 		return "", err
@@ -825,3 +931,20 @@ func checkSlugForDuplicate(slug string) (string, error) {
 
 	return slug, nil
 }
+
+// checkSlugForDuplicateTx returns the slug, or the slug with the lowest numbered
+// suffix which isn't in use, as seen within tx
+func checkSlugForDuplicateTx(tx *bolt.Tx, slug string) (string, error) {
+	// check for existing slug in __contentIndex
+	b := tx.Bucket([]byte("__contentIndex"))
+	if b == nil {
+		return "", bolt.ErrBucketNotFound
+	}
+
+	original := slug
+	for i := 1; b.Get([]byte(slug)) != nil; i++ {
+		slug = fmt.Sprintf("%s-%d", original, i)
+	}
+
+	return slug, nil
+}
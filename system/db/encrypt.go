@@ -0,0 +1,303 @@
+package db
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/kudzu-cms/kudzu/system/cfg"
+	"github.com/kudzu-cms/kudzu/system/item"
+
+	"github.com/boltdb/bolt"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// encryptedPrefix marks a field value as ciphertext, and is followed by the ID of
+// the key used to encrypt it and the base64 encoded nonce and sealed value
+const encryptedPrefix = "kudzu:enc:"
+
+var (
+	// ErrNoEncryptionKey is returned when content of an item.Encryptable type is
+	// stored or read without an encryption key configured
+	ErrNoEncryptionKey = errors.New("No encryption key configured for type with encrypted fields")
+
+	// ErrUnknownEncryptionKey is returned when a field was encrypted with a key
+	// which is neither the current nor a previous encryption key
+	ErrUnknownEncryptionKey = errors.New("Field encrypted with unknown key")
+
+	// encryptionKeys holds the current key first, followed by any previous keys
+	// which are only used to decrypt values not yet rotated to the current key
+	encryptionKeys []encryptionKey
+)
+
+type encryptionKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// loadEncryptionKeys reads the current and previous encryption keys from the
+// KUDZU_ENCRYPTION_KEY and KUDZU_ENCRYPTION_KEY_PREVIOUS (comma separated)
+// environment variables, or the file at KUDZU_ENCRYPTION_KEY_FILE, which has one
+// key per line with the current key first. Keys are base64 encoded 32 byte values.
+func loadEncryptionKeys() error {
+	var encoded []string
+	if path := cfg.EncryptionKeyFile(); path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		encoded = strings.Split(string(b), "\n")
+	} else {
+		encoded = append([]string{cfg.EncryptionKey()}, strings.Split(cfg.EncryptionKeyPrevious(), ",")...)
+	}
+
+	var keys []encryptionKey
+	for _, e := range encoded {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+
+		raw, err := base64.StdEncoding.DecodeString(e)
+		if err != nil {
+			return fmt.Errorf("Invalid encryption key, must be base64 encoded: %s", err)
+		}
+
+		if len(raw) != 32 {
+			return fmt.Errorf("Invalid encryption key, must be 32 bytes, got %d", len(raw))
+		}
+
+		block, err := aes.NewCipher(raw)
+		if err != nil {
+			return err
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(raw)
+		keys = append(keys, encryptionKey{
+			id:   hex.EncodeToString(sum[:4]),
+			aead: aead,
+		})
+	}
+
+	encryptionKeys = keys
+
+	return nil
+}
+
+// encryptedFields returns the fields to encrypt for the type stored in the
+// namespace provided, ignoring any specifier such as __pending or __sorted
+func encryptedFields(namespace string) []string {
+	ns := strings.Split(namespace, "__")[0]
+	it, ok := item.Types[ns]
+	if !ok {
+		return nil
+	}
+
+	e, ok := it().(item.Encryptable)
+	if !ok {
+		return nil
+	}
+
+	return e.EncryptFields()
+}
+
+// encryptionAAD returns the additional data a field value is sealed with, which
+// binds the ciphertext to the namespace, item ID and field it was stored in so
+// it can't be moved to another item or field and still decrypt
+func encryptionAAD(namespace string, data []byte, field string) []byte {
+	id := gjson.GetBytes(data, "id").String()
+	return []byte(namespace + ":" + id + ":" + field)
+}
+
+// encrypt replaces the values of an item.Encryptable type's encrypted fields in
+// the json data provided with ciphertext, using the current encryption key. The
+// namespace must be the bucket the data is stored in, including any specifier.
+func encrypt(namespace string, data []byte) ([]byte, error) {
+	fields := encryptedFields(namespace)
+	if len(fields) == 0 {
+		return data, nil
+	}
+
+	if len(encryptionKeys) == 0 {
+		return nil, ErrNoEncryptionKey
+	}
+	key := encryptionKeys[0]
+
+	for _, field := range fields {
+		v := gjson.GetBytes(data, field)
+		if !v.Exists() || v.Type == gjson.Null {
+			continue
+		}
+
+		// never encrypt a value twice, values encrypted with a previous key are
+		// only re-encrypted by RotateEncryptionKey once decrypted
+		if v.Type == gjson.String && strings.HasPrefix(v.Str, encryptedPrefix) {
+			continue
+		}
+
+		nonce := make([]byte, key.aead.NonceSize())
+		_, err := rand.Read(nonce)
+		if err != nil {
+			return nil, err
+		}
+
+		// seal the raw json of the value so any field type can be encrypted
+		sealed := key.aead.Seal(nonce, nonce, []byte(v.Raw), encryptionAAD(namespace, data, field))
+		enc := encryptedPrefix + key.id + ":" + base64.StdEncoding.EncodeToString(sealed)
+
+		data, err = sjson.SetBytes(data, field, enc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+// decrypt replaces any ciphertext values of an item.Encryptable type's encrypted
+// fields in the json data provided with their original values. The namespace
+// must be the bucket the data was read from, as given to encrypt.
+func decrypt(namespace string, data []byte) ([]byte, error) {
+	fields := encryptedFields(namespace)
+	if len(fields) == 0 || len(data) == 0 {
+		return data, nil
+	}
+
+	for _, field := range fields {
+		v := gjson.GetBytes(data, field)
+		if v.Type != gjson.String || !strings.HasPrefix(v.Str, encryptedPrefix) {
+			continue
+		}
+
+		if len(encryptionKeys) == 0 {
+			return nil, ErrNoEncryptionKey
+		}
+
+		parts := strings.SplitN(strings.TrimPrefix(v.Str, encryptedPrefix), ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Malformed encrypted value in field: %s", field)
+		}
+
+		var aead cipher.AEAD
+		for _, key := range encryptionKeys {
+			if key.id == parts[0] {
+				aead = key.aead
+				break
+			}
+		}
+		if aead == nil {
+			return nil, ErrUnknownEncryptionKey
+		}
+
+		sealed, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, err
+		}
+
+		if len(sealed) < aead.NonceSize() {
+			return nil, fmt.Errorf("Malformed encrypted value in field: %s", field)
+		}
+
+		nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		raw, err := aead.Open(nil, nonce, sealed, encryptionAAD(namespace, data, field))
+		if err != nil {
+			return nil, err
+		}
+
+		data, err = sjson.SetRawBytes(data, field, raw)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+// decryptAll decrypts each value in place, logging and leaving a value as it was
+// stored if it cannot be decrypted
+func decryptAll(namespace string, values [][]byte) [][]byte {
+	for i := range values {
+		v, err := decrypt(namespace, values[i])
+		if err != nil {
+			log.Println("Error decrypting content in", namespace, ":", err)
+			continue
+		}
+
+		values[i] = v
+	}
+
+	return values
+}
+
+// RotateEncryptionKey re-encrypts the encrypted fields of all stored content
+// with the current encryption key. Values encrypted with a previous key, or
+// stored before a type implemented item.Encryptable, are rewritten so previous
+// keys can be retired once rotation has completed.
+func RotateEncryptionKey() (int, error) {
+	if len(encryptionKeys) == 0 {
+		return 0, ErrNoEncryptionKey
+	}
+
+	var rotated int
+	err := store.Update(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			ns := string(name)
+			if len(encryptedFields(ns)) == 0 {
+				return nil
+			}
+
+			updated := make(map[string][]byte)
+			err := b.ForEach(func(k, v []byte) error {
+				j, err := decrypt(ns, v)
+				if err != nil {
+					return fmt.Errorf("%s:%s %s", ns, k, err)
+				}
+
+				j, err = encrypt(ns, j)
+				if err != nil {
+					return err
+				}
+
+				if string(j) != string(v) {
+					updated[string(k)] = j
+				}
+
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			// keys can't be modified while iterating over the bucket
+			for k, v := range updated {
+				err := b.Put([]byte(k), v)
+				if err != nil {
+					return err
+				}
+			}
+
+			rotated += len(updated)
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return rotated, nil
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"path/filepath"
 
+	"github.com/kudzu-cms/kudzu/system/backup"
 	"github.com/kudzu-cms/kudzu/system/cfg"
 	"github.com/kudzu-cms/kudzu/system/item"
 	"github.com/kudzu-cms/kudzu/system/search"
@@ -16,19 +17,23 @@ import (
 )
 
 var (
-	store *bolt.DB
+	store *backup.Store
 
 	buckets = []string{
 		"__config", "__users",
 		"__addons", "__uploads",
-		"__contentIndex",
+		"__contentIndex", "__changes",
+		"__apikeys", "__webhooks", "__webhookDeliveries",
+		"__webhookDue",
 	}
 
 	bucketsToAdd []string
 )
 
-// Store provides access to the underlying *bolt.DB store
-func Store() *bolt.DB {
+// Store provides access to the underlying store. Its View and Update methods
+// are used as those of a *bolt.DB are, and wait while system.db is compacted,
+// which replaces the *bolt.DB it holds.
+func Store() *backup.Store {
 	return store
 }
 
@@ -47,9 +52,13 @@ func Init() {
 		return
 	}
 
-	var err error
+	err := loadEncryptionKeys()
+	if err != nil {
+		log.Fatalln("Failed to load encryption keys.", err)
+	}
+
 	systemDb := filepath.Join(cfg.DataDir(), "system.db")
-	store, err = bolt.Open(systemDb, 0666, nil)
+	store, err = backup.Open(systemDb, 0666)
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -100,6 +109,8 @@ func Init() {
 	if err != nil {
 		log.Fatalln("Failed to invalidate cache.", err)
 	}
+
+	go serveChangeRetention()
 }
 
 // AddBucket adds a bucket to be created if it doesn't already exist
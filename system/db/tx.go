@@ -0,0 +1,115 @@
+package db
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+// Tx groups content changes so they are committed together, or not at all. A
+// Tx holds the only write transaction of the db until it is committed or rolled
+// back, so nothing else may write to the db from the goroutine using it.
+type Tx struct {
+	tx    *bolt.Tx
+	done  func()
+	after []func()
+}
+
+// Begin starts a Tx, which must be committed or rolled back
+func Begin() (*Tx, error) {
+	tx, done, err := store.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tx{tx: tx, done: done}, nil
+}
+
+// Commit saves the changes made within the Tx, then notifies changes and
+// updates the sorted content and search index as each change would alone
+func (t *Tx) Commit() error {
+	err := t.tx.Commit()
+	t.done()
+	if err != nil {
+		return err
+	}
+
+	for _, fn := range t.after {
+		fn()
+	}
+
+	return nil
+}
+
+// Rollback discards the changes made within the Tx
+func (t *Tx) Rollback() error {
+	defer t.done()
+
+	return t.tx.Rollback()
+}
+
+// SetContent inserts/replaces values within the Tx, as SetContent does
+func (t *Tx) SetContent(target string, data url.Values) (int, error) {
+	tt := strings.Split(target, ":")
+	ns, id := tt[0], tt[1]
+
+	var cid int
+	var after func()
+	var err error
+	if id == "-1" {
+		cid, after, err = insertTx(t.tx, ns, data)
+	} else {
+		cid, after, err = updateTx(t.tx, ns, id, data, nil)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	t.after = append(t.after, after)
+
+	return cid, nil
+}
+
+// UpdateContent updates/merges values within the Tx, as UpdateContent does
+func (t *Tx) UpdateContent(target string, data url.Values) (int, error) {
+	tt := strings.Split(target, ":")
+	ns, id := tt[0], tt[1]
+
+	if !IsValidID(id) {
+		return 0, fmt.Errorf("Invalid ID in target for UpdateContent: %s", target)
+	}
+
+	existingContent, err := contentTx(t.tx, target)
+	if err != nil {
+		return 0, err
+	}
+
+	cid, after, err := updateTx(t.tx, ns, id, data, &existingContent)
+	if err != nil {
+		return 0, err
+	}
+
+	t.after = append(t.after, after)
+
+	return cid, nil
+}
+
+// DeleteContent removes an item within the Tx, as DeleteContent does
+func (t *Tx) DeleteContent(target string) error {
+	after, err := deleteTx(t.tx, target)
+	if err != nil {
+		return err
+	}
+
+	t.after = append(t.after, after)
+
+	return nil
+}
+
+// Content retrieves one item, including changes made within the Tx, as Content
+// does
+func (t *Tx) Content(target string) ([]byte, error) {
+	return contentTx(t.tx, target)
+}
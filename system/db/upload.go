@@ -117,12 +117,14 @@ func SetUpload(target string, data url.Values) (int, error) {
 			return err
 		}
 
-		return nil
+		return appendChange(tx, "__uploads", int(id), ChangeUpload)
 	})
 	if err != nil {
 		return 0, err
 	}
 
+	notifyChange()
+
 	return int(id), nil
 }
 
@@ -155,7 +157,7 @@ func Upload(target string) ([]byte, error) {
 
 // UploadBySlug returns the value for an upload by its slug
 func UploadBySlug(slug string) ([]byte, error) {
-	val := &bytes.Buffer{}
+	var target string
 	// get target from __contentIndex or return nil if not exists
 	err := store.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("__contentIndex"))
@@ -168,17 +170,15 @@ func UploadBySlug(slug string) ([]byte, error) {
 			return fmt.Errorf("no value for key '%s' in __contentIndex", slug)
 		}
 
-		j, err := Upload(string(v))
-		if err != nil {
-			return err
-		}
-
-		_, err = val.Write(j)
+		target = string(v)
 
-		return err
+		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
 
-	return val.Bytes(), err
+	return Upload(target)
 }
 
 // UploadAll returns a [][]byte containing all upload data from the system
@@ -218,14 +218,26 @@ func DeleteUpload(target string) error {
 		return err
 	}
 
-	return store.Update(func(tx *bolt.Tx) error {
+	err = store.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(parts[0]))
 		if b == nil {
 			return bolt.ErrBucketNotFound
 		}
 
-		return b.Delete(id)
+		err := b.Delete(id)
+		if err != nil {
+			return err
+		}
+
+		return appendChange(tx, "__uploads", int(binary.BigEndian.Uint64(id)), ChangeDelete)
 	})
+	if err != nil {
+		return err
+	}
+
+	notifyChange()
+
+	return nil
 }
 
 func key(sid string) ([]byte, error) {
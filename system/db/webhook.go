@@ -0,0 +1,339 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+// ErrNoWebhookExists is used for the db to report a non-existing webhook
+var ErrNoWebhookExists = errors.New("Error. No webhook exists.")
+
+// ErrNoWebhookDeliveryExists is used for the db to report a non-existing
+// webhook delivery
+var ErrNoWebhookDeliveryExists = errors.New("Error. No webhook delivery exists.")
+
+// webhookCursorKey holds the sequence number of the last change in the change
+// log which webhook deliveries have been created for. Keys in the __webhooks
+// bucket beginning with "__" are not webhooks.
+const webhookCursorKey = "__cursor"
+
+// SetWebhook saves a webhook in the db. hook is the webhook.Webhook, which is
+// stored as JSON with its id as the key.
+func SetWebhook(id string, hook interface{}) error {
+	return setJSON("__webhooks", id, hook)
+}
+
+// Webhook gets the webhook by ID from the db
+func Webhook(id string) ([]byte, error) {
+	return getJSON("__webhooks", id, ErrNoWebhookExists)
+}
+
+// WebhookAll returns all webhooks from the db
+func WebhookAll() ([][]byte, error) {
+	return allJSON("__webhooks", 0)
+}
+
+// DeleteWebhook removes a webhook from the db. Its deliveries are kept in the
+// delivery log.
+func DeleteWebhook(id string) error {
+	return deleteKey("__webhooks", id)
+}
+
+// WebhookCursor returns the sequence number of the last change webhook
+// deliveries have been created for, and false if none have been created yet
+func WebhookCursor() (uint64, bool, error) {
+	var v []byte
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__webhooks"))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		v = b.Get([]byte(webhookCursorKey))
+		return nil
+	})
+	if err != nil || v == nil {
+		return 0, false, err
+	}
+
+	seq, err := strconv.ParseUint(string(v), 10, 64)
+	return seq, true, err
+}
+
+// SetWebhookCursor sets the sequence number of the last change webhook
+// deliveries have been created for
+func SetWebhookCursor(seq uint64) error {
+	return store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__webhooks"))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		return b.Put([]byte(webhookCursorKey), []byte(strconv.FormatUint(seq, 10)))
+	})
+}
+
+// SetWebhookDelivery saves a webhook delivery in the db. IDs should sort in
+// the order deliveries are created, which is the order they are listed in. due
+// is the time in milliseconds the delivery should next be attempted, or 0 if it
+// is no longer pending.
+func SetWebhookDelivery(id string, delivery interface{}, due int64) error {
+	j, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	return store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__webhookDeliveries"))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		err := b.Put([]byte(id), j)
+		if err != nil {
+			return err
+		}
+
+		return setWebhookDue(tx, id, due)
+	})
+}
+
+// QueueWebhookDeliveries saves the webhook deliveries created for the change
+// with the sequence number seq, keyed by their IDs and due at the time in
+// milliseconds, and moves the webhook cursor to seq in the same transaction, so
+// the deliveries for a change are only ever stored once
+func QueueWebhookDeliveries(seq uint64, deliveries map[string]interface{}, due int64) error {
+	jj := make(map[string][]byte)
+	for id, d := range deliveries {
+		j, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+
+		jj[id] = j
+	}
+
+	return store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__webhookDeliveries"))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		for id, j := range jj {
+			err := b.Put([]byte(id), j)
+			if err != nil {
+				return err
+			}
+
+			err = setWebhookDue(tx, id, due)
+			if err != nil {
+				return err
+			}
+		}
+
+		hooks := tx.Bucket([]byte("__webhooks"))
+		if hooks == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		return hooks.Put([]byte(webhookCursorKey), []byte(strconv.FormatUint(seq, 10)))
+	})
+}
+
+// WebhookDelivery gets the webhook delivery by ID from the db
+func WebhookDelivery(id string) ([]byte, error) {
+	return getJSON("__webhookDeliveries", id, ErrNoWebhookDeliveryExists)
+}
+
+// WebhookDeliveries returns up to count webhook deliveries from the db, most
+// recent first. A count of -1 returns all deliveries.
+func WebhookDeliveries(count int) ([][]byte, error) {
+	return allJSON("__webhookDeliveries", count)
+}
+
+// WebhookDeliveriesDue returns up to count webhook deliveries due to be
+// attempted at or before the time in milliseconds, in the order they are due
+func WebhookDeliveriesDue(before int64, count int) ([][]byte, error) {
+	var due [][]byte
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__webhookDeliveries"))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		idx := tx.Bucket([]byte("__webhookDue"))
+		if idx == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		last := webhookDueKey(before, "")
+		c := idx.Cursor()
+		for k, v := c.Seek([]byte(webhookDuePrefix)); k != nil && len(due) < count; k, v = c.Next() {
+			if !bytes.HasPrefix(k, []byte(webhookDuePrefix)) || bytes.Compare(k[:len(last)], last) > 0 {
+				break
+			}
+
+			j := b.Get(v)
+			if j == nil {
+				continue
+			}
+
+			// values are only valid for the life of the transaction
+			due = append(due, append([]byte(nil), j...))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return due, nil
+}
+
+// DeleteWebhookDelivery removes a webhook delivery from the delivery log
+func DeleteWebhookDelivery(id string) error {
+	return store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__webhookDeliveries"))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		err := b.Delete([]byte(id))
+		if err != nil {
+			return err
+		}
+
+		return setWebhookDue(tx, id, 0)
+	})
+}
+
+// pending deliveries are indexed in the __webhookDue bucket by the time they
+// are due followed by their ID, so those due can be read with a cursor in the
+// order they are due. Each delivery's key in the index is also stored under
+// its ID, so it can be moved when the delivery is rescheduled.
+const (
+	webhookDuePrefix = "due:"
+	webhookDueIDs    = "id:"
+)
+
+func webhookDueKey(due int64, id string) []byte {
+	return []byte(fmt.Sprintf("%s%016x%s", webhookDuePrefix, due, id))
+}
+
+// setWebhookDue indexes the delivery with the id as due at the time in
+// milliseconds, removing it from the index if due is 0
+func setWebhookDue(tx *bolt.Tx, id string, due int64) error {
+	idx := tx.Bucket([]byte("__webhookDue"))
+	if idx == nil {
+		return bolt.ErrBucketNotFound
+	}
+
+	ref := []byte(webhookDueIDs + id)
+	if prev := idx.Get(ref); prev != nil {
+		err := idx.Delete(append([]byte(nil), prev...))
+		if err != nil {
+			return err
+		}
+	}
+
+	if due == 0 {
+		return idx.Delete(ref)
+	}
+
+	k := webhookDueKey(due, id)
+	err := idx.Put(k, []byte(id))
+	if err != nil {
+		return err
+	}
+
+	return idx.Put(ref, k)
+}
+
+func setJSON(bucket, key string, v interface{}) error {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		return b.Put([]byte(key), j)
+	})
+}
+
+func getJSON(bucket, key string, notFound error) ([]byte, error) {
+	val := &bytes.Buffer{}
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		_, err := val.Write(b.Get([]byte(key)))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if val.Len() == 0 {
+		return nil, notFound
+	}
+
+	return val.Bytes(), nil
+}
+
+// allJSON returns up to count values from the bucket in reverse key order,
+// skipping keys beginning with "__". A count of 0 or -1 returns all values.
+func allJSON(bucket string, count int) ([][]byte, error) {
+	var all [][]byte
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			if count > 0 && len(all) >= count {
+				break
+			}
+
+			if strings.HasPrefix(string(k), "__") {
+				continue
+			}
+
+			// values are only valid for the life of the transaction
+			all = append(all, append([]byte(nil), v...))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return all, nil
+}
+
+func deleteKey(bucket, key string) error {
+	return store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		return b.Delete([]byte(key))
+	})
+}
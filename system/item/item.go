@@ -127,6 +127,39 @@ type Omittable interface {
 	Omit(http.ResponseWriter, *http.Request) ([]string, error)
 }
 
+// Cacheable lets a user define the Cache-Control header of content API responses
+// for a content type, in place of the policy set in the system configuration,
+// e.g. "max-age=60, public" for content which changes often.
+type Cacheable interface {
+	CacheControl(http.ResponseWriter, *http.Request) (string, error)
+}
+
+// ResponseCacheable lets a user keep content API responses for a content type in
+// the server's response cache, when it is enabled in the system configuration.
+// Requests with the same path, query and values of the headers returned by
+// CacheVary share a response, so every header the type's Hide, Omit or
+// BeforeAPIResponse depend on, such as "Authorization", must be listed.
+// BeforeAPIResponse is only called when a response is rendered, and the headers
+// it sets are kept with the response and sent with every request sharing it.
+type ResponseCacheable interface {
+	CacheVary() []string
+}
+
+// Mappable lets a user define the URL of each item of a content type in the
+// sitemap at /sitemap.xml, in place of the URL pattern set in the system
+// configuration. Items for which SitemapURL returns "" are left out.
+type Mappable interface {
+	SitemapURL() string
+}
+
+// Encryptable lets a user define certain fields within a content struct to be
+// encrypted at rest. Values are encrypted before they are stored in the database
+// and decrypted when read, and are never added to a search index. All items in
+// the slice should be the json tag names of the struct fields to which they correspond.
+type Encryptable interface {
+	EncryptFields() []string
+}
+
 // Attachable lets a user define a content type in a plugin.
 type Attachable interface {
 	Attach()
@@ -34,3 +34,27 @@ var (
 func init() {
 	Types = make(map[string]func() interface{})
 }
+
+// APIError can be returned by a hook or interface method called for a request
+// to the content API, such as BeforeAPICreate or Hide, to respond with the
+// error's status and an error body giving its code, message and any details.
+// Other errors leave it to the method to write a response status.
+type APIError struct {
+	Status  int         `json:"-"`
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// NewAPIError returns an APIError with the status, code and message
+func NewAPIError(status int, code, message string) *APIError {
+	return &APIError{
+		Status:  status,
+		Code:    code,
+		Message: message,
+	}
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
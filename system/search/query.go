@@ -0,0 +1,239 @@
+package search
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kudzu-cms/kudzu/system/item"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/document"
+	"github.com/blevesearch/bleve/search"
+)
+
+const (
+	// maxFragments is the most highlighted fragments returned for each field
+	// of a hit
+	maxFragments = 3
+
+	// defaultFacetSize is the number of terms counted by a Facet with no Size
+	defaultFacetSize = 10
+)
+
+// Facetable enables the search API to return facets, counts of the hits for
+// each term or date range of a field, for the facets a type provides. Facets
+// are requested by name.
+type Facetable interface {
+	SearchFacets() []Facet
+}
+
+// Facet counts the hits of a search by the terms of a field, or by the date
+// ranges the field's value falls within if DateRanges are set. Size is the most
+// terms counted, 10 if not set.
+type Facet struct {
+	Name       string
+	Field      string
+	Size       int
+	DateRanges []DateRange
+}
+
+// DateRange is a named range of dates counted by a Facet. A zero Start or End
+// leaves the range open on that side.
+type DateRange struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+}
+
+// Options sets how a query made by Query is paginated, sorted and extended
+type Options struct {
+	// Count is the number of hits to return, or all hits if -1
+	Count  int
+	Offset int
+
+	// Sort lists the fields to sort hits by, prefixed with "-" for descending
+	// order, and "_score" for relevance. Hits are sorted by relevance if empty.
+	Sort []string
+
+	// Highlight includes the locations of the terms matched in each hit, so
+	// fragments of the matching fields can be highlighted
+	Highlight bool
+
+	Facets []Facet
+}
+
+// Result is the outcome of a query made by Query
+type Result struct {
+	Hits   []Hit
+	Total  uint64
+	Took   time.Duration
+	Facets search.FacetResults
+}
+
+// Hit is a search result for content of any type
+type Hit struct {
+	Type      string              `json:"type"`
+	ID        int                 `json:"id"`
+	Score     float64             `json:"score"`
+	Fragments map[string][]string `json:"fragments,omitempty"`
+
+	match *search.DocumentMatch
+}
+
+// Target returns the kudzu "target", Type:ID, of the content hit
+func (h Hit) Target() string {
+	return fmt.Sprintf("%s:%d", h.Type, h.ID)
+}
+
+// Highlight sets the hit's Fragments to the best fragments of each field of
+// the content matched by the query, with the terms matched marked by <mark>.
+// It does nothing unless the query was made with Options.Highlight.
+func (h *Hit) Highlight(content []byte) error {
+	if h.match == nil || len(h.match.Locations) == 0 {
+		return nil
+	}
+
+	idx, ok := Search[h.Type]
+	if !ok {
+		return ErrNoIndex
+	}
+
+	it, ok := item.Types[h.Type]
+	if !ok {
+		return fmt.Errorf("[search] Highlight Error: type '%s' doesn't exist", h.Type)
+	}
+
+	// fields aren't stored in the index, so the document is mapped again from
+	// the content, as it was when indexed
+	p, err := indexable(it, content)
+	if err != nil {
+		return err
+	}
+
+	doc := document.NewDocument(h.match.ID)
+	err = idx.Mapping().MapDocument(doc, p)
+	if err != nil {
+		return err
+	}
+
+	highlighter, err := bleve.Config.Cache.HighlighterNamed("html")
+	if err != nil {
+		return err
+	}
+
+	h.Fragments = make(map[string][]string)
+	for field := range h.match.Locations {
+		// fields missing from the content, such as those omitted from the
+		// response, give empty fragments
+		var fragments []string
+		for _, f := range highlighter.BestFragmentsInField(h.match, doc, field, maxFragments) {
+			if f != "" {
+				fragments = append(fragments, f)
+			}
+		}
+
+		if len(fragments) > 0 {
+			h.Fragments[field] = fragments
+		}
+	}
+
+	return nil
+}
+
+// Query conducts a search across the indices of each type provided, and returns
+// the hits from all of them merged by score, or in the order of opts.Sort. If
+// none of the types has a search index, ErrNoIndex will be returned as the error,
+// and if the query can't be parsed, an error wrapping ErrInvalidQuery.
+func Query(typeNames []string, query string, opts Options) (*Result, error) {
+	var indices []bleve.Index
+	var docs uint64
+	for _, t := range typeNames {
+		idx, ok := Search[t]
+		if !ok {
+			continue
+		}
+
+		n, err := idx.DocCount()
+		if err != nil {
+			return nil, err
+		}
+
+		indices = append(indices, idx)
+		docs += n
+	}
+
+	if len(indices) == 0 {
+		return nil, ErrNoIndex
+	}
+
+	count := opts.Count
+	if count < 0 {
+		count = int(docs)
+	}
+
+	q := bleve.NewQueryStringQuery(query)
+	if _, err := q.Parse(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+	}
+
+	req := bleve.NewSearchRequestOptions(q, count, opts.Offset, false)
+	req.IncludeLocations = opts.Highlight
+
+	if len(opts.Sort) > 0 {
+		req.SortBy(opts.Sort)
+	}
+
+	for _, f := range opts.Facets {
+		size := f.Size
+		if size == 0 {
+			size = defaultFacetSize
+		}
+
+		// every date range is counted
+		if len(f.DateRanges) > 0 {
+			size = len(f.DateRanges)
+		}
+
+		fr := bleve.NewFacetRequest(f.Field, size)
+		for _, r := range f.DateRanges {
+			fr.AddDateTimeRange(r.Name, r.Start, r.End)
+		}
+
+		req.AddFacet(f.Name, fr)
+	}
+
+	res, err := bleve.NewIndexAlias(indices...).Search(req)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{
+		Hits:   []Hit{},
+		Total:  res.Total,
+		Took:   res.Took,
+		Facets: res.Facets,
+	}
+
+	for _, match := range res.Hits {
+		target := strings.Split(match.ID, ":")
+		if len(target) != 2 {
+			continue
+		}
+
+		id, err := strconv.Atoi(target[1])
+		if err != nil {
+			continue
+		}
+
+		hit := Hit{Type: target[0], ID: id, Score: match.Score}
+		if opts.Highlight {
+			hit.match = match
+		}
+
+		result.Hits = append(result.Hits, hit)
+	}
+
+	return result, nil
+}
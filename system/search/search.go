@@ -16,6 +16,7 @@ import (
 
 	"github.com/blevesearch/bleve"
 	"github.com/blevesearch/bleve/mapping"
+	"github.com/tidwall/sjson"
 )
 
 var (
@@ -24,6 +25,9 @@ var (
 
 	// ErrNoIndex is for failed checks for an index in Search map
 	ErrNoIndex = errors.New("No search index found for type provided")
+
+	// ErrInvalidQuery is wrapped by errors for queries which can't be parsed
+	ErrInvalidQuery = errors.New("Invalid query string")
 )
 
 // Searchable ...
@@ -99,14 +103,13 @@ func UpdateIndex(id string, data interface{}) error {
 
 	idx, ok := Search[ns]
 	if ok {
-		// unmarshal json to struct, error if not registered
+		// error if type not registered
 		it, ok := item.Types[ns]
 		if !ok {
 			return fmt.Errorf("[search] UpdateIndex Error: type '%s' doesn't exist", ns)
 		}
 
-		p := it()
-		err := json.Unmarshal(data.([]byte), &p)
+		p, err := indexable(it, data.([]byte))
 		if err != nil {
 			return err
 		}
@@ -118,6 +121,30 @@ func UpdateIndex(id string, data interface{}) error {
 	return nil
 }
 
+// indexable returns the content j as an item of the type made by it, as it is
+// added to the type's search index
+func indexable(it func() interface{}, j []byte) (interface{}, error) {
+	p := it()
+
+	// encrypted fields must never be stored in plaintext in the index
+	if e, ok := p.(item.Encryptable); ok {
+		for _, field := range e.EncryptFields() {
+			var err error
+			j, err = sjson.DeleteBytes(j, field)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err := json.Unmarshal(j, &p)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
 // DeleteIndex removes data from a content type's search index at the
 // given identifier
 func DeleteIndex(id string) error {
@@ -0,0 +1,198 @@
+package search
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis"
+	"github.com/blevesearch/bleve/analysis/analyzer/custom"
+	"github.com/blevesearch/bleve/analysis/token/edgengram"
+	"github.com/blevesearch/bleve/analysis/token/lowercase"
+	"github.com/blevesearch/bleve/analysis/tokenizer/unicode"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/registry"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+const (
+	// SuggestAnalyzer is the name of the analyzer which indexes the prefixes
+	// of each word of a suggestable field
+	SuggestAnalyzer = "kudzu_suggest"
+
+	// suggestSuffix is appended to a suggestable field's name to name the
+	// field its prefixes are indexed in
+	suggestSuffix = ".suggest"
+
+	// suggestQueryAnalyzer splits a query into the words matched against the
+	// prefixes indexed by SuggestAnalyzer. Like it, and unlike the standard
+	// analyzer, it keeps stop words, so "the" and "on" can be suggested from.
+	suggestQueryAnalyzer = "kudzu_suggest_query"
+
+	suggestFilter    = "kudzu_suggest_edge_ngram"
+	suggestMinPrefix = 1
+	suggestMaxPrefix = 20
+
+	// suggestFuzzyLength is the shortest query matched with a typo tolerated,
+	// and suggestFuzzierLength the shortest with two
+	suggestFuzzyLength   = 4
+	suggestFuzzierLength = 7
+)
+
+// ErrNoSuggest is returned by Suggest for types without suggestable fields
+var ErrNoSuggest = errors.New("No suggestable fields in search index for type provided")
+
+func init() {
+	// the query analyzer is registered with bleve rather than added to each
+	// mapping, so indexes created before it existed can use it too
+	registry.RegisterAnalyzer(suggestQueryAnalyzer, suggestQueryAnalyzerConstructor)
+}
+
+func suggestQueryAnalyzerConstructor(config map[string]interface{}, cache *registry.Cache) (*analysis.Analyzer, error) {
+	tokenizer, err := cache.TokenizerNamed(unicode.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	toLower, err := cache.TokenFilterNamed(lowercase.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &analysis.Analyzer{
+		Tokenizer:    tokenizer,
+		TokenFilters: []analysis.TokenFilter{toLower},
+	}, nil
+}
+
+// Suggestable marks the top-level field named in the mapping as suggestable, so
+// the start of each of its words is matched by Suggest. The field is still
+// indexed for full-text search as it would be by the default mapping. Content
+// indexed before a field is marked must be indexed again to be suggested.
+func Suggestable(m *mapping.IndexMappingImpl, field string) error {
+	if _, ok := m.CustomAnalysis.TokenFilters[suggestFilter]; !ok {
+		err := m.AddCustomTokenFilter(suggestFilter, map[string]interface{}{
+			"type": edgengram.Name,
+			"min":  float64(suggestMinPrefix),
+			"max":  float64(suggestMaxPrefix),
+		})
+		if err != nil {
+			return err
+		}
+
+		err = m.AddCustomAnalyzer(SuggestAnalyzer, map[string]interface{}{
+			"type":          custom.Name,
+			"tokenizer":     unicode.Name,
+			"token_filters": []string{lowercase.Name, suggestFilter},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	prefixes := bleve.NewTextFieldMapping()
+	prefixes.Name = field + suggestSuffix
+	prefixes.Analyzer = SuggestAnalyzer
+	prefixes.IncludeInAll = false
+	prefixes.IncludeTermVectors = false
+
+	m.DefaultMapping.AddFieldMappingsAt(field, bleve.NewTextFieldMapping(), prefixes)
+
+	return nil
+}
+
+// Suggestion is a hit for content of the type suggested by Suggest
+type Suggestion struct {
+	ID    int     `json:"id"`
+	Score float64 `json:"score"`
+}
+
+// Suggest returns up to count hits for content of the type with a suggestable
+// field containing words starting with each word of the query, as it is typed.
+// Longer queries also match words with a typo, or two. If there is no search
+// index for the type, ErrNoIndex will be returned as the error, or ErrNoSuggest
+// if the type's SearchMapping has no suggestable fields.
+func Suggest(typeName, q string, count int) ([]Suggestion, error) {
+	idx, ok := Search[typeName]
+	if !ok {
+		return nil, ErrNoIndex
+	}
+
+	m, ok := idx.Mapping().(*mapping.IndexMappingImpl)
+	if !ok {
+		return nil, ErrNoSuggest
+	}
+
+	fields := suggestFields(m.DefaultMapping)
+	if len(fields) == 0 {
+		return nil, ErrNoSuggest
+	}
+
+	var fuzziness int
+	switch n := utf8.RuneCountInString(q); {
+	case n >= suggestFuzzierLength:
+		fuzziness = 2
+	case n >= suggestFuzzyLength:
+		fuzziness = 1
+	}
+
+	var queries []query.Query
+	for _, field := range fields {
+		// the query is split into words, but not into prefixes
+		prefix := bleve.NewMatchQuery(q)
+		prefix.SetField(field)
+		prefix.Analyzer = suggestQueryAnalyzer
+		prefix.SetOperator(query.MatchQueryOperatorAnd)
+		prefix.SetBoost(2)
+		queries = append(queries, prefix)
+
+		if fuzziness > 0 {
+			fuzzy := bleve.NewMatchQuery(q)
+			fuzzy.SetField(field)
+			fuzzy.Analyzer = suggestQueryAnalyzer
+			fuzzy.SetOperator(query.MatchQueryOperatorAnd)
+			fuzzy.SetFuzziness(fuzziness)
+			queries = append(queries, fuzzy)
+		}
+	}
+
+	req := bleve.NewSearchRequestOptions(bleve.NewDisjunctionQuery(queries...), count, 0, false)
+	res, err := idx.Search(req)
+	if err != nil {
+		return nil, err
+	}
+
+	suggestions := []Suggestion{}
+	for _, hit := range res.Hits {
+		target := strings.Split(hit.ID, ":")
+		if len(target) != 2 {
+			continue
+		}
+
+		id, err := strconv.Atoi(target[1])
+		if err != nil {
+			continue
+		}
+
+		suggestions = append(suggestions, Suggestion{ID: id, Score: hit.Score})
+	}
+
+	return suggestions, nil
+}
+
+// suggestFields returns the names of the fields the prefixes of suggestable
+// fields are indexed in
+func suggestFields(dm *mapping.DocumentMapping) []string {
+	var fields []string
+	for _, prop := range dm.Properties {
+		for _, fm := range prop.Fields {
+			if fm.Analyzer == SuggestAnalyzer {
+				fields = append(fields, fm.Name)
+			}
+		}
+	}
+
+	return fields
+}
@@ -0,0 +1,248 @@
+package tenant
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/kudzu-cms/kudzu/system/cfg"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// maxRestartDelay is the longest a worker will wait before being restarted
+// after exiting unexpectedly
+const maxRestartDelay = time.Second * 30
+
+// worker runs and supervises the kudzu process serving a single tenant
+type worker struct {
+	tenant Tenant
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	stopping bool
+}
+
+// Serve runs the multi-tenant front server on the port provided. It starts a
+// worker process for each tenant, listening on the tenant's private port with
+// the tenant's data directory, and proxies each request to the worker for the
+// tenant matching the request's Host header. Requests for unknown hosts receive
+// a 404 Not Found response. If https is true, the front server also serves
+// HTTPS on httpsport, with certificates from Let's Encrypt for every tenant's
+// hosts, since workers only serve HTTP.
+func Serve(bind string, port int, https bool, httpsport int) error {
+	tenants, err := Load()
+	if err != nil {
+		return err
+	}
+
+	if len(tenants) == 0 {
+		return ErrNoTenants
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	hosts := make(map[string]*httputil.ReverseProxy)
+	var workers []*worker
+	for _, t := range tenants {
+		target, err := url.Parse(fmt.Sprintf("http://localhost:%d", t.Port))
+		if err != nil {
+			return err
+		}
+
+		proxy := httputil.NewSingleHostReverseProxy(target)
+		// flush immediately so long-polling and streaming responses are not buffered
+		proxy.FlushInterval = -1
+
+		for _, h := range t.Hosts {
+			hosts[strings.ToLower(h)] = proxy
+		}
+
+		w := &worker{tenant: t}
+		workers = append(workers, w)
+		go w.run(exe)
+	}
+
+	// stop all workers before exiting
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		for _, w := range workers {
+			w.stop()
+		}
+		os.Exit(0)
+	}()
+
+	handler := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		proxy, ok := hosts[hostname(req.Host)]
+		if !ok {
+			res.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		// workers only see HTTP requests, so are told whether the client
+		// used HTTPS, replacing anything the client sent
+		proto := "http"
+		if req.TLS != nil {
+			proto = "https"
+		}
+		req.Header.Set("X-Forwarded-Proto", proto)
+
+		proxy.ServeHTTP(res, req)
+	})
+
+	addr := fmt.Sprintf("%s:%d", bind, port)
+	if !https {
+		fmt.Printf("Serving %d tenants at http://%s...\n", len(tenants), addr)
+		return http.ListenAndServe(addr, handler)
+	}
+
+	m, err := certManager(tenants)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:      fmt.Sprintf("%s:%d", bind, httpsport),
+		Handler:   handler,
+		TLSConfig: &tls.Config{GetCertificate: m.GetCertificate},
+	}
+
+	// the HTTP port answers Let's Encrypt's "http-01" challenges, and serves
+	// other requests as it would without HTTPS
+	go func() {
+		log.Fatalln(http.ListenAndServe(addr, m.HTTPHandler(handler)))
+	}()
+
+	fmt.Printf("Serving %d tenants at http://%s and https://%s...\n", len(tenants), addr, server.Addr)
+	return server.ListenAndServeTLS("", "")
+}
+
+// certManager returns an autocert.Manager fetching certificates from Let's
+// Encrypt for the hosts of every tenant, cached with the certificates of a
+// single site
+func certManager(tenants []Tenant) (*autocert.Manager, error) {
+	cache := filepath.Join(cfg.TlsDir(), "certs")
+	err := os.MkdirAll(cache, os.ModePerm|os.ModeDir)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't create cert directory at %s: %s", cache, err)
+	}
+
+	var hosts []string
+	for _, t := range tenants {
+		hosts = append(hosts, t.Hosts...)
+	}
+
+	return &autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		Cache:       autocert.DirCache(cache),
+		HostPolicy:  autocert.HostWhitelist(hosts...),
+		RenewBefore: time.Hour * 24 * 30,
+	}, nil
+}
+
+// run starts the tenant's worker process and restarts it with an increasing
+// delay whenever it exits, until the worker is stopped
+func (w *worker) run(exe string) {
+	delay := time.Second
+	for {
+		w.mu.Lock()
+		if w.stopping {
+			w.mu.Unlock()
+			return
+		}
+
+		cmd := exec.Command(exe, os.Args[1:]...)
+		cmd.Env = w.env()
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		w.cmd = cmd
+
+		started := time.Now()
+		err := cmd.Start()
+		w.mu.Unlock()
+
+		if err == nil {
+			err = cmd.Wait()
+		}
+
+		w.mu.Lock()
+		stopping := w.stopping
+		w.mu.Unlock()
+		if stopping {
+			return
+		}
+
+		// reset the delay if the worker ran for a while before exiting
+		if time.Since(started) > maxRestartDelay {
+			delay = time.Second
+		}
+
+		log.Printf("[Tenant] worker for %s exited: %v, restarting in %s\n", w.tenant.Name, err, delay)
+		time.Sleep(delay)
+
+		delay *= 2
+		if delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+	}
+}
+
+// stop terminates the tenant's worker process and prevents it being restarted
+func (w *worker) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.stopping = true
+	if w.cmd != nil && w.cmd.Process != nil {
+		w.cmd.Process.Signal(syscall.SIGTERM)
+	}
+}
+
+// env returns the environment for the tenant's worker process. Directories
+// derived from the data directory are removed so they can't be shared between
+// tenants by an inherited setting.
+func (w *worker) env() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		switch strings.SplitN(kv, "=", 2)[0] {
+		case "KUDZU_DATA_DIR", "KUDZU_UPLOAD_DIR", "KUDZU_SEARCH_DIR",
+			"KUDZU_TENANT", "KUDZU_TENANT_PORT":
+			continue
+		}
+
+		env = append(env, kv)
+	}
+
+	return append(env,
+		"KUDZU_DATA_DIR="+w.tenant.DataDir,
+		"KUDZU_TENANT="+w.tenant.Name,
+		fmt.Sprintf("KUDZU_TENANT_PORT=%d", w.tenant.Port),
+	)
+}
+
+// hostname returns the lowercased host of a Host header, without the port
+func hostname(host string) string {
+	h, _, err := net.SplitHostPort(host)
+	if err != nil {
+		h = host
+	}
+
+	return strings.ToLower(h)
+}
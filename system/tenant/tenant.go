@@ -0,0 +1,152 @@
+// Package tenant provides a multi-tenant mode to serve several kudzu sites from
+// a single front process, selecting a tenant by the Host header of a request.
+// Each tenant has its own data directory, and therefore its own system.db,
+// analytics.db, uploads, search indexes, users and configuration, while sharing
+// the content types registered in the binary.
+package tenant
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/kudzu-cms/kudzu/system/cfg"
+)
+
+// basePort is the first private port assigned to tenant worker processes
+const basePort = 9100
+
+var (
+	// ErrTenantExists is returned when provisioning a tenant whose name or host
+	// is already in use
+	ErrTenantExists = errors.New("Tenant name or host already in use")
+
+	// ErrInvalidTenantName is returned when provisioning a tenant with a name
+	// that can't be used as a directory name
+	ErrInvalidTenantName = errors.New("Tenant name must only contain letters, numbers, '-' and '_'")
+
+	// ErrNoTenants is returned when serving in multi-tenant mode before any
+	// tenants have been provisioned
+	ErrNoTenants = errors.New("No tenants found in tenants file. Provision a tenant first.")
+
+	validName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+)
+
+// Tenant describes a site served in multi-tenant mode
+type Tenant struct {
+	Name    string   `json:"name"`
+	Hosts   []string `json:"hosts"`
+	DataDir string   `json:"data_dir"`
+	Port    int      `json:"port"`
+}
+
+// Enabled reports whether a tenants file exists, meaning the system should run
+// in multi-tenant mode
+func Enabled() bool {
+	_, err := os.Stat(cfg.TenantsFile())
+	return err == nil
+}
+
+// Load reads all provisioned tenants from the tenants file
+func Load() ([]Tenant, error) {
+	b, err := ioutil.ReadFile(cfg.TenantsFile())
+	if os.IsNotExist(err) {
+		return []Tenant{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var tenants []Tenant
+	err = json.Unmarshal(b, &tenants)
+	if err != nil {
+		return nil, err
+	}
+
+	return tenants, nil
+}
+
+// Provision creates a data directory for a new tenant, assigns it a private port
+// and adds it to the tenants file. The tenant's admin is initialized the first
+// time /admin is visited on one of its hosts.
+func Provision(name string, hosts []string) (*Tenant, error) {
+	if !validName.MatchString(name) {
+		return nil, ErrInvalidTenantName
+	}
+
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("Tenant %s must have at least one host", name)
+	}
+
+	tenants, err := Load()
+	if err != nil {
+		return nil, err
+	}
+
+	normalized := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		normalized = append(normalized, strings.ToLower(strings.TrimSpace(host)))
+	}
+	hosts = normalized
+
+	port := basePort
+	for _, t := range tenants {
+		if t.Name == name {
+			return nil, ErrTenantExists
+		}
+
+		for _, h := range t.Hosts {
+			for _, host := range hosts {
+				if h == host {
+					return nil, ErrTenantExists
+				}
+			}
+		}
+
+		if t.Port >= port {
+			port = t.Port + 1
+		}
+	}
+
+	t := Tenant{
+		Name:    name,
+		Hosts:   hosts,
+		DataDir: filepath.Join(cfg.TenantsDir(), name),
+		Port:    port,
+	}
+
+	err = os.MkdirAll(t.DataDir, os.ModeDir|os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	tenants = append(tenants, t)
+	err = save(tenants)
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// save writes the tenants file, replacing it atomically
+func save(tenants []Tenant) error {
+	j, err := json.MarshalIndent(tenants, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	path := cfg.TenantsFile()
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, j, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
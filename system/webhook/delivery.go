@@ -0,0 +1,546 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kudzu-cms/kudzu/system/db"
+)
+
+const (
+	// StatusPending is the status of a delivery waiting to be sent or retried
+	StatusPending = "pending"
+
+	// StatusDelivered is the status of a delivery the receiver responded to
+	// with a 2xx status
+	StatusDelivered = "delivered"
+
+	// StatusFailed is the status of a delivery which failed every attempt
+	StatusFailed = "failed"
+
+	// MaxAttempts is the number of times a delivery is attempted before it fails
+	MaxAttempts = 8
+
+	// firstRetry is how long to wait before retrying a failed attempt, doubling
+	// after each attempt, so all attempts are made within about an hour
+	firstRetry = time.Second * 30
+
+	// sendInterval is how often deliveries due to be retried are checked for
+	sendInterval = time.Second * 5
+
+	// changeBatch is the number of changes read from the change log at a time
+	changeBatch = 100
+
+	// sendTimeout is how long a receiver has to respond to a delivery
+	sendTimeout = time.Second * 15
+
+	// sendWorkers is the number of deliveries sent at once, so a slow receiver
+	// only holds up its own deliveries
+	sendWorkers = 8
+
+	// sendBatch is the most due deliveries read from the db at a time
+	sendBatch = 100
+)
+
+// Delivery is an event to be sent to a webhook, and the record of its attempts
+type Delivery struct {
+	ID           string `json:"id"`
+	Hook         string `json:"hook"`
+	URL          string `json:"url"`
+	Event        string `json:"event"`
+	Type         string `json:"type"`
+	ContentID    int    `json:"content_id"`
+	Seq          uint64 `json:"seq"`
+	Timestamp    int64  `json:"timestamp"`
+	Status       string `json:"status"`
+	Created      int64  `json:"created"`
+	Attempts     int    `json:"attempts"`
+	NextAttempt  int64  `json:"next_attempt"`
+	LastAttempt  int64  `json:"last_attempt"`
+	ResponseCode int    `json:"response_code"`
+	Error        string `json:"error"`
+	Redelivery   string `json:"redelivery,omitempty"`
+}
+
+var (
+	client = &http.Client{Timeout: sendTimeout}
+
+	// wake is signalled when deliveries are queued, or a worker is free, so
+	// due deliveries are sent without waiting for the next interval
+	wake = make(chan struct{}, 1)
+
+	// sends passes the IDs of due deliveries to the workers which send them
+	sends = make(chan string)
+
+	// sending holds the IDs of deliveries passed to a worker, so a delivery
+	// isn't sent again while an attempt is in progress
+	sending = struct {
+		sync.Mutex
+		ids map[string]bool
+	}{ids: make(map[string]bool)}
+)
+
+// Run starts creating deliveries for changes in the change log and sending
+// them. It should be called once the db has been initialized. Changes made
+// before webhooks were first run are not delivered.
+func Run() {
+	seq, ok, err := db.WebhookCursor()
+	if err != nil {
+		log.Println("[Webhook] error reading cursor:", err)
+		return
+	}
+
+	if !ok {
+		seq, err = db.LastChange()
+		if err != nil {
+			log.Println("[Webhook] error reading change log:", err)
+			return
+		}
+
+		err = db.SetWebhookCursor(seq)
+		if err != nil {
+			log.Println("[Webhook] error setting cursor:", err)
+			return
+		}
+	}
+
+	go queueChanges(seq)
+	go sendDeliveries()
+
+	for i := 0; i < sendWorkers; i++ {
+		go sendWorker()
+	}
+}
+
+// queueChanges creates deliveries for each webhook matching the changes
+// committed after the change with sequence number seq, waiting for new changes
+func queueChanges(seq uint64) {
+	for {
+		// get the notification channel before reading so a change committed
+		// between the read and the wait can't be missed
+		notify := db.ChangeNotify()
+
+		changes, err := db.Changes(seq, changeBatch, "")
+		if err != nil {
+			log.Println("[Webhook] error reading change log:", err)
+		}
+
+		if len(changes) > 0 {
+			// seq is moved past each change whose deliveries were stored, even
+			// if a later one failed, so they aren't queued again on retry
+			seq, err = queue(seq, changes)
+			if err != nil {
+				log.Println("[Webhook] error queueing deliveries:", err)
+			}
+		}
+
+		if err != nil {
+			time.Sleep(sendInterval)
+			continue
+		}
+
+		if len(changes) == changeBatch {
+			continue
+		}
+
+		<-notify
+	}
+}
+
+// queue creates deliveries for each webhook matching the changes, storing them
+// and moving the cursor past each change in one transaction. It returns the
+// sequence number of the last change queued, or seq if none were.
+func queue(seq uint64, changes []db.Change) (uint64, error) {
+	hooks, err := All()
+	if err != nil {
+		return seq, err
+	}
+
+	now := millis(time.Now())
+	queued := false
+	defer func() {
+		if queued {
+			wakeSender()
+		}
+	}()
+
+	for _, c := range changes {
+		event, ok := events[c.Op]
+		if !ok {
+			continue
+		}
+
+		deliveries := make(map[string]interface{})
+		for _, w := range hooks {
+			if !w.Matches(c.Type, event) {
+				continue
+			}
+
+			id, err := deliveryID()
+			if err != nil {
+				return seq, err
+			}
+
+			d := &Delivery{
+				ID:          id,
+				Hook:        w.ID,
+				URL:         w.URL,
+				Event:       event,
+				Type:        c.Type,
+				ContentID:   c.ID,
+				Seq:         c.Seq,
+				Timestamp:   c.Timestamp,
+				Status:      StatusPending,
+				Created:     now,
+				NextAttempt: now,
+			}
+
+			deliveries[d.ID] = d
+		}
+
+		if len(deliveries) == 0 {
+			continue
+		}
+
+		err = db.QueueWebhookDeliveries(c.Seq, deliveries, now)
+		if err != nil {
+			return seq, err
+		}
+
+		seq = c.Seq
+		queued = true
+	}
+
+	// changes after the last with deliveries only need the cursor moved
+	last := changes[len(changes)-1].Seq
+	if last != seq {
+		err = db.SetWebhookCursor(last)
+		if err != nil {
+			return seq, err
+		}
+	}
+
+	return last, nil
+}
+
+// wakeSender signals sendDeliveries to check for due deliveries now
+func wakeSender() {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// sendDeliveries passes deliveries which are due to the workers, and prunes the
+// delivery log
+func sendDeliveries() {
+	ticker := time.NewTicker(sendInterval)
+	pruned := time.Time{}
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-wake:
+		}
+
+		err := sendDue()
+		if err != nil {
+			log.Println("[Webhook] error sending deliveries:", err)
+		}
+
+		if time.Since(pruned) > time.Hour {
+			err := prune(time.Now().Add(-db.ChangeRetention()))
+			if err != nil {
+				log.Println("[Webhook] error pruning delivery log:", err)
+			}
+
+			pruned = time.Now()
+		}
+	}
+}
+
+// sendDue passes deliveries which are due to idle workers, in the order they
+// are due. Deliveries left once every worker is busy are passed on when one is
+// free.
+func sendDue() error {
+	jj, err := db.WebhookDeliveriesDue(millis(time.Now()), sendBatch)
+	if err != nil {
+		return err
+	}
+
+	for _, j := range jj {
+		var d Delivery
+		err := json.Unmarshal(j, &d)
+		if err != nil {
+			return err
+		}
+
+		if !claim(d.ID) {
+			continue
+		}
+
+		select {
+		case sends <- d.ID:
+		default:
+			release(d.ID)
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// sendWorker sends the deliveries passed to it by sendDue
+func sendWorker() {
+	for id := range sends {
+		err := sendClaimed(id)
+		if err != nil {
+			log.Println("[Webhook] error sending delivery", id+":", err)
+		}
+
+		release(id)
+		wakeSender()
+	}
+}
+
+// sendClaimed sends the delivery with the id if it is still due. It is read
+// again once claimed, since an attempt may have finished after it was listed.
+func sendClaimed(id string) error {
+	j, err := db.WebhookDelivery(id)
+	if err == db.ErrNoWebhookDeliveryExists {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var d Delivery
+	err = json.Unmarshal(j, &d)
+	if err != nil {
+		return err
+	}
+
+	if d.Status != StatusPending || d.NextAttempt > millis(time.Now()) {
+		return nil
+	}
+
+	return Send(&d)
+}
+
+// claim marks the delivery with the id as being sent, and reports whether it
+// wasn't already
+func claim(id string) bool {
+	sending.Lock()
+	defer sending.Unlock()
+
+	if sending.ids[id] {
+		return false
+	}
+
+	sending.ids[id] = true
+	return true
+}
+
+func release(id string) {
+	sending.Lock()
+	defer sending.Unlock()
+
+	delete(sending.ids, id)
+}
+
+// Send attempts to send a delivery to its webhook, and stores the result. An
+// error is only returned if the result can't be stored. Deliveries are sent by
+// the workers started by Run, which never attempt a delivery twice at once.
+func Send(d *Delivery) error {
+	j, err := db.Webhook(d.Hook)
+	if err == db.ErrNoWebhookExists {
+		d.Status = StatusFailed
+		d.Error = "The webhook has been deleted"
+		return db.SetWebhookDelivery(d.ID, d, 0)
+	}
+	if err != nil {
+		return err
+	}
+
+	var w Webhook
+	err = json.Unmarshal(j, &w)
+	if err != nil {
+		return err
+	}
+
+	d.Attempts++
+	d.LastAttempt = millis(time.Now())
+	d.ResponseCode, err = post(&w, d)
+	d.Error = ""
+	if err != nil {
+		d.Error = err.Error()
+	}
+
+	switch {
+	case err == nil:
+		d.Status = StatusDelivered
+
+	case d.Attempts >= MaxAttempts:
+		d.Status = StatusFailed
+
+	default:
+		backoff := firstRetry * time.Duration(1<<uint(d.Attempts-1))
+		d.NextAttempt = millis(time.Now().Add(backoff))
+		return db.SetWebhookDelivery(d.ID, d, d.NextAttempt)
+	}
+
+	return db.SetWebhookDelivery(d.ID, d, 0)
+}
+
+// post sends the delivery's payload to the webhook, returning the status code
+// of the response and an error if the response wasn't 2xx
+func post(w *Webhook, d *Delivery) (int, error) {
+	body, err := json.Marshal(Payload{
+		Delivery:  d.ID,
+		Event:     d.Event,
+		Type:      d.Type,
+		ID:        d.ContentID,
+		Seq:       d.Seq,
+		Timestamp: d.Timestamp,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "kudzu-webhook")
+	req.Header.Set(EventHeader, d.Event)
+	req.Header.Set(DeliveryHeader, d.ID)
+	req.Header.Set(SignatureHeader, w.Sign(body))
+
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	// read some of the body so the connection can be reused
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 1024*64))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("Receiver responded with %d %s", res.StatusCode, http.StatusText(res.StatusCode))
+	}
+
+	return res.StatusCode, nil
+}
+
+// Redeliver queues a new delivery of the same event as the delivery with the
+// id, to be sent immediately, and returns it
+func Redeliver(id string) (*Delivery, error) {
+	j, err := db.WebhookDelivery(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var d Delivery
+	err = json.Unmarshal(j, &d)
+	if err != nil {
+		return nil, err
+	}
+
+	nid, err := deliveryID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := millis(time.Now())
+	r := &Delivery{
+		ID:          nid,
+		Hook:        d.Hook,
+		URL:         d.URL,
+		Event:       d.Event,
+		Type:        d.Type,
+		ContentID:   d.ContentID,
+		Seq:         d.Seq,
+		Timestamp:   d.Timestamp,
+		Status:      StatusPending,
+		Created:     now,
+		NextAttempt: now,
+		Redelivery:  d.ID,
+	}
+
+	err = db.SetWebhookDelivery(r.ID, r, r.NextAttempt)
+	if err != nil {
+		return nil, err
+	}
+
+	wakeSender()
+
+	return r, nil
+}
+
+// Deliveries returns up to count deliveries, most recent first
+func Deliveries(count int) ([]Delivery, error) {
+	jj, err := db.WebhookDeliveries(count)
+	if err != nil {
+		return nil, err
+	}
+
+	var deliveries []Delivery
+	for _, j := range jj {
+		var d Delivery
+		err := json.Unmarshal(j, &d)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, nil
+}
+
+// prune removes delivered and failed deliveries created before the time
+func prune(before time.Time) error {
+	deliveries, err := Deliveries(-1)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range deliveries {
+		// deliveries stored before they had a created time use their change's
+		created := d.Created
+		if created == 0 {
+			created = d.Timestamp
+		}
+
+		if d.Status == StatusPending || created >= millis(before) {
+			continue
+		}
+
+		err := db.DeleteWebhookDelivery(d.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deliveryID returns a new delivery ID, which sorts in the order deliveries
+// are created
+func deliveryID() (string, error) {
+	r, err := randomHex(4)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%016x", time.Now().UnixNano()) + r, nil
+}
@@ -0,0 +1,190 @@
+// Package webhook sends HTTP requests to URLs configured by admin users when
+// content and uploads change, so external systems such as a static site's CI
+// can react to changes without a hook written for every content type.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/kudzu-cms/kudzu/system/db"
+)
+
+const (
+	// EventCreate is sent when public content is created
+	EventCreate = "create"
+
+	// EventUpdate is sent when public content is updated or replaced
+	EventUpdate = "update"
+
+	// EventDelete is sent when public content or an upload is deleted
+	EventDelete = "delete"
+
+	// EventApprove is sent when pending content is approved, following the
+	// create event for the approved content
+	EventApprove = "approve"
+
+	// EventUpload is sent when a file upload is stored
+	EventUpload = "upload"
+
+	// AllTypes can be used in place of a type name to send events for every
+	// type, including uploads
+	AllTypes = "*"
+
+	// UploadsType is the type name of events for file uploads
+	UploadsType = "__uploads"
+
+	// SignatureHeader holds the hex encoded HMAC-SHA256 of a delivery's body,
+	// keyed with the webhook's secret, as "sha256=<hmac>"
+	SignatureHeader = "X-Kudzu-Signature"
+
+	// EventHeader holds the event of a delivery
+	EventHeader = "X-Kudzu-Event"
+
+	// DeliveryHeader holds the ID of a delivery, which is the same for each
+	// attempt to send it
+	DeliveryHeader = "X-Kudzu-Delivery"
+)
+
+// Events are the events a webhook may be sent for
+var Events = []string{EventCreate, EventUpdate, EventDelete, EventApprove, EventUpload}
+
+// ErrInvalidURL is returned when creating a webhook with a URL which isn't an
+// absolute http or https URL
+var ErrInvalidURL = errors.New("Webhook URL must be an absolute http or https URL")
+
+// events maps the ops recorded in the change log to webhook events
+var events = map[string]string{
+	db.ChangeInsert:  EventCreate,
+	db.ChangeUpdate:  EventUpdate,
+	db.ChangeDelete:  EventDelete,
+	db.ChangeApprove: EventApprove,
+	db.ChangeUpload:  EventUpload,
+}
+
+// Webhook defines a URL to send events to, filtered by type and event. An empty
+// list of types or events matches all of them.
+type Webhook struct {
+	ID      string   `json:"id"`
+	URL     string   `json:"url"`
+	Types   []string `json:"types"`
+	Events  []string `json:"events"`
+	Secret  string   `json:"secret"`
+	Created int64    `json:"created"`
+}
+
+// Payload is the JSON body sent for an event
+type Payload struct {
+	Delivery  string `json:"delivery"`
+	Event     string `json:"event"`
+	Type      string `json:"type"`
+	ID        int    `json:"id"`
+	Seq       uint64 `json:"seq"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// New creates a webhook sending the events to the URL. If secret is empty, a
+// random secret is generated.
+func New(u string, types, events []string, secret string) (*Webhook, error) {
+	parsed, err := url.Parse(u)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, ErrInvalidURL
+	}
+
+	if secret == "" {
+		secret, err = randomHex(20)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Webhook{
+		ID:      id,
+		URL:     u,
+		Types:   types,
+		Events:  events,
+		Secret:  secret,
+		Created: millis(time.Now()),
+	}, nil
+}
+
+// Matches reports whether the webhook should be sent for the event on the type
+func (w *Webhook) Matches(typeName, event string) bool {
+	return contains(w.Types, typeName) && contains(w.Events, event)
+}
+
+// Sign returns the value of the signature header for a body sent to the webhook
+func (w *Webhook) Sign(body []byte) string {
+	return Sign(w.Secret, body)
+}
+
+// Sign returns the value of the signature header for a body, which receivers
+// can compare with their own signature of the body to verify it was sent by
+// kudzu
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// All returns all webhooks
+func All() ([]Webhook, error) {
+	jj, err := db.WebhookAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var hooks []Webhook
+	for _, j := range jj {
+		var w Webhook
+		err := json.Unmarshal(j, &w)
+		if err != nil {
+			return nil, err
+		}
+
+		hooks = append(hooks, w)
+	}
+
+	return hooks, nil
+}
+
+func contains(list []string, v string) bool {
+	if len(list) == 0 {
+		return true
+	}
+
+	for _, s := range list {
+		if s == v || s == AllTypes {
+			return true
+		}
+	}
+
+	return false
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("Failed to generate random value: %s", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}